@@ -5,9 +5,8 @@ import (
 	"github.com/gomarkdown/markdown/ast"
 	"github.com/gomarkdown/markdown/parser"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"sync"
 )
 
@@ -30,7 +29,7 @@ var accounts accountStore
 // This is called once at startup and therefore does not need to be locked. On every restart, this map starts empty and
 // is slowly repopulated as pages are visited.
 func init() {
-	if os.Getenv("ODDMU_WEBFINGER") == "1" {
+	if setting("webfinger") == "1" {
 		accounts.uris = make(map[string]string)
 		useWebfinger = true
 	}
@@ -76,7 +75,7 @@ func accountLink(p *parser.Parser, data []byte, offset int) (int, ast.Node) {
 	uri, ok := accounts.uris[string(account)]
 	defer accounts.RUnlock()
 	if !ok {
-		log.Printf("Looking up %s\n", account)
+		slog.Info("Looking up account", "account", account)
 		uri = "https://" + string(domain) + "/users/" + string(user[1:])
 		accounts.uris[string(account)] = uri // prevent more lookings
 		go lookUpAccountUri(string(account), string(domain))
@@ -96,26 +95,26 @@ func lookUpAccountUri(account, domain string) {
 	uri := "https://" + domain + "/.well-known/webfinger"
 	resp, err := http.Get(uri + "?resource=acct:" + account)
 	if err != nil {
-		log.Printf("Failed to look up %s: %s", account, err)
+		slog.Warn("Failed to look up account", "account", account, "err", err)
 		return
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Failed to read from %s: %s", account, err)
+		slog.Warn("Failed to read account", "account", account, "err", err)
 		return
 	}
 	var wf webFinger
 	err = json.Unmarshal([]byte(body), &wf)
 	if err != nil {
-		log.Printf("Failed to parse the JSON from %s: %s", account, err)
+		slog.Warn("Failed to parse the JSON for account", "account", account, "err", err)
 		return
 	}
 	uri, err = parseWebFinger(body)
 	if err != nil {
-		log.Printf("Could not find profile URI for %s: %s", account, err)
+		slog.Warn("Could not find profile URI", "account", account, "err", err)
 	}
-	log.Printf("Found profile for %s: %s", account, uri)
+	slog.Info("Found profile", "account", account, "uri", uri)
 	accounts.Lock()
 	defer accounts.Unlock()
 	accounts.uris[account] = uri
@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
@@ -10,37 +10,49 @@ import (
 // page. What you type there is appended to the page using the
 // appendHandler.
 func addHandler(w http.ResponseWriter, r *http.Request, name string) {
-	p, err := loadPage(name)
+	s := siteOf(r)
+	p, err := s.loadPage(name)
 	if err != nil {
-		p = &Page{Title: name, Name: name}
+		p = &Page{Title: name, Name: name, site: s}
 	} else {
 		p.handleTitle(false)
 	}
-	renderTemplate(w, p.Dir(), "add", p)
+	s.templates.render(w, p.Dir(), "add", p)
 }
 
 // appendHandler takes the "body" form parameter and appends it. The browser is redirected to the page view. This is
 // similar to the saveHandler.
 func appendHandler(w http.ResponseWriter, r *http.Request, name string) {
 	body := r.FormValue("body")
-	p, err := loadPage(name)
+	var old []byte
+	s := siteOf(r)
+	p, err := s.loadPage(name)
 	if err != nil {
-		p = &Page{Name: name, Body: []byte(body)}
+		p = &Page{Name: name, Body: []byte(body), site: s}
 	} else {
+		old = p.Body
 		p.append([]byte(body))
 	}
 	p.handleTitle(false)
+	err = p.checkSize()
+	if err != nil {
+		slog.Warn("Append refused", "page", name, "err", err)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	err = p.checkSpam(r, old)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	username := author(r, name)
+	p.author = username
 	err = p.save()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	username, _, ok := r.BasicAuth()
-	if ok {
-		log.Println("Save", name, "by", username)
-	} else {
-		log.Println("Save", name)
-	}
+	slog.Info("Save", "page", name, "user", username)
 	if r.FormValue("notify") == "on" {
 		err = p.notify()
 		if err != nil {
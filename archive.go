@@ -4,10 +4,9 @@ import (
 	"archive/zip"
 	"io"
 	"io/fs"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"regexp"
 	"strings"
 )
@@ -17,52 +16,54 @@ import (
 // this is a "separate site"; if the regular expression does not match, this is the "main site" and page names must also
 // not match the regular expression.
 func archiveHandler(w http.ResponseWriter, r *http.Request, name string) {
-	filter := os.Getenv("ODDMU_FILTER")
+	s := siteOf(r)
+	filter := s.dirSetting("filter", path.Dir(name))
 	re, err := regexp.Compile(filter)
 	if err != nil {
-		log.Println("ODDMU_FILTER does not compile:", filter, err)
+		slog.Error("ODDMU_FILTER does not compile", "filter", filter, "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	matches := re.MatchString(name)
-	dir := filepath.Dir(filepath.FromSlash(name))
+	dir := path.Dir(storagePath(name))
 	z := zip.NewWriter(w)
-	err = filepath.Walk(dir, func(fp string, info fs.FileInfo, err error) error {
+	err = fs.WalkDir(s.store, dir, func(fp string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
-			if fp != "." && strings.HasPrefix(filepath.Base(fp), ".") {
-				return filepath.SkipDir
+		if d.IsDir() {
+			if fp != "." && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
 			}
-		} else if !strings.HasPrefix(filepath.Base(fp), ".") &&
-			(matches || !re.MatchString(filepath.ToSlash(fp))) {
+		} else if !strings.HasPrefix(d.Name(), ".") &&
+			(matches || !re.MatchString(fp)) {
 			zf, err := z.Create(fp)
 			if err != nil {
-				log.Println(err)
+				slog.Error("Archive failed", "file", fp, "err", err)
 				return err
 			}
-			f, err := os.Open(fp)
+			f, err := s.store.Open(fp)
 			if err != nil {
-				log.Println(err)
+				slog.Error("Archive failed", "file", fp, "err", err)
 				return err
 			}
+			defer f.Close()
 			_, err = io.Copy(zf, f)
 			if err != nil {
-				log.Println(err)
+				slog.Error("Archive failed", "file", fp, "err", err)
 				return err
 			}
 		}
 		return nil
 	})
 	if err != nil {
-		log.Println(err)
+		slog.Error("Archive failed", "dir", dir, "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	err = z.Close()
 	if err != nil {
-		log.Println(err)
+		slog.Error("Archive failed", "dir", dir, "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"golang.org/x/crypto/bcrypt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// editActions are the actions that change files. If the setting "auth" applies to the directory of a page or file,
+// these actions require a login.
+var editActions = []string{"edit", "save", "add", "append", "upload", "drop", "delete", "rename", "dav"}
+
+// checkAuth reports whether the request may proceed. If the action changes files and the setting "auth" (or the
+// environment variable ODDMU_AUTH) names a password file for the directory of the page or file, the user must log in
+// with a name and password listed in that file. If not, the status 401 asks the browser for a login. The name is the
+// page or file name as passed to the handler; directories end in a slash. A relative filename for the password file is
+// relative to the root directory of the site. The ban lists require an administrator; see [checkAdmin].
+func checkAuth(w http.ResponseWriter, r *http.Request, action, name string) bool {
+	if !slices.Contains(editActions, action) {
+		return true
+	}
+	if isBanList(name) {
+		return checkAdmin(w, r)
+	}
+	fp := authFile(siteOf(r), name)
+	if fp == "" {
+		return true
+	}
+	username, password, ok := r.BasicAuth()
+	if ok && checkPassword(fp, username, password) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="Oddmu", charset="UTF-8"`)
+	http.Error(w, "please log in", http.StatusUnauthorized)
+	return false
+}
+
+// checkAdmin reports whether the request may change the ban lists. The setting "admin" (or the environment variable
+// ODDMU_ADMIN) names a password file and the user must log in with a name and password listed in that file. If not,
+// the status 401 asks the browser for a login. If there is no such setting, the ban lists cannot be changed via the
+// wiki and the status 403 is returned. A relative filename for the password file is relative to the root directory of
+// the site.
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	fp := adminFile(siteOf(r))
+	if fp == "" {
+		http.Error(w, "the ban lists can only be changed by an administrator", http.StatusForbidden)
+		return false
+	}
+	username, password, ok := r.BasicAuth()
+	if ok && checkPassword(fp, username, password) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="Oddmu", charset="UTF-8"`)
+	http.Error(w, "please log in as an administrator", http.StatusUnauthorized)
+	return false
+}
+
+// adminFile returns the password file for administrators, or the empty string if there is none. A relative filename is
+// relative to the root directory of the site.
+func adminFile(s *site) string {
+	fp := s.setting("admin")
+	if fp != "" && !filepath.IsAbs(fp) {
+		fp = s.join(fp)
+	}
+	return fp
+}
+
+// authFile returns the password file for the directory of a page or file, or the empty string if no login is
+// required. A relative filename is relative to the root directory of the site.
+func authFile(s *site, name string) string {
+	fp := s.dirSetting("auth", path.Dir(name))
+	if fp != "" && !filepath.IsAbs(fp) {
+		fp = s.join(fp)
+	}
+	return fp
+}
+
+// authenticatedUser returns the name of the user if the request has a login that is valid for the page or file named
+// by the URL path, as understood by [makeHandler]. Otherwise, the empty string is returned: if there is no login, if
+// the password is wrong or if no password file applies.
+func authenticatedUser(r *http.Request) string {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return ""
+	}
+	m := validPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		return ""
+	}
+	fp := authFile(siteOf(r), m[2])
+	if fp == "" || !checkPassword(fp, username, password) {
+		return ""
+	}
+	return username
+}
+
+// anonymous is the author of changes made without a valid login. See [author].
+const anonymous = "Anonymous"
+
+// author returns the name to record as the author of changes to pages or files: the name of the user if the login is
+// valid for the password file that applies to any of the pages or files (see [checkAuth]), or [anonymous] otherwise.
+// The name the browser sends is not trusted unless the password matches.
+func author(r *http.Request, names ...string) string {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return anonymous
+	}
+	s := siteOf(r)
+	for _, name := range names {
+		fp := authFile(s, name)
+		if isBanList(name) {
+			fp = adminFile(s)
+		}
+		if fp != "" && checkPassword(fp, username, password) {
+			return username
+		}
+	}
+	return anonymous
+}
+
+// checkPassword reports whether the password file has a line for the user and the password matches. Each line has
+// the username, a colon and a bcrypt hash, as created by "htpasswd -B". Other hash formats are not supported. The file
+// is read every time so that changes are effective immediately.
+func checkPassword(fp, username, password string) bool {
+	f, err := os.Open(fp)
+	if err != nil {
+		slog.Error("Cannot read password file", "file", fp, "err", err)
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		user, hash, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || user != username {
+			continue
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err != nil {
+			slog.Warn("Login failed", "user", username, "err", err)
+			return false
+		}
+		return true
+	}
+	slog.Warn("Login failed", "user", username)
+	return false
+}
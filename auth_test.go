@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	cleanup(t, "testdata/auth")
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll("testdata/auth/private", 0755))
+	assert.NoError(t, os.WriteFile("testdata/auth/.htpasswd", []byte("alex:"+string(hash)+"\n"), 0644))
+	useConfig(t, "testdata/auth", `
+[dir."testdata/auth/private"]
+auth = "testdata/auth/.htpasswd"
+`)
+	os.Unsetenv("ODDMU_AUTH")
+	h := makeHandler(saveHandler, true, http.MethodPost)
+	save := func(path, username, password string) int {
+		data := url.Values{}
+		data.Set("body", "Whispers in the dark")
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(data.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if username != "" {
+			r.SetBasicAuth(username, password)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	// public pages need no login
+	assert.Equal(t, http.StatusFound, save("/save/testdata/auth/public", "", ""))
+	// private pages do
+	assert.Equal(t, http.StatusUnauthorized, save("/save/testdata/auth/private/diary", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, save("/save/testdata/auth/private/diary", "alex", "wrong"))
+	assert.Equal(t, http.StatusUnauthorized, save("/save/testdata/auth/private/diary", "berta", "secret"))
+	assert.Equal(t, http.StatusFound, save("/save/testdata/auth/private/diary", "alex", "secret"))
+	// viewing doesn't
+	assert.HTTPStatusCode(t, makeHandler(viewHandler, false, http.MethodGet),
+		http.MethodGet, "/view/testdata/auth/private/diary", nil, http.StatusOK)
+	// renaming a public page into the private directory does
+	assert.HTTPStatusCode(t, makeHandler(renameHandler, true, http.MethodPost),
+		http.MethodPost, "/rename/testdata/auth/public.md", url.Values{"name": {"private/public.md"}},
+		http.StatusUnauthorized)
+}
+
+func TestAuthAdmin(t *testing.T) {
+	cleanup(t, "testdata/admin")
+	t.Cleanup(func() {
+		_ = os.Remove(bannedHostsPage + ".md")
+	})
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll("testdata/admin", 0755))
+	assert.NoError(t, os.WriteFile("testdata/admin/.htpasswd", []byte("alex:"+string(hash)+"\n"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/admin/hosts.md", []byte("# Hosts\n"), 0644))
+	os.Unsetenv("ODDMU_AUTH")
+	assert.True(t, isBanList("banned-hosts"))
+	assert.True(t, isBanList("/banned-content.md"))
+	assert.True(t, isBanList("./banned-hosts.md"))
+	assert.False(t, isBanList("testdata/admin/banned-hosts"))
+	h := makeHandler(saveHandler, true, http.MethodPost)
+	save := func(username, password string) int {
+		data := url.Values{}
+		data.Set("body", "# Banned hosts\n\n192.0.2.1\n")
+		r := httptest.NewRequest(http.MethodPost, "/save/"+bannedHostsPage, strings.NewReader(data.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if username != "" {
+			r.SetBasicAuth(username, password)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	// without an administrator, nobody may change the ban lists
+	t.Setenv("ODDMU_ADMIN", "")
+	assert.Equal(t, http.StatusForbidden, save("", ""))
+	assert.HTTPStatusCode(t, makeHandler(renameHandler, true, http.MethodPost),
+		http.MethodPost, "/rename/testdata/admin/hosts.md", url.Values{"name": {"../../banned-hosts.md"}},
+		http.StatusForbidden)
+	// with an administrator, a login is required
+	t.Setenv("ODDMU_ADMIN", "testdata/admin/.htpasswd")
+	assert.Equal(t, http.StatusUnauthorized, save("", ""))
+	assert.Equal(t, http.StatusUnauthorized, save("alex", "wrong"))
+	assert.NoFileExists(t, bannedHostsPage+".md")
+	assert.Equal(t, http.StatusFound, save("alex", "secret"))
+	assert.FileExists(t, bannedHostsPage+".md")
+}
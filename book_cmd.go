@@ -0,0 +1,568 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	markdownHtml "github.com/gomarkdown/markdown/html"
+	"github.com/google/subcommands"
+	netHtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"html"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type bookCmd struct {
+	format string
+	title  string
+	feed   bool
+}
+
+func (cmd *bookCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.format, "format", "html", "the book format: html or epub")
+	f.StringVar(&cmd.title, "title", "", "the title of the book")
+	f.BoolVar(&cmd.feed, "feed", false, "use the pages linked from the page given, like a feed")
+}
+
+func (*bookCmd) Name() string     { return "book" }
+func (*bookCmd) Synopsis() string { return "render pages as one HTML file or EPUB" }
+func (*bookCmd) Usage() string {
+	return `book [-format html|epub] [-title <title>] [-feed] <page name> ...:
+  Render the pages as the chapters of a book, in the order given.
+  With -feed, only one page is given and the chapters are the pages
+  it links to from list items starting with an asterisk, just like a
+  feed. Links between chapters become links within the book and
+  images are embedded. The book is a single HTML file or an EPUB 3
+  file. It is printed to stdout so you probably want to redirect it:
+
+    oddmu book -format epub -feed index.md > /tmp/book.epub
+`
+}
+
+func (cmd *bookCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if len(f.Args()) == 0 {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitFailure
+	}
+	return bookCli(os.Stdout, defaultSite, cmd.format, cmd.title, cmd.feed, f.Args())
+}
+
+// book is a sequence of pages, the chapters, rendered as one HTML file or as an EPUB.
+type book struct {
+	site *site
+
+	// epub is set if the book is an EPUB. Every chapter is a separate XHTML file and the images are separate files.
+	epub bool
+
+	// Title is the title of the book. It defaults to the title of the page listing the chapters or to the title of
+	// the first chapter.
+	Title string
+
+	// Language is the language of the book, as an ISO 639-1 code, based on the first chapter.
+	Language string
+
+	// Identifier is a URN for the EPUB, based on the title and the names of the chapters.
+	Identifier string
+
+	// Modified is the last modification time of all the chapters, in the format required for EPUB.
+	Modified string
+
+	// Chapters are the chapters in order.
+	Chapters []*bookChapter
+
+	// Images are the images of an EPUB. A single HTML file uses data URLs instead.
+	Images []*bookImage
+
+	// names maps page names to chapters.
+	names map[string]*bookChapter
+
+	// images maps the names of images to the URLs used in the book.
+	images map[string]string
+
+	// missing is the set of local pages and files that are linked from chapters but are not part of the book.
+	missing map[string]bool
+}
+
+// bookChapter is a page in a book. Id is used for the anchor of the chapter and as the prefix for all the heading ids
+// in the chapter so that they don't collide with the heading ids of other chapters. File is the name of the XHTML file
+// in an EPUB.
+type bookChapter struct {
+	Id       string
+	File     string
+	Title    string
+	Language string
+	Html     string
+	page     *Page
+}
+
+// bookImage is an image in an EPUB.
+type bookImage struct {
+	Id   string
+	Href string
+	Type string
+	data []byte
+}
+
+// bookFormats are the formats a book can be written in.
+var bookFormats = map[string]func(w io.Writer, b *book) error{
+	"html": writeBookHtml,
+	"epub": writeBookEpub,
+}
+
+// bookCli writes the book for the pages given as arguments. The names of the pages end in ".md". If feed is set, only
+// one page is given and the chapters are the pages it links to, like a feed. See [feed].
+func bookCli(w io.Writer, s *site, format, title string, feed bool, args []string) subcommands.ExitStatus {
+	write, ok := bookFormats[format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown book format %s\n", format)
+		return subcommands.ExitFailure
+	}
+	names := []string{}
+	for _, name := range args {
+		if !strings.HasSuffix(name, ".md") {
+			fmt.Fprintf(os.Stderr, "%s does not end in '.md'\n", name)
+			return subcommands.ExitFailure
+		}
+		names = append(names, name[0:len(name)-3])
+	}
+	if feed {
+		if len(names) != 1 {
+			fmt.Fprintln(os.Stderr, "The -feed option requires exactly one page")
+			return subcommands.ExitFailure
+		}
+		var err error
+		names, title, err = bookFeed(s, names[0], title)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return subcommands.ExitFailure
+		}
+	}
+	loadLanguages()
+	b, err := newBook(s, format == "epub", title, names)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return subcommands.ExitFailure
+	}
+	err = write(w, b)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Writing the book: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// bookFeed returns the names of the pages linked from a page, like a feed, and the title of the book. If no title is
+// given, the title of the page is used.
+func bookFeed(s *site, name, title string) ([]string, string, error) {
+	p, err := s.loadPage(name)
+	if err != nil {
+		return nil, "", err
+	}
+	p.handleTitle(false)
+	if title == "" {
+		title = p.Title
+	}
+	f := feed(p, time.Now(), 0, 0, feedOptions{})
+	if len(f.Items) == 0 {
+		return nil, "", fmt.Errorf("%s links to no pages", name)
+	}
+	names := []string{}
+	for _, it := range f.Items {
+		names = append(names, it.Name)
+	}
+	return names, title, nil
+}
+
+// newBook loads the pages and renders the chapters of a book.
+func newBook(s *site, epub bool, title string, names []string) (*book, error) {
+	b := &book{site: s, epub: epub, Title: title, names: make(map[string]*bookChapter), images: make(map[string]string),
+		missing: make(map[string]bool)}
+	var modified time.Time
+	h := sha1.New()
+	for i, name := range names {
+		p, err := s.loadPage(name)
+		if err != nil {
+			return nil, err
+		}
+		p.handleTitle(false)
+		if !titleRegexp.Match(p.Body) {
+			p.Body = append([]byte("# "+p.Title+"\n\n"), p.Body...)
+		}
+		c := &bookChapter{Id: fmt.Sprintf("c%d", i+1), Title: p.Title, page: p}
+		if epub {
+			c.File = c.Id + ".xhtml"
+		}
+		ti, err := p.ModTime()
+		if err == nil && ti.After(modified) {
+			modified = ti
+		}
+		b.Chapters = append(b.Chapters, c)
+		b.names[name] = c
+		io.WriteString(h, name+"\n")
+	}
+	if len(b.Chapters) == 0 {
+		return nil, fmt.Errorf("a book needs at least one chapter")
+	}
+	if b.Title == "" {
+		b.Title = b.Chapters[0].Title
+	}
+	lang, _, _ := strings.Cut(language(b.Chapters[0].page.plainText()), ",")
+	if lang == "" {
+		lang = "en"
+	}
+	b.Language = lang
+	io.WriteString(h, b.Title)
+	u := h.Sum(nil)
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	b.Identifier = fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+	b.Modified = modified.UTC().Format("2006-01-02T15:04:05Z")
+	for _, c := range b.Chapters {
+		c.Language = lang
+		c.Html = b.render(c)
+	}
+	if len(b.missing) > 0 {
+		missing := []string{}
+		for name := range b.missing {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		fmt.Fprintf(os.Stderr, "Linked but not in the book: %s\n", strings.Join(missing, ", "))
+	}
+	return b, nil
+}
+
+// bookVoidElements are the HTML elements without content. XHTML requires them to be closed.
+var bookVoidElements = []string{"area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source",
+	"track", "wbr"}
+
+// render returns the HTML of a chapter. Heading ids get the chapter id as prefix. Links to other chapters become links
+// within the book and local images are embedded. Other local links are made absolute if ODDMU_BASE_URL is set.
+// Otherwise, they would be broken and only their text remains. For an EPUB, the HTML is XHTML.
+func (b *book) render(c *bookChapter) string {
+	parser, _ := wikiParser()
+	doc := markdown.Parse(c.page.Body, parser)
+	broken := []*ast.Link{}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if entering {
+			switch v := node.(type) {
+			case *ast.Heading:
+				if v.HeadingID != "" {
+					v.HeadingID = c.Id + "-" + v.HeadingID
+				}
+			case *ast.Link:
+				dest := b.link(c, string(v.Destination))
+				if dest == "" {
+					broken = append(broken, v)
+				}
+				v.Destination = []byte(dest)
+			case *ast.Image:
+				v.Destination = []byte(b.image(c, string(v.Destination)))
+			}
+		}
+		return ast.GoToNext
+	})
+	for _, link := range broken {
+		unlink(link)
+	}
+	flags := markdownHtml.CommonFlags & ^markdownHtml.SmartypantsFractions
+	if b.epub {
+		flags |= markdownHtml.UseXHTML
+	}
+	renderer := markdownHtml.NewRenderer(markdownHtml.RendererOptions{Flags: flags})
+	s := string(c.page.sanitize(markdown.Render(doc, renderer)))
+	if b.epub {
+		s = xhtml(s)
+	}
+	return s
+}
+
+// xhtml returns the HTML as well-formed XHTML. Raw HTML in a page may use void elements such as <br> without closing
+// them, may leave other elements open, and may use named character references XML doesn't know. Therefore, the HTML
+// is parsed and written again. If the HTML cannot be parsed, it is returned unchanged.
+func xhtml(s string) string {
+	context := &netHtml.Node{Type: netHtml.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := netHtml.ParseFragment(strings.NewReader(s), context)
+	if err != nil {
+		return s
+	}
+	w := new(strings.Builder)
+	for _, n := range nodes {
+		writeXhtml(w, n)
+	}
+	return w.String()
+}
+
+// writeXhtml writes a node and its children as XHTML. Text and attribute values are escaped using the entities XML
+// knows. Comments are skipped.
+func writeXhtml(w *strings.Builder, n *netHtml.Node) {
+	switch n.Type {
+	case netHtml.TextNode:
+		w.WriteString(html.EscapeString(n.Data))
+	case netHtml.ElementNode:
+		w.WriteString("<" + n.Data)
+		for _, a := range n.Attr {
+			w.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+		}
+		if n.FirstChild == nil && slices.Contains(bookVoidElements, n.Data) {
+			w.WriteString(" />")
+			return
+		}
+		w.WriteString(">")
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeXhtml(w, c)
+		}
+		w.WriteString("</" + n.Data + ">")
+	}
+}
+
+// local returns the name of the local file a link or image source refers to, or the empty string.
+func (b *book) local(c *bookChapter, dest string) (string, *url.URL) {
+	u, err := url.Parse(dest)
+	if err != nil || u.IsAbs() || u.Host != "" || u.Path == "" {
+		return "", u
+	}
+	if name, ok := strings.CutPrefix(u.Path, "/view/"); ok {
+		return name, u
+	}
+	if strings.HasPrefix(u.Path, "/") {
+		return "", u
+	}
+	return path.Join(path.Dir(c.page.Name), u.Path), u
+}
+
+// unlink replaces a link with its content.
+func unlink(link *ast.Link) {
+	parent := link.GetParent()
+	children := []ast.Node{}
+	for _, child := range parent.GetChildren() {
+		if child != ast.Node(link) {
+			children = append(children, child)
+			continue
+		}
+		for _, content := range link.GetChildren() {
+			content.SetParent(parent)
+			children = append(children, content)
+		}
+	}
+	parent.SetChildren(children)
+}
+
+// link returns the destination of a link within the book, if it refers to a chapter. Links to other local pages and
+// files are recorded as missing. They are made absolute if ODDMU_BASE_URL is set. If not, the empty string is
+// returned since the link would be broken.
+func (b *book) link(c *bookChapter, dest string) string {
+	if strings.HasPrefix(dest, "#") {
+		return b.href(c, dest[1:])
+	}
+	name, u := b.local(c, dest)
+	if name == "" {
+		return dest
+	}
+	if target, ok := b.names[name]; ok {
+		return b.href(target, u.Fragment)
+	}
+	b.missing[name] = true
+	base := siteFeedOptions(b.site).base
+	if base != nil {
+		return base.ResolveReference(&url.URL{Path: "view/" + c.page.Name}).ResolveReference(u).String()
+	}
+	return ""
+}
+
+// href returns the URL for an anchor in a chapter.
+func (b *book) href(c *bookChapter, fragment string) string {
+	id := c.Id
+	if fragment != "" {
+		id += "-" + fragment
+	}
+	if !b.epub {
+		return "#" + id
+	}
+	if fragment == "" {
+		return c.File
+	}
+	return c.File + "#" + id
+}
+
+// image returns the source of an embedded image. A single HTML file uses a data URL. An EPUB adds the image to the
+// files it contains. If the image cannot be read, the source is not changed.
+func (b *book) image(c *bookChapter, dest string) string {
+	name, _ := b.local(c, dest)
+	if name == "" {
+		return dest
+	}
+	if src, ok := b.images[name]; ok {
+		return src
+	}
+	data, err := b.site.store.readFile(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot embed %s: %s\n", name, err)
+		return dest
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(path.Ext(name)), ";")
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	var src string
+	if b.epub {
+		img := &bookImage{Id: fmt.Sprintf("i%d", len(b.Images)+1), Type: mimeType, data: data}
+		img.Href = "images/" + img.Id + path.Ext(name)
+		b.Images = append(b.Images, img)
+		src = img.Href
+	} else {
+		src = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	b.images[name] = src
+	return src
+}
+
+// bookTemplates are the templates for the single HTML file and the files in an EPUB.
+var bookTemplates = template.Must(template.New("html").Parse(`<!DOCTYPE html>
+<html lang="{{.Language}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>{{html .Title}}</title>
+<style>
+html { max-width: 70ch; padding: 1ch; margin: auto; }
+img { max-width: 100%; }
+section { break-before: page; }
+</style>
+</head>
+<body>
+<h1>{{html .Title}}</h1>
+<nav>
+<ol>
+{{range .Chapters}}<li><a href="#{{.Id}}">{{html .Title}}</a></li>
+{{end}}</ol>
+</nav>
+{{range .Chapters}}<section id="{{.Id}}">
+{{.Html}}</section>
+{{end}}</body>
+</html>
+`))
+
+func init() {
+	template.Must(bookTemplates.New("container").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles>
+</container>
+`))
+	template.Must(bookTemplates.New("opf").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id" xml:lang="{{.Language}}">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">{{.Identifier}}</dc:identifier>
+<dc:title>{{html .Title}}</dc:title>
+<dc:language>{{.Language}}</dc:language>
+<meta property="dcterms:modified">{{.Modified}}</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+{{range .Chapters}}<item id="{{.Id}}" href="{{.File}}" media-type="application/xhtml+xml"/>
+{{end}}{{range .Images}}<item id="{{.Id}}" href="{{.Href}}" media-type="{{.Type}}"/>
+{{end}}</manifest>
+<spine>
+{{range .Chapters}}<itemref idref="{{.Id}}"/>
+{{end}}</spine>
+</package>
+`))
+	template.Must(bookTemplates.New("nav").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{.Language}}" xml:lang="{{.Language}}">
+<head>
+<meta charset="UTF-8"/>
+<title>{{html .Title}}</title>
+</head>
+<body>
+<nav epub:type="toc" id="toc">
+<h1>{{html .Title}}</h1>
+<ol>
+{{range .Chapters}}<li><a href="{{.File}}">{{html .Title}}</a></li>
+{{end}}</ol>
+</nav>
+</body>
+</html>
+`))
+	template.Must(bookTemplates.New("chapter").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{.Language}}" xml:lang="{{.Language}}">
+<head>
+<meta charset="UTF-8"/>
+<title>{{html .Title}}</title>
+</head>
+<body>
+<section id="{{.Id}}" epub:type="chapter">
+{{.Html}}</section>
+</body>
+</html>
+`))
+}
+
+// writeBookHtml writes the book as a single HTML file.
+func writeBookHtml(w io.Writer, b *book) error {
+	return bookTemplates.ExecuteTemplate(w, "html", b)
+}
+
+// writeBookEpub writes the book as an EPUB 3 file: a zip file starting with the uncompressed MIME type, the container
+// pointing to the package document, the package document listing all the files, the navigation document with the
+// table of contents, the chapters and the images.
+func writeBookEpub(w io.Writer, b *book) error {
+	z := zip.NewWriter(w)
+	f, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, "application/epub+zip")
+	if err != nil {
+		return err
+	}
+	create := func(name, tmpl string, data any) error {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		return bookTemplates.ExecuteTemplate(f, tmpl, data)
+	}
+	err = create("META-INF/container.xml", "container", b)
+	if err == nil {
+		err = create("OEBPS/content.opf", "opf", b)
+	}
+	if err == nil {
+		err = create("OEBPS/nav.xhtml", "nav", b)
+	}
+	for _, c := range b.Chapters {
+		if err == nil {
+			err = create("OEBPS/"+c.File, "chapter", c)
+		}
+	}
+	for _, img := range b.Images {
+		if err != nil {
+			break
+		}
+		f, err = z.Create("OEBPS/" + img.Href)
+		if err == nil {
+			_, err = f.Write(img.data)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return z.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"github.com/google/subcommands"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+// bookPages writes a book with two chapters listed on an index page.
+func bookPages(t *testing.T) {
+	cleanup(t, "testdata/book")
+	assert.NoError(t, os.MkdirAll("testdata/book", 0755))
+	assert.NoError(t, os.WriteFile("testdata/book/index.md", []byte(`# The Campaign
+
+* [Setting](setting)
+* [Monsters](monsters)
+- [Ignored](ignored)
+`), 0644))
+	assert.NoError(t, os.WriteFile("testdata/book/setting.md", []byte(`# Setting
+
+The "valley" is full of [monsters](monsters#undead). See the [map](#map).
+
+## Map
+
+![The valley](valley.png)
+`), 0644))
+	assert.NoError(t, os.WriteFile("testdata/book/monsters.md", []byte(`# Monsters
+
+## Undead
+
+<img src="ghoul.jpg" alt="Ghoul"><br>
+Hungry <p>always
+
+Back to the [setting](setting) or the [ignored page](ignored).
+`), 0644))
+	assert.NoError(t, os.WriteFile("testdata/book/valley.png", []byte("\x89PNG\r\n\x1a\n"), 0644))
+}
+
+func TestBookCmdHtml(t *testing.T) {
+	bookPages(t)
+	b := new(bytes.Buffer)
+	s := bookCli(b, defaultSite, "html", "", true, []string{"testdata/book/index.md"})
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	h := b.String()
+	assert.Contains(t, h, "<title>The Campaign</title>")
+	assert.Contains(t, h, `<li><a href="#c1">Setting</a></li>`)
+	assert.Contains(t, h, `<section id="c2">`)
+	assert.Contains(t, h, `<h2 id="c2-undead">Undead</h2>`)
+	assert.Contains(t, h, `<a href="#c2-undead">monsters</a>`)
+	assert.Contains(t, h, `<a href="#c1-map">map</a>`)
+	assert.Contains(t, h, `<a href="#c1">setting</a>`)
+	// links to pages that aren't chapters would be broken
+	assert.Contains(t, h, `or the ignored page.`)
+	assert.Contains(t, h, `src="data:image/png;base64,iVBORw0KGgo="`)
+	assert.NotContains(t, h, "Ignored")
+	// with a base URL, they are absolute
+	t.Setenv("ODDMU_BASE_URL", "https://example.org/")
+	b.Reset()
+	s = bookCli(b, defaultSite, "html", "", true, []string{"testdata/book/index.md"})
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.Contains(t, b.String(), `<a href="https://example.org/view/testdata/book/ignored">ignored page</a>`)
+}
+
+func TestBookCmdEpub(t *testing.T) {
+	bookPages(t)
+	b := new(bytes.Buffer)
+	s := bookCli(b, defaultSite, "epub", "Valley", false,
+		[]string{"testdata/book/setting.md", "testdata/book/monsters.md"})
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert.NoError(t, err)
+	// the MIME type comes first, uncompressed
+	assert.Equal(t, "mimetype", z.File[0].Name)
+	assert.Equal(t, zip.Store, z.File[0].Method)
+	files := make(map[string]string)
+	for _, f := range z.File {
+		r, err := f.Open()
+		assert.NoError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		files[f.Name] = string(data)
+		// all the XML files are well-formed
+		if f.Name != "mimetype" && f.Name != "OEBPS/images/i1.png" {
+			d := xml.NewDecoder(bytes.NewReader(data))
+			for err == nil {
+				_, err = d.Token()
+			}
+			assert.True(t, errors.Is(err, io.EOF), "%s: %s", f.Name, err)
+		}
+	}
+	assert.Equal(t, "application/epub+zip", files["mimetype"])
+	assert.Contains(t, files["META-INF/container.xml"], `full-path="OEBPS/content.opf"`)
+	assert.Contains(t, files["OEBPS/content.opf"], "<dc:title>Valley</dc:title>")
+	assert.Contains(t, files["OEBPS/content.opf"], `<item id="i1" href="images/i1.png" media-type="image/png"/>`)
+	assert.Contains(t, files["OEBPS/content.opf"], "<itemref idref=\"c1\"/>\n<itemref idref=\"c2\"/>")
+	assert.Contains(t, files["OEBPS/nav.xhtml"], `<a href="c2.xhtml">Monsters</a>`)
+	assert.Contains(t, files["OEBPS/c1.xhtml"], `<a href="c2.xhtml#c2-undead">monsters</a>`)
+	assert.Contains(t, files["OEBPS/c1.xhtml"], `<img src="images/i1.png" alt="The valley" />`)
+	assert.Contains(t, files["OEBPS/c1.xhtml"], "“valley”")
+	assert.Contains(t, files["OEBPS/c2.xhtml"], `<a href="c1.xhtml">setting</a>`)
+	assert.Contains(t, files["OEBPS/c2.xhtml"], `or the ignored page.`)
+	assert.Contains(t, files["OEBPS/c2.xhtml"], `<img src="ghoul.jpg" alt="Ghoul" /><br />`)
+	assert.Contains(t, files["OEBPS/c2.xhtml"], "<p>always</p>")
+	assert.Equal(t, "\x89PNG\r\n\x1a\n", files["OEBPS/images/i1.png"])
+}
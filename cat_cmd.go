@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/google/subcommands"
+	"github.com/muesli/reflow/ansi"
+	"github.com/muesli/reflow/wordwrap"
+	"html"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+type catCmd struct {
+	width int
+}
+
+func (*catCmd) Name() string     { return "cat" }
+func (*catCmd) Synopsis() string { return "render a page for the terminal" }
+func (*catCmd) Usage() string {
+	return `cat [-width <n>] <page name> ...:
+  Render one or more pages for the terminal: paragraphs are wrapped,
+  emphasis is shown in bold and italics, links get numbered footnotes
+  and tables are laid out in columns. If standard output is not a
+  terminal, plain text is printed.
+  Use a single - to read Markdown from stdin.
+`
+}
+
+func (cmd *catCmd) SetFlags(f *flag.FlagSet) {
+	f.IntVar(&cmd.width, "width", 72, "the maximum width of the lines")
+}
+
+func (cmd *catCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if len(f.Args()) == 0 {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	return catCli(os.Stdout, cmd.width, !isTerminal(os.Stdout), f.Args())
+}
+
+// isTerminal reports whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// catCli runs the cat command on the command line. If plain is set, no ANSI escape codes are used. It is used here
+// with an io.Writer for easy testing.
+func catCli(w io.Writer, width int, plain bool, args []string) subcommands.ExitStatus {
+	if len(args) == 1 && args[0] == "-" {
+		body, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read from stdin: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		p := &Page{Name: "stdin", Body: body}
+		return p.printText(w, width, plain)
+	}
+	for i, name := range args {
+		if !strings.HasSuffix(name, ".md") {
+			fmt.Fprintf(os.Stderr, "%s does not end in '.md'\n", name)
+			return subcommands.ExitFailure
+		}
+		name = name[0 : len(name)-3]
+		p, err := loadPage(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot load %s: %s\n", name, err)
+			return subcommands.ExitFailure
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		status := p.printText(w, width, plain)
+		if status != subcommands.ExitSuccess {
+			return status
+		}
+	}
+	return subcommands.ExitSuccess
+}
+
+func (p *Page) printText(w io.Writer, width int, plain bool) subcommands.ExitStatus {
+	_, err := io.WriteString(w, p.renderText(width, plain))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write to stdout: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// termIndent is the indentation of the text below the headings, like in a man page.
+const termIndent = "    "
+
+// termWriter renders the Markdown AST for the terminal. Headings start at the left margin, everything else is
+// indented and wrapped. Links are numbered and their destinations are listed at the end, like footnotes.
+type termWriter struct {
+	b strings.Builder
+
+	// width is the maximum width of the lines, including the indentation.
+	width int
+
+	// plain is set if no ANSI escape codes are used for bold, italics, strike-through and underlines.
+	plain bool
+
+	// links are the link destinations, numbered from one.
+	links []string
+}
+
+// renderText renders the Page.Body for the terminal. Lines are wrapped at the width given. See [termWriter].
+func (p *Page) renderText(width int, plain bool) string {
+	parser, _ := wikiParser()
+	w := &termWriter{width: width, plain: plain}
+	w.block(markdown.Parse(p.Body, parser), termIndent)
+	for i, dest := range w.links {
+		fmt.Fprintf(&w.b, "%s[%d] %s\n", termIndent, i+1, dest)
+	}
+	return strings.TrimRight(w.b.String(), "\n") + "\n"
+}
+
+// block writes a block node. The indent is the indentation of the text.
+func (w *termWriter) block(node ast.Node, indent string) {
+	switch v := node.(type) {
+	case *ast.Document:
+		for _, child := range v.Children {
+			w.block(child, indent)
+		}
+	case *ast.Heading:
+		text := w.style(w.inline(v), "1", "22")
+		if v.Level == 1 {
+			text = w.style(text, "4", "24")
+		}
+		outdent := strings.TrimPrefix(indent, termIndent)
+		w.wrap(text, outdent, outdent)
+	case *ast.Paragraph:
+		w.wrap(w.inline(v), indent, indent)
+		w.b.WriteString("\n")
+	case *ast.List:
+		w.list(v, indent)
+		w.b.WriteString("\n")
+	case *ast.BlockQuote:
+		for _, child := range v.Children {
+			w.block(child, indent+termIndent)
+		}
+	case *ast.CodeBlock:
+		for _, line := range strings.Split(strings.TrimRight(string(v.Literal), "\n"), "\n") {
+			w.b.WriteString(strings.TrimRight(indent+termIndent+line, " ") + "\n")
+		}
+		w.b.WriteString("\n")
+	case *ast.Table:
+		w.table(v, indent)
+		w.b.WriteString("\n")
+	case *ast.HorizontalRule:
+		w.b.WriteString(indent + strings.Repeat("-", max(w.width-len(indent), 3)) + "\n\n")
+	default:
+		// raw HTML and the like are skipped
+	}
+}
+
+// wrap writes text wrapped at the width. The first line starts with the first prefix, the other lines start with the
+// rest prefix. Both prefixes should have the same width.
+func (w *termWriter) wrap(text, first, rest string) {
+	n := max(w.width-ansi.PrintableRuneWidth(rest), 1)
+	for i, line := range strings.Split(wordwrap.String(text, n), "\n") {
+		if i == 0 {
+			w.b.WriteString(first + line + "\n")
+		} else {
+			w.b.WriteString(rest + line + "\n")
+		}
+	}
+}
+
+// list writes the items of a list with a hanging indent. Nested lists are indented further.
+func (w *termWriter) list(l *ast.List, indent string) {
+	n := max(l.Start, 1)
+	for _, child := range l.Children {
+		item, ok := child.(*ast.ListItem)
+		if !ok {
+			continue
+		}
+		bullet := "* "
+		if l.ListFlags&ast.ListTypeOrdered != 0 {
+			bullet = fmt.Sprintf("%d. ", n)
+			n++
+		}
+		first := indent + bullet
+		rest := indent + strings.Repeat(" ", len(bullet))
+		for _, c := range item.Children {
+			switch v := c.(type) {
+			case *ast.List:
+				w.list(v, rest)
+			case *ast.Paragraph:
+				w.wrap(w.inline(v), first, rest)
+				first = rest
+			default:
+				w.block(v, rest)
+			}
+		}
+	}
+}
+
+// table writes a table with the columns aligned. The cells of the header are bold.
+func (w *termWriter) table(t *ast.Table, indent string) {
+	rows := [][]string{}
+	aligns := []ast.CellAlignFlags{}
+	widths := []int{}
+	header := false
+	ast.WalkFunc(t, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch v := node.(type) {
+		case *ast.TableHeader:
+			header = entering
+		case *ast.TableRow:
+			if entering {
+				rows = append(rows, []string{})
+			}
+		case *ast.TableCell:
+			if entering {
+				text := w.inline(v)
+				if header {
+					text = w.style(text, "1", "22")
+				}
+				i := len(rows[len(rows)-1])
+				if i >= len(widths) {
+					widths = append(widths, 0)
+					aligns = append(aligns, v.Align)
+				}
+				widths[i] = max(widths[i], ansi.PrintableRuneWidth(text))
+				rows[len(rows)-1] = append(rows[len(rows)-1], text)
+				return ast.SkipChildren
+			}
+		}
+		return ast.GoToNext
+	})
+	for _, row := range rows {
+		line := indent
+		for i, cell := range row {
+			if i > 0 {
+				line += "  "
+			}
+			padding := strings.Repeat(" ", widths[i]-ansi.PrintableRuneWidth(cell))
+			if aligns[i] == ast.TableAlignmentRight {
+				line += padding + cell
+			} else {
+				line += cell + padding
+			}
+		}
+		w.b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+}
+
+// inline returns the text of a node's children. Links get the number of their footnote unless they are hashtags or
+// the link text is the destination.
+func (w *termWriter) inline(node ast.Node) string {
+	var b strings.Builder
+	for _, child := range node.GetChildren() {
+		switch v := child.(type) {
+		case *ast.Text:
+			b.WriteString(html.UnescapeString(strings.ReplaceAll(string(v.Literal), "\n", " ")))
+		case *ast.Code:
+			b.WriteString(string(v.Literal))
+		case *ast.Hardbreak:
+			b.WriteString("\n")
+		case *ast.Softbreak:
+			b.WriteString(" ")
+		case *ast.Emph:
+			b.WriteString(w.style(w.inline(v), "3", "23"))
+		case *ast.Strong:
+			b.WriteString(w.style(w.inline(v), "1", "22"))
+		case *ast.Del:
+			b.WriteString(w.style(w.inline(v), "9", "29"))
+		case *ast.Link:
+			label := w.inline(v)
+			b.WriteString(label)
+			if !slices.Contains(v.AdditionalAttributes, `class="tag"`) && label != string(v.Destination) {
+				fmt.Fprintf(&b, "[%d]", w.footnote(string(v.Destination)))
+			}
+		case *ast.Image:
+			label := w.inline(v)
+			if label == "" {
+				label = "image"
+			}
+			fmt.Fprintf(&b, "%s[%d]", label, w.footnote(string(v.Destination)))
+		case *ast.HTMLSpan:
+			// skipped
+		default:
+			if leaf := child.AsLeaf(); leaf != nil {
+				b.WriteString(string(leaf.Literal))
+			} else {
+				b.WriteString(w.inline(child))
+			}
+		}
+	}
+	return b.String()
+}
+
+// footnote returns the number of the footnote for a link destination. Links to the same destination share a number.
+func (w *termWriter) footnote(dest string) int {
+	i := slices.Index(w.links, dest)
+	if i == -1 {
+		w.links = append(w.links, dest)
+		i = len(w.links) - 1
+	}
+	return i + 1
+}
+
+// style returns the text surrounded by the ANSI escape codes to turn an attribute on and off, unless the output is
+// plain.
+func (w *termWriter) style(text, on, off string) string {
+	if w.plain {
+		return text
+	}
+	return "\x1b[" + on + "m" + text + "\x1b[" + off + "m"
+}
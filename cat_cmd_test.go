@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"github.com/google/subcommands"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestCatCmd(t *testing.T) {
+	cleanup(t, "testdata/cat")
+	assert.NoError(t, os.MkdirAll("testdata/cat", 0755))
+	assert.NoError(t, os.WriteFile("testdata/cat/bees.md", []byte(`# Bees
+
+Bees make *honey* and **wax**. They live in a [hive](hive) or in a [hollow
+tree](https://en.wikipedia.org/wiki/Tree) and they [dance](waggle-dance). #Bees
+
+## Kinds
+
+1. Honey bees, see [hive](hive)
+2. Bumblebees
+   * fuzzy
+   * loud
+
+| Name   | Legs |
+|--------|-----:|
+| Bee    | 6    |
+| Spider | 8    |
+`), 0644))
+	b := new(bytes.Buffer)
+	s := catCli(b, 40, true, []string{"testdata/cat/bees.md"})
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.Equal(t, `Bees
+    Bees make honey and wax. They live
+    in a hive or in a hollow tree[1] and
+    they dance[2]. #Bees
+
+Kinds
+    1. Honey bees, see hive
+    2. Bumblebees
+       * fuzzy
+       * loud
+
+    Name    Legs
+    Bee        6
+    Spider     8
+
+    [1] https://en.wikipedia.org/wiki/Tree
+    [2] waggle-dance
+`, b.String())
+	b.Reset()
+	s = catCli(b, 40, false, []string{"testdata/cat/bees.md"})
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.Contains(t, b.String(), "\x1b[4m\x1b[1mBees\x1b[22m\x1b[24m\n")
+	assert.Contains(t, b.String(), "Bees make \x1b[3mhoney\x1b[23m and \x1b[1mwax\x1b[22m.")
+	assert.Contains(t, b.String(), "    \x1b[1mName\x1b[22m    \x1b[1mLegs\x1b[22m\n")
+}
+
+func TestCatCmdUsage(t *testing.T) {
+	cmd := &catCmd{}
+	f := flag.NewFlagSet("cat", flag.ContinueOnError)
+	cmd.SetFlags(f)
+	assert.NoError(t, f.Parse([]string{"-width", "40"}))
+	assert.Equal(t, subcommands.ExitUsageError, cmd.Execute(context.Background(), f))
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"path"
 	"regexp"
 	"strings"
@@ -24,25 +24,25 @@ func (p *Page) notify() error {
 	link := "* [" + p.Title + "](" + esc + ")\n"
 	re := regexp.MustCompile(`(?m)^\* \[[^\]]+\]\(` + esc + `\)\n`)
 	dir := p.Dir()
-	err := addLinkWithDate(path.Join(dir, "changes"), link, re)
+	err := p.wiki().addLinkWithDate(path.Join(dir, "changes"), link, re)
 	if err != nil {
-		log.Printf("Updating changes in %s failed: %s", dir, err)
+		slog.Warn("Updating changes failed", "dir", dir, "err", err)
 		return err
 	}
 	if p.IsBlog() {
 		// Add to the index only if the blog post is for the current year
 		if strings.HasPrefix(p.Base(), time.Now().Format("2006")) {
-			err := addLink(path.Join(dir, "index"), true, link, re)
+			err := p.wiki().addLink(path.Join(dir, "index"), true, link, re)
 			if err != nil {
-				log.Printf("Updating index in %s failed: %s", dir, err)
+				slog.Warn("Updating index failed", "dir", dir, "err", err)
 				return err
 			}
 		}
 		p.renderHtml() // to set hashtags
 		for _, hashtag := range p.Hashtags {
-			err := addLink(path.Join(dir, hashtag), false, link, re)
+			err := p.wiki().addLink(path.Join(dir, hashtag), false, link, re)
 			if err != nil {
-				log.Printf("Updating hashtag %s in %s failed: %s", hashtag, dir, err)
+				slog.Warn("Updating hashtag failed", "hashtag", hashtag, "dir", dir, "err", err)
 				return err
 			}
 		}
@@ -53,13 +53,13 @@ func (p *Page) notify() error {
 // addLinkWithDate adds the link to a page, with date header for today. If a match already exists, it is removed. If
 // this leaves a date header without any links, it is removed as well. If a list is found, the link is added at the top
 // of the list. Lists must use the asterisk, not the minus character.
-func addLinkWithDate(name, link string, re *regexp.Regexp) error {
+func (s *site) addLinkWithDate(name, link string, re *regexp.Regexp) error {
 	date := time.Now().Format(time.DateOnly)
 	org := ""
-	p, err := loadPage(name)
+	p, err := s.loadPage(name)
 	if err != nil {
 		// create a new page
-		p = &Page{Name: name, Body: []byte("# Changes\n\n## " + date + "\n" + link)}
+		p = &Page{Name: name, Body: []byte("# Changes\n\n## " + date + "\n" + link), site: s}
 	} else {
 		org = string(p.Body)
 		// remove the old match, if one exists
@@ -142,11 +142,11 @@ func addLinkWithDate(name, link string, re *regexp.Regexp) error {
 
 // addLink adds a link to a named page, if the page exists and doesn't contain the link. If the link exists but with a
 // different title, the title is fixed.
-func addLink(name string, mandatory bool, link string, re *regexp.Regexp) error {
-	p, err := loadPage(name)
+func (s *site) addLink(name string, mandatory bool, link string, re *regexp.Regexp) error {
+	p, err := s.loadPage(name)
 	if err != nil {
 		if mandatory {
-			p = &Page{Name: name, Body: []byte(link)}
+			p = &Page{Name: name, Body: []byte(link), site: s}
 			return p.save()
 		} else {
 			// Skip non-existing files: no error
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// configFile is the default name of the configuration file in the root directory. As it starts with a dot, it
+// cannot be viewed, edited or replaced via the web. The environment variable ODDMU_CONFIG names a different file.
+const configFile = ".oddmu.toml"
+
+// settings are the names of all the settings. For every setting there is an environment variable with the prefix
+// "ODDMU_", e.g. the setting "filter" and the environment variable ODDMU_FILTER. See [setting].
+var settings = []string{
+	"address", "port", "tls_cert", "tls_key", "acme_domains", "acme_cache", "acme_email", "acme_directory",
+	"log_level", "log_format", "metrics", "languages", "webfinger", "base_url", "feed_summary", "feed_enclosure",
+	"filter", "auth", "sanitize", "embed_hosts", "csp", "file_policy", "rate_limit_ip", "rate_limit_user",
+	"max_request_size", "max_page_size", "max_upload_size", "quota", "max_links", "hosts", "storage", "dav",
+	"gemini_port", "gemini_cert", "gemini_key", "trusted_proxies", "admin",
+}
+
+// dirSettings are the settings that can be overridden for a directory and its subdirectories. See [dirSetting].
+var dirSettings = []string{"filter", "languages", "auth"}
+
+// configStore controls access to the settings read from the configuration file. Make sure to lock and unlock as
+// appropriate. The file is read when a setting is needed for the first time and again after reset.
+type configStore struct {
+	sync.RWMutex
+
+	// file is the name of the configuration file. If empty, [configPath] is used.
+	file string
+
+	// loaded is set once the configuration file has been read.
+	loaded bool
+
+	// global maps settings to their values.
+	global map[string]string
+
+	// dirs maps directories (without trailing slash) to their settings.
+	dirs map[string]map[string]string
+}
+
+var config configStore
+
+// configPath returns the name of the configuration file: the environment variable ODDMU_CONFIG or configFile in the
+// root directory of the default site.
+func configPath() string {
+	fp := os.Getenv("ODDMU_CONFIG")
+	if fp == "" {
+		return defaultSite.join(configFile)
+	}
+	return fp
+}
+
+// path returns the name of the configuration file.
+func (c *configStore) path() string {
+	if c.file == "" {
+		return configPath()
+	}
+	return c.file
+}
+
+// load reads the configuration file unless this has already happened. Errors are logged and the file is ignored.
+func (c *configStore) load() {
+	c.RLock()
+	loaded := c.loaded
+	c.RUnlock()
+	if loaded {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.loaded {
+		return
+	}
+	var err error
+	c.global, c.dirs, err = readConfig(c.path())
+	if err != nil {
+		slog.Error("Cannot read configuration", "file", c.path(), "err", err)
+	}
+	c.loaded = true
+}
+
+// reset makes sure that the configuration file is read again when a setting is needed.
+func (c *configStore) reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.loaded = false
+}
+
+// envName returns the name of the environment variable for a setting.
+func envName(key string) string {
+	return "ODDMU_" + strings.ToUpper(key)
+}
+
+// lookup returns the value of a setting and whether it was set at all. The environment variable takes precedence over
+// the configuration file.
+func (c *configStore) lookup(key string) (string, bool) {
+	v, ok := os.LookupEnv(envName(key))
+	if ok {
+		return v, true
+	}
+	c.load()
+	c.RLock()
+	defer c.RUnlock()
+	v, ok = c.global[key]
+	return v, ok
+}
+
+// dirLookup returns the value of a setting for a directory. The environment variable takes precedence over the section
+// for the directory in the configuration file, which takes precedence over the sections of its parent directories,
+// which take precedence over the global settings.
+func (c *configStore) dirLookup(key, dir string) string {
+	v, ok := os.LookupEnv(envName(key))
+	if ok {
+		return v
+	}
+	c.load()
+	c.RLock()
+	defer c.RUnlock()
+	dir = path.Clean(filepath.ToSlash(dir))
+	for dir != "." && dir != "/" {
+		v, ok = c.dirs[dir][key]
+		if ok {
+			return v
+		}
+		dir = path.Dir(dir)
+	}
+	return c.global[key]
+}
+
+// lookupSetting returns the value of a setting and whether it was set at all. The environment variable takes
+// precedence over the configuration file.
+func lookupSetting(key string) (string, bool) {
+	return config.lookup(key)
+}
+
+// setting returns the value of a setting or the empty string if it isn't set. See [lookupSetting].
+func setting(key string) string {
+	v, _ := lookupSetting(key)
+	return v
+}
+
+// dirSetting returns the value of a setting for a directory. See [configStore.dirLookup].
+func dirSetting(key, dir string) string {
+	return config.dirLookup(key, dir)
+}
+
+// readConfig reads a configuration file and returns the global settings and the settings per directory. If the file
+// doesn't exist, there are no settings.
+func readConfig(fp string) (map[string]string, map[string]map[string]string, error) {
+	b, err := os.ReadFile(fp)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(map[string]string), make(map[string]map[string]string), nil
+	} else if err != nil {
+		return make(map[string]string), make(map[string]map[string]string), err
+	}
+	return parseConfig(b)
+}
+
+// parseConfig parses a configuration file. The format is a subset of TOML: empty lines and comments starting with
+// "#" are skipped, "key = value" lines define settings, and "[dir.name]" lines start the section for a directory. The
+// name of the directory may be quoted. Values are strings (quoted with double or single quotes), integers, booleans or
+// arrays of these, all on a single line. The booleans true and false result in "1" and "" and arrays result in
+// comma-separated values, just like the environment variables.
+func parseConfig(b []byte) (map[string]string, map[string]map[string]string, error) {
+	global := make(map[string]string)
+	dirs := make(map[string]map[string]string)
+	section := global
+	inDir := false
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			dir, err := parseSection(line)
+			if err != nil {
+				return global, dirs, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if dirs[dir] == nil {
+				dirs[dir] = make(map[string]string)
+			}
+			section = dirs[dir]
+			inDir = true
+			continue
+		}
+		key, s, ok := strings.Cut(line, "=")
+		if !ok {
+			return global, dirs, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		key = strings.TrimSpace(key)
+		if !slices.Contains(settings, key) {
+			return global, dirs, fmt.Errorf("line %d: unknown setting %s", i+1, key)
+		}
+		if inDir && !slices.Contains(dirSettings, key) {
+			return global, dirs, fmt.Errorf("line %d: %s cannot be set for a directory", i+1, key)
+		}
+		v, rest, err := parseValue(strings.TrimSpace(s))
+		if err != nil {
+			return global, dirs, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if !isComment(rest) {
+			return global, dirs, fmt.Errorf("line %d: unexpected %s", i+1, rest)
+		}
+		section[key] = v
+	}
+	return global, dirs, nil
+}
+
+// parseSection parses a line like "[dir.name]" or `[dir."name"]` and returns the name of the directory.
+func parseSection(line string) (string, error) {
+	s, ok := strings.CutPrefix(line, "[dir.")
+	if !ok {
+		return "", fmt.Errorf("expected [dir.name] but got %s", line)
+	}
+	var dir string
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		var err error
+		dir, s, err = parseString(s)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		i := strings.Index(s, "]")
+		if i == -1 {
+			return "", fmt.Errorf("expected [dir.name] but got %s", line)
+		}
+		dir, s = strings.TrimSpace(s[:i]), s[i:]
+	}
+	s, ok = strings.CutPrefix(strings.TrimSpace(s), "]")
+	if !ok || !isComment(s) {
+		return "", fmt.Errorf("expected [dir.name] but got %s", line)
+	}
+	dir = path.Clean(strings.Trim(dir, "/"))
+	if dir == "." || isHiddenName(dir) {
+		return "", fmt.Errorf("invalid directory %s", dir)
+	}
+	return dir, nil
+}
+
+// parseValue parses the value at the beginning of s and returns it, together with the rest of s.
+func parseValue(s string) (string, string, error) {
+	switch {
+	case s == "":
+		return "", "", errors.New("value missing")
+	case s[0] == '"' || s[0] == '\'':
+		return parseString(s)
+	case s[0] == '[':
+		values := []string{}
+		s = strings.TrimSpace(s[1:])
+		for !strings.HasPrefix(s, "]") {
+			v, rest, err := parseValue(s)
+			if err != nil {
+				return "", "", err
+			}
+			values = append(values, v)
+			s = strings.TrimSpace(rest)
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimSpace(s[1:])
+			} else if !strings.HasPrefix(s, "]") {
+				return "", "", errors.New("array not closed")
+			}
+		}
+		return strings.Join(values, ","), s[1:], nil
+	}
+	i := strings.IndexAny(s, " \t#,]")
+	if i == -1 {
+		i = len(s)
+	}
+	token, rest := s[:i], s[i:]
+	switch token {
+	case "true":
+		return "1", rest, nil
+	case "false":
+		return "", rest, nil
+	}
+	n, err := strconv.ParseInt(strings.ReplaceAll(token, "_", ""), 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("expected a string, an integer or a boolean but got %s", token)
+	}
+	return strconv.FormatInt(n, 10), rest, nil
+}
+
+// parseString parses the quoted string at the beginning of s and returns it, together with the rest of s. Strings in
+// double quotes may contain escape sequences like "\"" and "\\". Strings in single quotes are taken literally.
+func parseString(s string) (string, string, error) {
+	if s[0] == '\'' {
+		i := strings.Index(s[1:], "'")
+		if i == -1 {
+			return "", "", errors.New("string not closed")
+		}
+		return s[1 : i+1], s[i+2:], nil
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("cannot parse %s: %w", s[:i+1], err)
+			}
+			return v, s[i+1:], nil
+		}
+	}
+	return "", "", errors.New("string not closed")
+}
+
+// isComment reports whether s is empty or a comment, after skipping whitespace.
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+// writeConfig writes the effective settings in the format of the configuration file. Settings taken from environment
+// variables have a comment saying so.
+func writeConfig(w io.Writer) error {
+	for _, key := range settings {
+		v, ok := lookupSetting(key)
+		if ok {
+			err := writeSetting(w, key, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	config.load()
+	config.RLock()
+	dirs := []string{}
+	for dir := range config.dirs {
+		dirs = append(dirs, dir)
+	}
+	config.RUnlock()
+	slices.Sort(dirs)
+	for _, dir := range dirs {
+		_, err := fmt.Fprintf(w, "\n[dir.%s]\n", strconv.Quote(dir))
+		if err != nil {
+			return err
+		}
+		for _, key := range dirSettings {
+			config.RLock()
+			_, ok := config.dirs[dir][key]
+			config.RUnlock()
+			if ok {
+				err := writeSetting(w, key, dirSetting(key, dir))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeSetting writes a setting in the format of the configuration file.
+func writeSetting(w io.Writer, key, v string) error {
+	var err error
+	if _, ok := os.LookupEnv(envName(key)); ok {
+		_, err = fmt.Fprintf(w, "%s = %s # from %s\n", key, strconv.Quote(v), envName(key))
+	} else {
+		_, err = fmt.Fprintf(w, "%s = %s\n", key, strconv.Quote(v))
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"io"
+	"os"
+)
+
+type configCmd struct {
+}
+
+func (*configCmd) SetFlags(f *flag.FlagSet) {
+}
+
+func (*configCmd) Name() string     { return "config" }
+func (*configCmd) Synopsis() string { return "print the effective settings" }
+func (*configCmd) Usage() string {
+	return `config:
+  Print the effective settings, taking both the configuration file
+  and the environment variables into account.
+`
+}
+
+func (cmd *configCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	return configCli(os.Stdout)
+}
+
+func configCli(w io.Writer) subcommands.ExitStatus {
+	_, _, err := readConfig(configPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, configPath(), err)
+		return subcommands.ExitFailure
+	}
+	err = writeConfig(w)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
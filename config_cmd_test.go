@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"github.com/google/subcommands"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestConfigCmd(t *testing.T) {
+	cleanup(t, "testdata/config-cmd")
+	useConfig(t, "testdata/config-cmd", `
+port = 8080
+webfinger = true
+[dir.knochentanz]
+languages = "de"
+`)
+	os.Unsetenv("ODDMU_WEBFINGER")
+	os.Unsetenv("ODDMU_LANGUAGES")
+	t.Setenv("ODDMU_PORT", "8081")
+	b := new(bytes.Buffer)
+	s := configCli(b)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.Contains(t, b.String(), `port = "8081" # from ODDMU_PORT`)
+	assert.Contains(t, b.String(), `webfinger = "1"`)
+	assert.Contains(t, b.String(), `[dir."knochentanz"]
+languages = "de"`)
+	// the output can be read again
+	global, dirs, err := parseConfig(b.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "8081", global["port"])
+	assert.Equal(t, "de", dirs["knochentanz"]["languages"])
+}
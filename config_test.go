@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useConfig writes a configuration file to the directory and uses it until the test is done.
+func useConfig(t *testing.T, dir, s string) {
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	fp := filepath.Join(dir, "oddmu.toml")
+	assert.NoError(t, os.WriteFile(fp, []byte(s), 0644))
+	t.Setenv("ODDMU_CONFIG", fp)
+	config.reset()
+	t.Cleanup(config.reset)
+}
+
+func TestParseConfig(t *testing.T) {
+	global, dirs, err := parseConfig([]byte(`# Example
+port = 8_080
+languages = ["en", 'de'] # comment
+webfinger = true
+metrics = false
+filter = "^(secret|private)/\\w"
+quota = 'guests=50M'
+
+[dir.knochentanz]
+languages = "de"
+[dir."Alex Schroeder/"] # with a space
+auth = ".htpasswd"
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "8080", global["port"])
+	assert.Equal(t, "en,de", global["languages"])
+	assert.Equal(t, "1", global["webfinger"])
+	assert.Equal(t, "", global["metrics"])
+	assert.Equal(t, `^(secret|private)/\w`, global["filter"])
+	assert.Equal(t, "guests=50M", global["quota"])
+	assert.Equal(t, "de", dirs["knochentanz"]["languages"])
+	assert.Equal(t, ".htpasswd", dirs["Alex Schroeder"]["auth"])
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, s := range []string{
+		"port",
+		"colour = \"red\"",
+		"port = \"8080",
+		"port = 80 80",
+		"port = eighty",
+		"languages = [\"en\", \"de\"",
+		"[knochentanz]",
+		"[dir.]",
+		"[dir..secret]",
+		"[dir.knochentanz]\nport = 8080",
+	} {
+		_, _, err := parseConfig([]byte(s))
+		assert.Error(t, err, s)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	cleanup(t, "testdata/config")
+	useConfig(t, "testdata/config", `
+filter = "^secret/"
+languages = "en,de"
+[dir.knochentanz]
+languages = "de"
+[dir.knochentanz/sub]
+filter = "^knochentanz/sub/secret/"
+`)
+	os.Unsetenv("ODDMU_FILTER")
+	os.Unsetenv("ODDMU_LANGUAGES")
+	os.Unsetenv("ODDMU_CSP")
+	assert.Equal(t, "^secret/", setting("filter"))
+	assert.Equal(t, "^secret/", dirSetting("filter", "knochentanz"))
+	assert.Equal(t, "^knochentanz/sub/secret/", dirSetting("filter", "knochentanz/sub/deeper"))
+	assert.Equal(t, "de", dirSetting("languages", "knochentanz/sub"))
+	assert.Equal(t, "en,de", dirSetting("languages", "."))
+	_, ok := lookupSetting("csp")
+	assert.False(t, ok)
+	// environment variables take precedence
+	t.Setenv("ODDMU_LANGUAGES", "fr")
+	assert.Equal(t, "fr", setting("languages"))
+	assert.Equal(t, "fr", dirSetting("languages", "knochentanz"))
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// davMethods are the WebDAV methods that change files or locks. They are subject to [checkAuth] and [limit].
+var davMethods = []string{"PUT", "DELETE", "MKCOL", "COPY", "MOVE", "PROPPATCH", "LOCK", "UNLOCK"}
+
+// davLockStore controls access to the WebDAV locks of the sites. Make sure to lock and unlock as appropriate.
+type davLockStore struct {
+	sync.Mutex
+
+	// locks maps sites to their lock systems. The locks are kept in memory.
+	locks map[*site]webdav.LockSystem
+}
+
+var davLocks = davLockStore{locks: make(map[*site]webdav.LockSystem)}
+
+// lookup returns the lock system for a site, creating it if necessary.
+func (d *davLockStore) lookup(s *site) webdav.LockSystem {
+	d.Lock()
+	defer d.Unlock()
+	ls, ok := d.locks[s]
+	if !ok {
+		ls = webdav.NewMemLS()
+		d.locks[s] = ls
+	}
+	return ls
+}
+
+// davHandler serves the pages and files of the site via WebDAV at "/dav/" if the setting "dav" is set. Unlike the
+// WebDAV module of a web server, this goes through Oddmu: hidden files cannot be accessed, the setting "filter" hides
+// directories from listings just like [listHandler] does, and changes are treated like edits and uploads, with
+// backups, size limits, index updates and template updates. See [davFS]. Methods that make changes require a login if
+// the setting "auth" applies to the directory of the file or the destination of a copy or move.
+func davHandler(w http.ResponseWriter, r *http.Request) {
+	s := siteOf(r)
+	if s.setting("dav") == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if isHiddenName(r.URL.Path) {
+		http.Error(w, "can neither confirm nor deny the existence of this resource", http.StatusForbidden)
+		return
+	}
+	fsys := &davFS{site: s, user: anonymous}
+	h := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: fsys,
+		LockSystem: davLocks.lookup(s),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				slog.Debug("WebDAV failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		},
+	}
+	if !slices.Contains(davMethods, r.Method) {
+		h.ServeHTTP(w, r)
+		return
+	}
+	names := []string{davName(r.URL.Path)}
+	dest := r.Header.Get("Destination")
+	if dest != "" {
+		u, err := url.Parse(dest)
+		if err != nil {
+			http.Error(w, "invalid destination", http.StatusBadRequest)
+			return
+		}
+		if u.Host != "" && u.Host != r.Host {
+			http.Error(w, "the destination is on a different server", http.StatusBadGateway)
+			return
+		}
+		if isHiddenName(u.Path) {
+			http.Error(w, "can neither confirm nor deny the existence of this resource", http.StatusForbidden)
+			return
+		}
+		names = append(names, davName(u.Path))
+	}
+	for _, name := range names {
+		if !checkAuth(w, r, "dav", name) {
+			return
+		}
+	}
+	fsys.user = author(r, names...)
+	limit(h.ServeHTTP)(w, r)
+}
+
+// davName returns the name used by the storage for a URL path starting with "/dav/". The path is cleaned the same way
+// [webdav.Handler] cleans it so that the name checked by [checkAuth] is the name that is changed.
+func davName(p string) string {
+	return storagePath(strings.TrimPrefix(path.Clean("/"+p), "/dav"))
+}
+
+// davFS is the storage of a site as seen by WebDAV clients, implementing [webdav.FileSystem]. Hidden files don't
+// exist. Backup files are not listed. Changes are made on behalf of the user.
+type davFS struct {
+	site *site
+	user string
+}
+
+// name returns the name used by the storage for a name used by WebDAV. Hidden files don't exist. The root directory is
+// ".", which is not hidden.
+func (d *davFS) name(op, name string) (string, error) {
+	name = storagePath(name)
+	if name != "." && isHiddenName(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return name, nil
+}
+
+func (d *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name, err := d.name("mkdir", name)
+	if err != nil {
+		return err
+	}
+	slog.Info("Mkdir", "dir", name, "user", d.user)
+	return d.site.store.mkdir(name)
+}
+
+// OpenFile opens a file for reading or for writing. Files opened for writing are saved when they are closed. See
+// [davFS.save].
+func (d *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name, err := d.name("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		f := &davFile{fs: d, name: name, buf: new(bytes.Buffer)}
+		b, err := d.site.store.readFile(name)
+		if err == nil {
+			if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+			}
+			if flag&os.O_TRUNC == 0 {
+				f.buf.Write(b)
+			}
+		} else if flag&os.O_CREATE == 0 || !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		return f, nil
+	}
+	fi, err := d.site.store.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &davFile{fs: d, name: name, info: fi}
+	if !fi.IsDir() {
+		b, err := d.site.store.readFile(name)
+		if err != nil {
+			return nil, err
+		}
+		f.reader = bytes.NewReader(b)
+	}
+	return f, nil
+}
+
+// RemoveAll deletes a file, just like [deleteHandler]. Directories can only be deleted if they are empty.
+func (d *davFS) RemoveAll(ctx context.Context, name string) error {
+	name, err := d.name("remove", name)
+	if err != nil {
+		return err
+	}
+	s := d.site
+	fi, err := s.store.stat(name)
+	if err != nil {
+		return err
+	}
+	slog.Info("Delete", "file", name, "user", d.user)
+	s.watches.ignore(filepath.FromSlash(name))
+	if fi.IsDir() {
+		return authored(s.store, d.user).remove(name)
+	}
+	err = s.deleteFile(name, d.user)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(name, ".md") {
+		s.index.deletePageName(strings.TrimSuffix(name, ".md"))
+	}
+	return nil
+}
+
+// Rename renames a file or directory, just like [renameHandler].
+func (d *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	from, err := d.name("rename", oldName)
+	if err != nil {
+		return err
+	}
+	to, err := d.name("rename", newName)
+	if err != nil {
+		return err
+	}
+	s := d.site
+	fi, err := s.store.stat(from)
+	if err != nil {
+		return err
+	}
+	slog.Info("Rename", "file", from, "target", to, "user", d.user)
+	s.watches.ignore(filepath.FromSlash(from))
+	s.watches.ignore(filepath.FromSlash(to))
+	err = authored(s.store, d.user).rename(from, to)
+	if err != nil {
+		return err
+	}
+	s.renameIndex(from, to, fi.IsDir())
+	return nil
+}
+
+func (d *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name, err := d.name("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return d.site.store.stat(name)
+}
+
+// list returns the files and subdirectories of a directory that are listed. Hidden files and backup files are
+// skipped. If the setting "filter" is a regular expression that doesn't match the directory, the files and
+// subdirectories matching the regular expression are skipped. See [listHandler].
+func (d *davFS) list(name string) ([]fs.FileInfo, error) {
+	dir := ""
+	if name != "." {
+		dir = name + "/"
+	}
+	filter := d.site.dirSetting("filter", dir)
+	re, err := regexp.Compile(filter)
+	if err != nil {
+		slog.Error("ODDMU_FILTER does not compile", "filter", filter, "err", err)
+		return nil, err
+	}
+	matches := re.MatchString(dir)
+	entries, err := d.site.store.readDir(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := []fs.FileInfo{}
+	for _, e := range entries {
+		n := e.Name()
+		if strings.HasPrefix(n, ".") || strings.HasSuffix(n, "~") {
+			continue
+		}
+		if e.IsDir() {
+			n += "/"
+		}
+		if filter != "" && !matches && re.MatchString(dir+n) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, fi)
+	}
+	return infos, nil
+}
+
+// save writes a file on behalf of the user, just like saving a page or uploading a file: the size limits apply, a
+// backup is made and the index or the templates are updated. Unlike saving a page, writing an empty page doesn't
+// delete it because clients often create an empty file before writing it.
+func (d *davFS) save(name string, data []byte) error {
+	s := d.site
+	fp := filepath.FromSlash(name)
+	var p *Page
+	var err error
+	if strings.HasSuffix(name, ".md") {
+		n := strings.TrimSuffix(name, ".md")
+		p = &Page{Title: n, Name: n, Body: data, site: s}
+		err = p.checkSize()
+	} else {
+		err = s.checkUploadSize(filepath.Dir(fp), name, int64(len(data)))
+	}
+	if err != nil {
+		slog.Warn("Upload refused", "file", name, "err", err)
+		return err
+	}
+	s.watches.ignore(fp)
+	err = s.backup(name)
+	if err != nil {
+		return err
+	}
+	err = authored(s.store, d.user).writeFile(name, data)
+	if err != nil {
+		return err
+	}
+	slog.Info("Saved", "file", name, "user", d.user)
+	if p != nil {
+		s.index.update(p)
+	} else {
+		metrics.uploaded()
+		s.templates.update(fp)
+	}
+	return nil
+}
+
+// davFile is a file or directory opened by a WebDAV client, implementing [webdav.File]. Files opened for reading have
+// their content read into memory. Files opened for writing have their content written to a buffer and saved when
+// they are closed.
+type davFile struct {
+	fs   *davFS
+	name string
+
+	// info is the information about the file or directory opened for reading.
+	info fs.FileInfo
+
+	// reader is the content of the file opened for reading.
+	reader *bytes.Reader
+
+	// entries are the files and subdirectories of the directory opened for reading, once read. See [davFS.list].
+	entries []fs.FileInfo
+
+	// offset is the number of entries already returned by Readdir.
+	offset int
+
+	// buf is the content of the file opened for writing.
+	buf *bytes.Buffer
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	return f.reader.Read(p)
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	if f.reader == nil {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	if f.buf == nil {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+	}
+	return f.buf.Write(p)
+}
+
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if f.info == nil || !f.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if f.entries == nil {
+		entries, err := f.fs.list(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.entries = entries
+	}
+	rest := f.entries[f.offset:]
+	if count <= 0 {
+		f.offset = len(f.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.offset += count
+	return rest[:count], nil
+}
+
+func (f *davFile) Stat() (fs.FileInfo, error) {
+	if f.buf != nil {
+		return davInfo{name: path.Base(f.name), size: int64(f.buf.Len()), modTime: time.Now()}, nil
+	}
+	return f.info, nil
+}
+
+// Close saves a file opened for writing. See [davFS.save].
+func (f *davFile) Close() error {
+	if f.buf == nil {
+		return nil
+	}
+	buf := f.buf
+	f.buf = nil
+	return f.fs.save(f.name, buf.Bytes())
+}
+
+// davInfo is the information about a file opened for writing.
+type davInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i davInfo) Name() string       { return i.name }
+func (i davInfo) Size() int64        { return i.size }
+func (i davInfo) Mode() fs.FileMode  { return 0644 }
+func (i davInfo) ModTime() time.Time { return i.modTime }
+func (i davInfo) IsDir() bool        { return false }
+func (i davInfo) Sys() any           { return nil }
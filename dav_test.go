@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// davRequest sends a WebDAV request to the handler and returns the response. Headers are given as pairs of names
+// and values.
+func davRequest(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	var b io.Reader
+	if body != "" {
+		b = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, path, b)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	davHandler(w, r)
+	return w
+}
+
+func TestDav(t *testing.T) {
+	cleanup(t, "testdata/dav")
+	assert.NoError(t, os.MkdirAll("testdata/dav/secret", 0755))
+	assert.NoError(t, os.WriteFile("testdata/dav/.hidden", []byte("Nobody sees me"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/dav/secret/diary.md", []byte("# Diary"), 0644))
+	os.Unsetenv("ODDMU_AUTH")
+	t.Setenv("ODDMU_FILTER", "^testdata/dav/secret/")
+	// WebDAV must be enabled
+	t.Setenv("ODDMU_DAV", "")
+	assert.Equal(t, http.StatusNotFound, davRequest(http.MethodGet, "/dav/testdata/dav/", "").Code)
+	t.Setenv("ODDMU_DAV", "1")
+	// pages are indexed
+	w := davRequest(http.MethodPut, "/dav/testdata/dav/moon.md", "# Moon\n\nThe moon is bright")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "Moon", index.titles["testdata/dav/moon"])
+	// changes make backups
+	w = davRequest(http.MethodPut, "/dav/testdata/dav/moon.md", "# Full Moon\n\nThe moon is bright")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "Full Moon", index.titles["testdata/dav/moon"])
+	assert.FileExists(t, "testdata/dav/moon.md~")
+	w = davRequest(http.MethodGet, "/dav/testdata/dav/moon.md", "")
+	assert.Equal(t, "# Full Moon\n\nThe moon is bright", w.Body.String())
+	// hidden files, backup files and filtered directories are not listed
+	w = davRequest("PROPFIND", "/dav/testdata/dav/", "", "Depth", "1")
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "/dav/testdata/dav/moon.md")
+	assert.NotContains(t, w.Body.String(), "moon.md~")
+	assert.NotContains(t, w.Body.String(), ".hidden")
+	assert.NotContains(t, w.Body.String(), "secret")
+	w = davRequest("PROPFIND", "/dav/testdata/dav/secret/", "", "Depth", "1")
+	assert.Contains(t, w.Body.String(), "diary.md")
+	// the root can be mounted
+	w = davRequest("PROPFIND", "/dav/", "", "Depth", "0")
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<D:href>/dav/</D:href>")
+	w = davRequest("PROPFIND", "/dav", "", "Depth", "1")
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "/dav/testdata/")
+	// hidden files cannot be accessed
+	assert.Equal(t, http.StatusForbidden, davRequest(http.MethodGet, "/dav/testdata/dav/.hidden", "").Code)
+	assert.Equal(t, http.StatusForbidden, davRequest(http.MethodPut, "/dav/testdata/dav/.hidden", "Boo!").Code)
+	w = davRequest("MOVE", "/dav/testdata/dav/moon.md", "", "Destination", "http://example.com/dav/testdata/dav/.moon.md")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	// moving updates the index
+	assert.Equal(t, http.StatusCreated, davRequest("MKCOL", "/dav/testdata/dav/poems/", "").Code)
+	w = davRequest("MOVE", "/dav/testdata/dav/moon.md", "", "Destination", "http://example.com/dav/testdata/dav/poems/moon.md")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, index.titles, "testdata/dav/moon")
+	assert.Equal(t, "Full Moon", index.titles["testdata/dav/poems/moon"])
+	// deleting makes a backup
+	assert.Equal(t, http.StatusNoContent, davRequest(http.MethodDelete, "/dav/testdata/dav/poems/moon.md", "").Code)
+	assert.NotContains(t, index.titles, "testdata/dav/poems/moon")
+	assert.FileExists(t, "testdata/dav/poems/moon.md~")
+	assert.NoFileExists(t, "testdata/dav/poems/moon.md")
+	// only empty directories can be deleted
+	assert.Equal(t, http.StatusMethodNotAllowed, davRequest(http.MethodDelete, "/dav/testdata/dav/poems/", "").Code)
+	assert.Equal(t, http.StatusCreated, davRequest("MKCOL", "/dav/testdata/dav/empty/", "").Code)
+	assert.Equal(t, http.StatusNoContent, davRequest(http.MethodDelete, "/dav/testdata/dav/empty/", "").Code)
+	assert.NoDirExists(t, "testdata/dav/empty")
+	// changes may require a login
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile("testdata/dav/.htpasswd", []byte("alex:"+string(hash)+"\n"), 0644))
+	t.Setenv("ODDMU_AUTH", "testdata/dav/.htpasswd")
+	assert.Equal(t, http.StatusUnauthorized, davRequest(http.MethodPut, "/dav/testdata/dav/sun.md", "# Sun").Code)
+	assert.Equal(t, http.StatusOK, davRequest(http.MethodGet, "/dav/testdata/dav/poems/moon.md~", "").Code)
+	r := httptest.NewRequest(http.MethodPut, "/dav/testdata/dav/sun.md", strings.NewReader("# Sun\n\nIt burns"))
+	r.SetBasicAuth("alex", "secret")
+	w = httptest.NewRecorder()
+	davHandler(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "Sun", index.titles["testdata/dav/sun"])
+}
+
+func TestDavName(t *testing.T) {
+	assert.Equal(t, "knochentanz/x.md", davName("/dav/knochentanz/x.md"))
+	assert.Equal(t, "knochentanz/x.md", davName("/dav//knochentanz/x.md"))
+	assert.Equal(t, "knochentanz/x.md", davName("/dav/./knochentanz//x.md"))
+	assert.Equal(t, "knochentanz/x.md", davName("/dav/../dav/knochentanz/x.md"))
+	assert.Equal(t, "knochentanz", davName("/dav/knochentanz/"))
+	assert.Equal(t, ".", davName("/dav/"))
+}
+
+func TestDavDestinationHost(t *testing.T) {
+	t.Setenv("ODDMU_DAV", "1")
+	w := davRequest("MOVE", "/dav/testdata/dav/moon.md", "", "Destination", "http://example.org/dav/testdata/dav/sun.md")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
@@ -5,36 +5,121 @@ import (
 	"github.com/sergi/go-diff/diffmatchpatch"
 	"html"
 	"html/template"
+	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 )
 
+// diffHandler shows the changes made to a page. If the page is stored in git, the "rev" query parameter is the hash of
+// the commit whose changes are shown.
 func diffHandler(w http.ResponseWriter, r *http.Request, name string) {
-	p, err := loadPage(name)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	s := siteOf(r)
+	rev := r.FormValue("rev")
+	if rev != "" && !revisionRe.MatchString(rev) {
+		http.Error(w, "the revision must be a commit hash", http.StatusBadRequest)
 		return
 	}
+	p, err := s.loadPage(name)
+	if err != nil {
+		if rev == "" {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// the changes made by an old revision of a deleted page can still be shown
+		p = &Page{Title: name, Name: name, site: s}
+	}
+	p.rev = rev
 	p.handleTitle(true)
 	p.renderHtml()
-	renderTemplate(w, p.Dir(), "diff", p)
+	s.templates.render(w, p.Dir(), "diff", p)
+}
+
+// historyHandler shows the commits that changed a page, newest first, with links to their diffs. This requires the git
+// storage. Deleted pages have a history, too.
+func historyHandler(w http.ResponseWriter, r *http.Request, name string) {
+	s := siteOf(r)
+	if _, ok := s.store.(*gitStorage); !ok {
+		http.Error(w, "the history requires the git storage", http.StatusNotFound)
+		return
+	}
+	p, err := s.loadPage(name)
+	if err != nil {
+		p = &Page{Title: name, Name: name, site: s}
+	} else {
+		p.handleTitle(false)
+	}
+	s.templates.render(w, p.Dir(), "history", p)
 }
 
-// Diff computes the diff for a page. At this point, renderHtml has already been called so the Name is escaped.
+// Versioned reports whether the storage of the page keeps old versions by itself. If so, there are no backups and
+// [Page.Diff] and [Page.History] use the old versions kept.
+func (p *Page) Versioned() bool {
+	return p.wiki().store.versioned()
+}
+
+// History returns the last commits that changed the page, newest first. This requires the git storage.
+func (p *Page) History() []Revision {
+	g, ok := p.wiki().store.(*gitStorage)
+	if !ok {
+		return nil
+	}
+	revs, err := g.log(p.Name+".md", 100)
+	if err != nil {
+		slog.Warn("History failed", "page", p.Name, "err", err)
+		return nil
+	}
+	return revs
+}
+
+// Diff computes the diff for a page. At this point, renderHtml has already been called so the Name is escaped. If the
+// page is stored in git, see [Page.gitDiff].
 func (p *Page) Diff() template.HTML {
-	fp := filepath.FromSlash(p.Name)
-	a := fp + ".md~"
-	t1, err := os.ReadFile(a)
+	if g, ok := p.wiki().store.(*gitStorage); ok {
+		return p.gitDiff(g)
+	}
+	store := p.wiki().store
+	a := p.Name + ".md~"
+	t1, err := store.readFile(a)
 	if err != nil {
 		return template.HTML("Cannot read " + a + ", so the page is new.")
 	}
-	b := fp + ".md"
-	t2, err := os.ReadFile(b)
+	b := p.Name + ".md"
+	t2, err := store.readFile(b)
 	if err != nil {
 		return template.HTML("Cannot read " + b + ", so the page was deleted.")
 	}
+	return diffHtml(t1, t2)
+}
+
+// gitDiff computes the diff for a page stored in git. If a revision was requested, these are the changes made by that
+// commit. If not, these are the changes made since the commit before the last commit that changed the page, including
+// changes that haven't been committed.
+func (p *Page) gitDiff(g *gitStorage) template.HTML {
+	name := p.Name + ".md"
+	rev := p.rev
+	if rev == "" {
+		revs, err := g.log(name, 1)
+		if err != nil || len(revs) == 0 {
+			return template.HTML("There is no history for " + html.EscapeString(name) + ", so the page is new.")
+		}
+		rev = revs[0].Hash
+	}
+	t1, _ := g.show(rev+"~", name) // missing if the commit created the page
+	var t2 []byte
+	var err error
+	if p.rev == "" {
+		t2, err = g.readFile(name)
+	} else {
+		t2, err = g.show(p.rev, name)
+	}
+	if err != nil {
+		t2 = nil // the page was deleted
+	}
+	return diffHtml(t1, t2)
+}
+
+// diffHtml returns the changes between two texts as HTML.
+func diffHtml(t1, t2 []byte) template.HTML {
 	dmp := diffmatchpatch.New()
 	diffs := dmp.DiffMain(string(t1), string(t2), false)
 	return template.HTML(diff2html(dmp.DiffCleanupSemantic(diffs)))
@@ -1,39 +1,56 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 )
 
 // editHandler uses the "edit.html" template to present an edit page. When editing, the page title is not overriden by a
 // title in the text. Instead, the page name is used. The edit is saved using the saveHandler.
 func editHandler(w http.ResponseWriter, r *http.Request, name string) {
-	p, err := loadPage(name)
+	s := siteOf(r)
+	p, err := s.loadPage(name)
 	if err != nil {
-		p = &Page{Title: name, Name: name}
+		p = &Page{Title: name, Name: name, site: s}
 	} else {
 		p.handleTitle(false)
 	}
-	renderTemplate(w, p.Dir(), "edit", p)
+	s.templates.render(w, p.Dir(), "edit", p)
 }
 
 // saveHandler takes the "body" form parameter and saves it. The browser is redirected to the page view. This is similar
 // to the appendHandler.
 func saveHandler(w http.ResponseWriter, r *http.Request, name string) {
 	body := r.FormValue("body")
-	p := &Page{Name: name, Body: []byte(body)}
-	err := p.save()
+	s := siteOf(r)
+	username := author(r, name)
+	p := &Page{Name: name, Body: []byte(body), site: s, author: username}
+	if len(body) > 0 {
+		err := p.checkSize()
+		if err != nil {
+			slog.Warn("Save refused", "page", name, "err", err)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	// an empty body deletes the page, so spammers and banned hosts must not get to save it
+	var old []byte
+	o, err := s.loadPage(name)
+	if err == nil {
+		old = o.Body
+	}
+	err = p.checkSpam(r, old)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
-	username, _, ok := r.BasicAuth()
-	if ok {
-		log.Println("Save", name, "by", username)
-	} else {
-		log.Println("Save", name)
+	err = p.save()
+	if err != nil {
+		slog.Error("Save failed", "page", name, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	slog.Info("Save", "page", name, "user", username)
 	if r.FormValue("notify") == "on" {
 		err = p.notify() // errors have already been logged, so no logging here
 		if err != nil {
@@ -63,7 +63,7 @@ func exportCli(w io.Writer, templateName string, idx *indexStore) subcommands.Ex
 		}
 		p.handleTitle(false)
 		p.renderHtml()
-		fi, err := os.Stat(name + ".md")
+		fi, err := defaultSite.store.stat(name + ".md")
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Stat %s: %s\n", name, err)
 			return subcommands.ExitFailure
@@ -2,13 +2,22 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/ast"
+	"html"
 	"html/template"
-	"os"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // Item is a Page plus a Date.
@@ -21,6 +30,35 @@ type Item struct {
 	// Markdown files, they don't contain any metadata. Instead, the last modification date of the file is used.
 	// This makes it work well with changes made to the files outside of Oddmu.
 	Date string
+
+	// modified is the last modification date of the file, as a time. It is used for the Atom and JSON feeds which
+	// require the RFC 3339 format.
+	modified time.Time
+
+	// Summary is the plain text of the page, limited to a number of characters. It is only set if the environment
+	// variable ODDMU_FEED_SUMMARY is set to the number of characters to use.
+	Summary string
+
+	// Enclosure is the first local image on the page. It is only set if the environment variable
+	// ODDMU_FEED_ENCLOSURE is set to "1".
+	Enclosure *Enclosure
+}
+
+// Enclosure is a file attached to a feed item. Url is the absolute URL of the file, Length is its size in bytes and
+// Type is its MIME type.
+type Enclosure struct {
+	Url    string
+	Length int64
+	Type   string
+}
+
+// feedOptions control how feed items are rendered. If base is set, relative links and image sources are turned into
+// absolute URLs using base as the URL of the site. If summary is larger than zero, items get a summary of that many
+// characters. If enclosure is set, items get an enclosure for the first local image.
+type feedOptions struct {
+	base      *url.URL
+	summary   int
+	enclosure bool
 }
 
 // Feed is an Item used for the feed itself, plus an array of items based on the linked pages.
@@ -41,16 +79,100 @@ type Feed struct {
 
 	// Complete is set when there is no pagination.
 	Complete bool
+
+	// base is the URL of the site, if known.
+	base *url.URL
+
+	// sources are the files of the pages the feed items are based on, whether they exist or not.
+	sources []string
+}
+
+// feedFormats are the feed formats known, mapping the extension used in URLs and filenames to the template used. The
+// JSON Feed doesn't use a template. See writeJsonFeed.
+var feedFormats = map[string]string{
+	"rss":  "feed.html",
+	"atom": "atom.html",
+	"json": "",
+}
+
+// getFeedOptions returns the feed options based on the environment variables ODDMU_BASE_URL, ODDMU_FEED_SUMMARY and
+// ODDMU_FEED_ENCLOSURE. If ODDMU_BASE_URL is not set, the base remains unset. See feedOptionsFor for the web server.
+func getFeedOptions() feedOptions {
+	return siteFeedOptions(defaultSite)
+}
+
+// siteFeedOptions returns the feed options based on the settings of the site. See getFeedOptions.
+func siteFeedOptions(s *site) feedOptions {
+	opts := feedOptions{}
+	opts.base = baseUrl(s.setting("base_url"))
+	n, err := strconv.Atoi(s.setting("feed_summary"))
+	if err == nil && n > 0 {
+		opts.summary = n
+	}
+	opts.enclosure = s.setting("feed_enclosure") == "1"
+	return opts
+}
+
+// feedOptionsFor returns the feed options for a request. If ODDMU_BASE_URL is not set and the request comes from a
+// trusted proxy (see [trustedProxy]), the base is determined by the request: the X-Forwarded-Proto, X-Forwarded-Host
+// and X-Forwarded-Prefix headers set by the proxy take precedence over the Host header and the TLS state of the
+// request. Otherwise, the base remains unset since anybody can set these headers.
+func feedOptionsFor(r *http.Request) feedOptions {
+	opts := siteFeedOptions(siteOf(r))
+	if opts.base != nil || !trustedProxy(peerIP(r)) {
+		return opts
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if s := forwarded(r, "X-Forwarded-Proto"); s != "" {
+		scheme = s
+	}
+	host := r.Host
+	if s := forwarded(r, "X-Forwarded-Host"); s != "" {
+		host = s
+	}
+	if host == "" {
+		return opts
+	}
+	opts.base = baseUrl(scheme + "://" + host + forwarded(r, "X-Forwarded-Prefix"))
+	return opts
+}
+
+// forwarded returns the first value of a X-Forwarded-* header. If there are multiple proxies, the header contains a
+// comma-separated list and the first one is the one the client used.
+func forwarded(r *http.Request, header string) string {
+	s, _, _ := strings.Cut(r.Header.Get(header), ",")
+	return strings.TrimSpace(s)
+}
+
+// baseUrl parses a string and returns the URL if it is absolute. The path always ends in a slash such that the base
+// URL can be used to resolve page URLs.
+func baseUrl(s string) *url.URL {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || !u.IsAbs() {
+		return nil
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u
 }
 
-// feed returns a RSS 2.0 feed for any page. The feed items it contains are the pages linked from in list items starting
+// feed returns a feed for any page. The feed items it contains are the pages linked from in list items starting
 // with an asterisk ("*"). The feed starts from a certain item and contains n items. If n is 0, the feed is complete
-// (unpaginated).
-func feed(p *Page, ti time.Time, from, n int) *Feed {
+// (unpaginated). The feed options determine how the items are rendered.
+func feed(p *Page, ti time.Time, from, n int, opts feedOptions) *Feed {
 	feed := new(Feed)
+	feed.base = opts.base
 	feed.Name = p.Name
 	feed.Title = p.Title
 	feed.Date = ti.Format(time.RFC1123Z)
+	feed.modified = ti
 	feed.From = from
 	feed.N = n
 	if n == 0 {
@@ -92,24 +214,216 @@ func feed(p *Page, ti time.Time, from, n int) *Feed {
 		}
 		// i counts links, not actual existing pages
 		name := path.Join(p.Dir(), string(link.Destination))
-		fi, err := os.Stat(filepath.FromSlash(name) + ".md")
+		feed.sources = append(feed.sources, filepath.FromSlash(name)+".md")
+		fi, err := p.wiki().store.stat(name + ".md")
 		if err != nil {
 			return ast.GoToNext
 		}
-		p2, err := loadPage(name)
+		p2, err := p.wiki().loadPage(name)
 		if err != nil {
 			return ast.GoToNext
 		}
-		p2.handleTitle(false)
-		p2.renderHtml()
-		it := Item{Date: fi.ModTime().Format(time.RFC1123Z)}
-		it.Title = p2.Title
-		it.Name = p2.Name
-		it.Html = template.HTML(template.HTMLEscaper(p2.Html))
-		it.Hashtags = p2.Hashtags
-		items = append(items, it)
+		items = append(items, feedItem(p2, fi.ModTime(), opts))
 		return ast.GoToNext
 	})
 	feed.Items = items
 	return feed
 }
+
+// feedItem returns the feed item for a page. If the feed options have a base URL, relative links and image sources are
+// turned into absolute URLs.
+func feedItem(p *Page, ti time.Time, opts feedOptions) Item {
+	p.handleTitle(false)
+	var img string
+	if opts.base != nil {
+		img = p.renderAbsoluteHtml(opts.base)
+	} else {
+		p.renderHtml()
+	}
+	it := Item{Date: ti.Format(time.RFC1123Z), modified: ti}
+	it.Title = p.Title
+	it.Name = p.Name
+	it.Html = template.HTML(template.HTMLEscaper(p.Html))
+	it.Hashtags = p.Hashtags
+	if opts.summary > 0 {
+		it.Summary = p.summary(opts.summary)
+	}
+	if opts.enclosure && img != "" {
+		it.Enclosure = enclosure(p.wiki(), img, opts.base.ResolveReference(&url.URL{Path: "view/" + img}))
+	}
+	return it
+}
+
+// summary returns the plain text of the page without the title and with whitespace collapsed, limited to n characters.
+// If the text is longer, it is cut at the last space and an ellipsis is appended.
+func (p *Page) summary(n int) string {
+	s := &Page{Body: p.Body}
+	s.handleTitle(true)
+	text := strings.Join(strings.Fields(s.plainText()), " ")
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	r := []rune(text)[:n]
+	i := strings.LastIndex(string(r), " ")
+	if i > 0 {
+		return string(r)[:i] + "…"
+	}
+	return string(r) + "…"
+}
+
+// enclosure returns the enclosure for a local file, given as a page name (a path using slashes), and its absolute URL.
+// If the file does not exist in the site, nil is returned.
+func enclosure(s *site, name string, u *url.URL) *Enclosure {
+	fi, err := s.store.stat(name)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	return &Enclosure{Url: u.String(), Length: fi.Size(), Type: mime.TypeByExtension(path.Ext(name))}
+}
+
+// htmlUrlRe matches src and href attributes in raw HTML. The first group is everything up to and including the
+// opening quote, the second group is the URL.
+var htmlUrlRe = regexp.MustCompile(`(?i)(\s(?:src|href)=")([^"]*)`)
+
+// renderAbsoluteHtml renders the Page.Body to HTML like renderHtml, except that relative links and image sources are
+// turned into absolute URLs. This also applies to the src and href attributes of raw HTML. The URL of the page is
+// based on the URL of the site. The name of the first local image (as a path relative to the site) is returned. If
+// there is no local image, the empty string is returned.
+func (p *Page) renderAbsoluteHtml(base *url.URL) string {
+	parser, hashtags := wikiParser()
+	doc := markdown.Parse(p.Body, parser)
+	page := base.ResolveReference(&url.URL{Path: "view/" + p.Name})
+	img := ""
+	absolute := func(dest []byte) []byte {
+		u, err := url.Parse(string(dest))
+		if err != nil || u.IsAbs() || u.Host != "" {
+			return dest
+		}
+		return []byte(page.ResolveReference(u).String())
+	}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if entering {
+			switch v := node.(type) {
+			case *ast.Link:
+				v.Destination = absolute(v.Destination)
+			case *ast.Image:
+				if img == "" {
+					u, err := url.Parse(string(v.Destination))
+					if err == nil && !u.IsAbs() && u.Host == "" && !strings.HasPrefix(u.Path, "/") {
+						img = path.Join(path.Dir(p.Name), u.Path)
+					}
+				}
+				v.Destination = absolute(v.Destination)
+			case *ast.HTMLSpan:
+				v.Literal = htmlUrlRe.ReplaceAllFunc(v.Literal, func(m []byte) []byte {
+					sm := htmlUrlRe.FindSubmatch(m)
+					return append(sm[1], absolute(sm[2])...)
+				})
+			case *ast.HTMLBlock:
+				v.Literal = htmlUrlRe.ReplaceAllFunc(v.Literal, func(m []byte) []byte {
+					sm := htmlUrlRe.FindSubmatch(m)
+					return append(sm[1], absolute(sm[2])...)
+				})
+			}
+		}
+		return ast.GoToNext
+	})
+	p.Html = p.sanitize(markdown.Render(doc, wikiRenderer()))
+	p.Hashtags = *hashtags
+	return img
+}
+
+// Updated returns the last modification date of the item in RFC 3339 format, as required for Atom feeds.
+func (it *Item) Updated() string {
+	return it.modified.Format(time.RFC3339)
+}
+
+// Published returns the publication date of the item in RFC 3339 format, as required for Atom feeds. Since pages don't
+// have metadata, only blog pages have a publication date: the ISO date their name starts with. For all other pages,
+// the empty string is returned.
+func (it *Item) Published() string {
+	s := blogRe.FindString(path.Base(it.Name))
+	if s == "" {
+		return ""
+	}
+	ti, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		return ""
+	}
+	return ti.Format(time.RFC3339)
+}
+
+// jsonFeed is the JSON Feed 1.1 representation of a feed. See https://www.jsonfeed.org/version/1.1/.
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageUrl string         `json:"home_page_url,omitempty"`
+	FeedUrl     string         `json:"feed_url,omitempty"`
+	NextUrl     string         `json:"next_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+// jsonFeedItem is the JSON Feed 1.1 representation of a feed item.
+type jsonFeedItem struct {
+	Id            string               `json:"id"`
+	Url           string               `json:"url"`
+	Title         string               `json:"title"`
+	ContentHtml   string               `json:"content_html"`
+	Summary       string               `json:"summary,omitempty"`
+	DatePublished string               `json:"date_published,omitempty"`
+	DateModified  string               `json:"date_modified"`
+	Tags          []string             `json:"tags,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+// jsonFeedAttachment is the JSON Feed 1.1 representation of an enclosure.
+type jsonFeedAttachment struct {
+	Url         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes"`
+}
+
+// writeJsonFeed writes the feed as a JSON Feed 1.1. If the site's URL is unknown, the URLs are relative to the site.
+// Item.Html is escaped for use in XML templates, so it is unescaped again.
+func writeJsonFeed(w io.Writer, f *Feed) error {
+	view := "/view/"
+	if f.base != nil {
+		view = f.base.String() + "view/"
+	}
+	jf := &jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageUrl: view + f.Path(),
+		FeedUrl:     view + f.Path() + ".json",
+		Items:       make([]jsonFeedItem, len(f.Items)),
+	}
+	if f.Next > 0 {
+		v := url.Values{}
+		v.Set("from", strconv.Itoa(f.Next))
+		v.Set("n", strconv.Itoa(f.N))
+		jf.NextUrl = jf.FeedUrl + "?" + v.Encode()
+	}
+	for i, it := range f.Items {
+		jf.Items[i] = jsonFeedItem{
+			Id:            view + it.Path(),
+			Url:           view + it.Path(),
+			Title:         it.Title,
+			ContentHtml:   html.UnescapeString(string(it.Html)),
+			Summary:       it.Summary,
+			DatePublished: it.Published(),
+			DateModified:  it.Updated(),
+			Tags:          it.Hashtags,
+		}
+		if it.Enclosure != nil {
+			jf.Items[i].Attachments = []jsonFeedAttachment{{
+				Url:         it.Enclosure.Url,
+				MimeType:    it.Enclosure.Type,
+				SizeInBytes: it.Enclosure.Length,
+			}}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jf)
+}
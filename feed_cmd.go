@@ -12,18 +12,20 @@ import (
 )
 
 type feedCmd struct {
+	format string
 }
 
 func (*feedCmd) Name() string     { return "feed" }
 func (*feedCmd) Synopsis() string { return "render a page as feed" }
 func (*feedCmd) Usage() string {
-	return `feed <page name> ...:
+	return `feed [-format rss|atom|json] <page name> ...:
   Render one or more pages as a single feed.
   Use a single - to read Markdown from stdin.
 `
 }
 
 func (cmd *feedCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.format, "format", "rss", "the feed format: rss, atom or json")
 }
 
 func (cmd *feedCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
@@ -31,10 +33,14 @@ func (cmd *feedCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}
 		fmt.Fprint(os.Stderr, cmd.Usage())
 		return subcommands.ExitFailure
 	}
-	return feedCli(os.Stdout, f.Args())
+	return feedCli(os.Stdout, cmd.format, f.Args())
 }
 
-func feedCli(w io.Writer, args []string) subcommands.ExitStatus {
+func feedCli(w io.Writer, format string, args []string) subcommands.ExitStatus {
+	if _, ok := feedFormats[format]; !ok {
+		fmt.Fprintf(os.Stderr, "Unknown feed format %s\n", format)
+		return subcommands.ExitFailure
+	}
 	if len(args) == 1 && args[0] == "-" {
 		body, err := io.ReadAll(os.Stdin)
 		if err != nil {
@@ -42,7 +48,7 @@ func feedCli(w io.Writer, args []string) subcommands.ExitStatus {
 			return subcommands.ExitFailure
 		}
 		p := &Page{Name: "stdin", Body: body}
-		return p.printFeed(w, time.Now())
+		return p.printFeed(w, format, time.Now())
 	}
 	for _, name := range args {
 		if !strings.HasSuffix(name, ".md") {
@@ -57,7 +63,7 @@ func feedCli(w io.Writer, args []string) subcommands.ExitStatus {
 			return subcommands.ExitFailure
 		}
 		ti, _ := p.ModTime()
-		status := p.printFeed(w, ti)
+		status := p.printFeed(w, format, ti)
 		if status != subcommands.ExitSuccess {
 			return status
 		}
@@ -65,13 +71,21 @@ func feedCli(w io.Writer, args []string) subcommands.ExitStatus {
 	return subcommands.ExitSuccess
 }
 
-// printFeed prints the complete feed for a page (unpaginated).
-func (p *Page) printFeed(w io.Writer, ti time.Time) subcommands.ExitStatus {
-	f := feed(p, ti, 0, 0)
+// printFeed prints the complete feed for a page (unpaginated) in the given format.
+func (p *Page) printFeed(w io.Writer, format string, ti time.Time) subcommands.ExitStatus {
+	f := feed(p, ti, 0, 0, getFeedOptions())
 	if len(f.Items) == 0 {
 		fmt.Fprintf(os.Stderr, "Empty feed for %s\n", p.Name)
 		return subcommands.ExitFailure
 	}
+	if format == "json" {
+		err := writeJsonFeed(w, f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write feed: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
 	_, err := w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>`))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot write prefix: %s\n", err)
@@ -80,7 +94,12 @@ func (p *Page) printFeed(w io.Writer, ti time.Time) subcommands.ExitStatus {
 	loadTemplates()
 	templates.RLock()
 	defer templates.RUnlock()
-	err = templates.template["feed.html"].Execute(w, f)
+	t := templates.template[feedFormats[format]]
+	if t == nil {
+		fmt.Fprintf(os.Stderr, "Template not found: %s\n", feedFormats[format])
+		return subcommands.ExitFailure
+	}
+	err = t.Execute(w, f)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot execute template: %s\n", err)
 		return subcommands.ExitFailure
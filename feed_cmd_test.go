@@ -16,7 +16,19 @@ func TestFeedCmd(t *testing.T) {
 	p.save()
 
 	b := new(bytes.Buffer)
-	s := feedCli(b, []string{"testdata/complete/index.md"})
+	s := feedCli(b, "rss", []string{"testdata/complete/index.md"})
 	assert.Equal(t, subcommands.ExitSuccess, s)
 	assert.Contains(t, b.String(), "<fh:complete/>")
+
+	b = new(bytes.Buffer)
+	s = feedCli(b, "atom", []string{"testdata/complete/index.md"})
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.Contains(t, b.String(), "<fh:complete/>")
+	assert.Contains(t, b.String(), "<title>One</title>")
+
+	b = new(bytes.Buffer)
+	s = feedCli(b, "json", []string{"testdata/complete/index.md"})
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.Contains(t, b.String(), `"title": "One"`)
+	assert.NotContains(t, b.String(), "next_url")
 }
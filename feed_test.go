@@ -3,8 +3,10 @@ package main
 import (
 	"github.com/stretchr/testify/assert"
 	"net/http"
-	"testing"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"testing"
 )
 
 func TestFeed(t *testing.T) {
@@ -54,20 +56,29 @@ Writing poems about plants.
 	assert.Contains(t, body, "<category>Palmtree</category>")
 }
 
-
 func TestFeedPagination(t *testing.T) {
 	cleanup(t, "testdata/pagination")
 
-	p := &Page{Name: "testdata/pagination/one", Body: []byte("# One\n")}; p.save()
-	p = &Page{Name: "testdata/pagination/two", Body: []byte("# Two\n")}; p.save()
-	p = &Page{Name: "testdata/pagination/three", Body: []byte("# Three\n")}; p.save()
-	p = &Page{Name: "testdata/pagination/four", Body: []byte("# Four\n")}; p.save()
-	p = &Page{Name: "testdata/pagination/five", Body: []byte("# Five\n")}; p.save()
-	p = &Page{Name: "testdata/pagination/six", Body: []byte("# Six\n")}; p.save()
-	p = &Page{Name: "testdata/pagination/seven", Body: []byte("# Seven\n")}; p.save()
-	p = &Page{Name: "testdata/pagination/eight", Body: []byte("# Eight\n")}; p.save()
-	p = &Page{Name: "testdata/pagination/nine", Body: []byte("# Nine\n")}; p.save()
-	p = &Page{Name: "testdata/pagination/ten", Body: []byte("# Ten\n")}; p.save()
+	p := &Page{Name: "testdata/pagination/one", Body: []byte("# One\n")}
+	p.save()
+	p = &Page{Name: "testdata/pagination/two", Body: []byte("# Two\n")}
+	p.save()
+	p = &Page{Name: "testdata/pagination/three", Body: []byte("# Three\n")}
+	p.save()
+	p = &Page{Name: "testdata/pagination/four", Body: []byte("# Four\n")}
+	p.save()
+	p = &Page{Name: "testdata/pagination/five", Body: []byte("# Five\n")}
+	p.save()
+	p = &Page{Name: "testdata/pagination/six", Body: []byte("# Six\n")}
+	p.save()
+	p = &Page{Name: "testdata/pagination/seven", Body: []byte("# Seven\n")}
+	p.save()
+	p = &Page{Name: "testdata/pagination/eight", Body: []byte("# Eight\n")}
+	p.save()
+	p = &Page{Name: "testdata/pagination/nine", Body: []byte("# Nine\n")}
+	p.save()
+	p = &Page{Name: "testdata/pagination/ten", Body: []byte("# Ten\n")}
+	p.save()
 
 	p = &Page{Name: "testdata/pagination/index", Body: []byte(`# Index
 * [one](one)
@@ -88,7 +99,8 @@ func TestFeedPagination(t *testing.T) {
 	assert.Contains(t, body, "<title>Ten</title>")
 	assert.NotContains(t, body, `<atom:link href="https://example.org/view/testdata/pagination/index.rss?from=10&n=10" rel="next" type="application/rss+xml"/>`)
 
-	p = &Page{Name: "testdata/pagination/eleven", Body: []byte("# Eleven\n")}; p.save()
+	p = &Page{Name: "testdata/pagination/eleven", Body: []byte("# Eleven\n")}
+	p.save()
 	p = &Page{Name: "testdata/pagination/index", Body: []byte(`# Index
 * [one](one)
 * [two](two)
@@ -144,3 +156,103 @@ func TestFeedPagination(t *testing.T) {
 	assert.Contains(t, body, `<atom:link href="https://example.org/view/testdata/pagination/index.rss?from=0&amp;n=3" rel="previous" type="application/rss+xml"/>`)
 	assert.Contains(t, body, `<atom:link href="https://example.org/view/testdata/pagination/index.rss?from=5&amp;n=3" rel="next" type="application/rss+xml"/>`)
 }
+
+func TestFeedFormats(t *testing.T) {
+	cleanup(t, "testdata/feed-formats")
+
+	p := &Page{Name: "testdata/feed-formats/2024-03-07-rain", Body: []byte(`# Rain
+I cannot hear you
+The birds outside are singing
+And the cars so loud
+
+#Haiku`)}
+	p.save()
+
+	p = &Page{Name: "testdata/feed-formats/index", Body: []byte(`# Poems
+* [Rain](2024-03-07-rain)
+`)}
+	p.save()
+
+	body := assert.HTTPBody(makeHandler(viewHandler, false, http.MethodGet), "GET", "/view/testdata/feed-formats/index.atom", nil)
+	assert.Contains(t, body, `<feed xmlns="http://www.w3.org/2005/Atom"`)
+	assert.Contains(t, body, "<title>Poems</title>")
+	assert.Contains(t, body, "<title>Rain</title>")
+	assert.Contains(t, body, "<published>2024-03-07T00:00:00")
+	assert.Contains(t, body, `<category term="Haiku"/>`)
+	assert.Contains(t, body, `&lt;h1 id=&#34;rain&#34;&gt;Rain&lt;/h1&gt;`)
+
+	body = assert.HTTPBody(makeHandler(viewHandler, false, http.MethodGet), "GET", "/view/testdata/feed-formats/index.json", nil)
+	assert.Contains(t, body, `"version": "https://jsonfeed.org/version/1.1"`)
+	assert.Contains(t, body, `"title": "Poems"`)
+	assert.Contains(t, body, `"url": "/view/testdata/feed-formats/2024-03-07-rain"`)
+	assert.Contains(t, body, `"content_html": "<h1 id=\"rain\">Rain</h1>`)
+	assert.Contains(t, body, `"date_published": "2024-03-07T00:00:00`)
+	assert.Contains(t, body, `"Haiku"`)
+
+	params := url.Values{}
+	params.Set("n", "0")
+	body = assert.HTTPBody(makeHandler(viewHandler, false, http.MethodGet), "GET", "/view/testdata/feed-formats/index.atom", params)
+	assert.Contains(t, body, `<fh:complete/>`)
+}
+
+func TestFeedFormatsFile(t *testing.T) {
+	cleanup(t, "testdata/feed-file")
+	assert.NoError(t, os.MkdirAll("testdata/feed-file", 0755))
+	assert.NoError(t, os.WriteFile("testdata/feed-file/data.json", []byte(`{"answer": 42}`), 0644))
+	assert.Contains(t,
+		assert.HTTPBody(makeHandler(viewHandler, false, http.MethodGet), "GET", "/view/testdata/feed-file/data.json", nil),
+		`"answer": 42`)
+	assert.HTTPStatusCode(t,
+		makeHandler(viewHandler, false, http.MethodGet), "GET", "/view/testdata/feed-file/no-feed.json", nil, http.StatusNotFound)
+}
+
+func TestFeedAbsoluteUrls(t *testing.T) {
+	cleanup(t, "testdata/absolute")
+	t.Setenv("ODDMU_FEED_SUMMARY", "25")
+	t.Setenv("ODDMU_FEED_ENCLOSURE", "1")
+	assert.NoError(t, os.MkdirAll("testdata/absolute", 0755))
+	assert.NoError(t, os.WriteFile("testdata/absolute/fern.jpg", []byte("not really a JPEG"), 0644))
+
+	p1 := &Page{Name: "testdata/absolute/fern", Body: []byte(`# Fern
+![A fern](fern.jpg)
+Curled fronds in the shade
+see [the cactus](cactus) and [home](/view/index)
+or <a href="dragon">the dragon</a>`)}
+	p1.save()
+
+	p2 := &Page{Name: "testdata/absolute/plants", Body: []byte(`# Plants
+* [My Fern](fern)`)}
+	p2.save()
+
+	// without a base URL, the forwarded headers are ignored unless they come from a trusted proxy
+	request := func() string {
+		r := httptest.NewRequest("GET", "/view/testdata/absolute/plants.rss", nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "example.org, proxy.example.org")
+		w := httptest.NewRecorder()
+		makeHandler(viewHandler, false, http.MethodGet)(w, r)
+		return w.Body.String()
+	}
+	t.Setenv("ODDMU_TRUSTED_PROXIES", "")
+	body := request()
+	assert.NotContains(t, body, "https://example.org/view/testdata/absolute/fern.jpg")
+	assert.Contains(t, body, `src=&#34;fern.jpg&#34;`)
+	assert.NotContains(t, body, "<enclosure")
+
+	// httptest requests come from 192.0.2.1
+	t.Setenv("ODDMU_TRUSTED_PROXIES", "192.0.2.0/24")
+	body = request()
+	assert.Contains(t, body, "https://example.org/view/testdata/absolute/fern.jpg")
+	assert.Contains(t, body, "https://example.org/view/testdata/absolute/cactus")
+	assert.Contains(t, body, "https://example.org/view/index")
+	assert.Contains(t, body, "https://example.org/view/testdata/absolute/dragon")
+	assert.Contains(t, body, "<description>A fern Curled fronds in…</description>")
+	assert.Contains(t, body, "<content:encoded>")
+	assert.Contains(t, body, `<enclosure url="https://example.org/view/testdata/absolute/fern.jpg" length="17" type="image/jpeg"/>`)
+
+	t.Setenv("ODDMU_BASE_URL", "https://example.com/wiki")
+	body = assert.HTTPBody(makeHandler(viewHandler, false, http.MethodGet), "GET", "/view/testdata/absolute/plants.json", nil)
+	assert.Contains(t, body, `"feed_url": "https://example.com/wiki/view/testdata/absolute/plants.json"`)
+	assert.Contains(t, body, `"summary": "A fern Curled fronds in…"`)
+	assert.Contains(t, body, `"size_in_bytes": 17`)
+}
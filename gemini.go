@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/gabriel-vasile/mimetype"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// geminiMaxRequest is the maximum length of a Gemini request: an absolute URL of up to 1024 bytes followed by CR LF.
+const geminiMaxRequest = 1026
+
+// geminiTimeout is how long a Gemini client has to send the request and to receive the response.
+const geminiTimeout = time.Minute
+
+// getGeminiTLSConfig returns the TLS configuration for the Gemini server. Gemini requires TLS. If the environment
+// variables ODDMU_GEMINI_CERT and ODDMU_GEMINI_KEY are set, they name the files with the certificate and the private
+// key, in PEM format. Since Gemini clients usually trust the certificate they see first, this can be a self-signed
+// certificate. Otherwise, a copy of the TLS configuration of the web server is used, without its application protocols;
+// see [getTLSConfig]. This way, both servers share the certificates, including those from ACME.
+func getGeminiTLSConfig(web *tls.Config) (*tls.Config, error) {
+	certFile := setting("gemini_cert")
+	keyFile := setting("gemini_key")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("ODDMU_GEMINI_CERT and ODDMU_GEMINI_KEY must both be set")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Using Gemini certificate", "file", certFile)
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}, nil
+	}
+	if web == nil {
+		return nil, errors.New("Gemini requires ODDMU_GEMINI_CERT and ODDMU_GEMINI_KEY, ODDMU_TLS_CERT and ODDMU_TLS_KEY, or ODDMU_ACME_DOMAINS")
+	}
+	config := web.Clone()
+	config.NextProtos = nil
+	return config, nil
+}
+
+// getGeminiListener returns a TLS listener on the address from ODDMU_ADDRESS and the port from ODDMU_GEMINI_PORT. See
+// [getGeminiTLSConfig].
+func getGeminiListener(web *tls.Config) (net.Listener, error) {
+	config, err := getGeminiTLSConfig(web)
+	if err != nil {
+		return nil, err
+	}
+	address := listenAddress(setting("address"), setting("gemini_port"))
+	slog.Info("Serving Gemini", "address", address)
+	return tls.Listen("tcp", address, config)
+}
+
+// serveGemini accepts Gemini connections on the listener until it is closed. Every connection is handled by
+// [geminiConnection].
+func serveGemini(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			slog.Warn("Accepting a Gemini connection failed", "err", err)
+			continue
+		}
+		go geminiConnection(conn)
+	}
+}
+
+// geminiConnection reads the request from the connection, writes the response using [geminiHandler] and closes the
+// connection. The request is a URL and its host determines the site, just like the Host header of an HTTP request;
+// see [siteStore.lookup]. Every request is logged with the path, the status code, the duration and the IP number.
+func geminiConnection(conn net.Conn) {
+	defer conn.Close()
+	start := time.Now()
+	conn.SetDeadline(start.Add(geminiTimeout))
+	w := bufio.NewWriter(conn)
+	r := bufio.NewReader(io.LimitReader(conn, geminiMaxRequest))
+	line, err := r.ReadString('\n')
+	var status int
+	var u *url.URL
+	if err == nil && strings.HasSuffix(line, "\r\n") {
+		u, err = url.Parse(strings.TrimSuffix(line, "\r\n"))
+	}
+	if err != nil || u == nil {
+		status = geminiHeader(w, 59, "Bad request")
+	} else {
+		status = geminiHandler(w, hostSites.lookup(u.Host), u)
+	}
+	err = w.Flush()
+	if err != nil {
+		slog.Debug("Gemini response failed", "err", err)
+	}
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	p := ""
+	if u != nil {
+		p = u.Path
+	}
+	slog.Info("Gemini request",
+		"path", p,
+		"status", status,
+		"duration", time.Since(start),
+		"ip", ip)
+}
+
+// geminiHeader writes the response header and returns the status code.
+func geminiHeader(w io.Writer, status int, meta string) int {
+	fmt.Fprintf(w, "%d %s\r\n", status, meta)
+	return status
+}
+
+// geminiHandler writes the response for a request and returns the status code. The URL paths are the page names, so
+// there is no "/view/" action; such URLs are redirected. The path "/search/" followed by a directory asks for a
+// search term (status 10) and searches the directory; see [geminiSearch]. Pages ending in ".rss", ".atom" or ".json"
+// are feeds; see [geminiFeed]. Pages are rendered as gemtext; see [geminiPage]. Other files are served as they are;
+// see [geminiFile]. Directories are redirected so that their paths end in a slash and for paths ending in a slash, the
+// index page is served. Hidden files and directories are not found.
+func geminiHandler(w io.Writer, s *site, u *url.URL) int {
+	if u.Scheme != "gemini" {
+		return geminiHeader(w, 53, "Proxy request refused")
+	}
+	name := strings.TrimPrefix(u.Path, "/")
+	if isHiddenName(name) {
+		return geminiHeader(w, 51, "Not found")
+	}
+	if rest, ok := strings.CutPrefix(name, "view/"); ok {
+		return geminiHeader(w, 31, geminiPath(rest))
+	}
+	if name == "search" || strings.HasPrefix(name, "search/") {
+		return geminiSearch(w, s, strings.TrimPrefix(strings.TrimPrefix(name, "search"), "/"), u.RawQuery)
+	}
+	ext := path.Ext(name)
+	format := strings.TrimPrefix(ext, ".")
+	if _, ok := feedFormats[format]; ok {
+		fi, err := s.store.stat(name[:len(name)-len(ext)] + ".md")
+		if err == nil && !fi.IsDir() {
+			return geminiFeed(w, s, name[:len(name)-len(ext)], fi.ModTime(), format)
+		}
+	}
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += "index"
+	}
+	fi, err := s.store.stat(name + ".md")
+	if err == nil && !fi.IsDir() {
+		return geminiPage(w, s, name)
+	}
+	fi, err = s.store.stat(storagePath(name))
+	if err != nil || path.Base(name) == "index" {
+		return geminiHeader(w, 51, "Not found")
+	}
+	if fi.IsDir() {
+		return geminiHeader(w, 31, geminiPath(name)+"/")
+	}
+	return geminiFile(w, s, name)
+}
+
+// geminiPath returns the absolute URL path for a page or file name, percent-escaped.
+func geminiPath(name string) string {
+	u := &url.URL{Path: "/" + name}
+	return u.EscapedPath()
+}
+
+// geminiPage writes a page as gemtext. The changes page, index pages and pages named like a hashtag are gemlogs; see
+// [indexStore.isGemlog].
+func geminiPage(w io.Writer, s *site, name string) int {
+	p, err := s.loadPage(name)
+	if err != nil {
+		return geminiHeader(w, 40, "Cannot load page")
+	}
+	s.index.RLock()
+	gemlog := s.index.isGemlog(name)
+	s.index.RUnlock()
+	meta := "text/gemini"
+	lang := p.Language()
+	if lang != "" && !strings.Contains(lang, ",") {
+		meta += "; lang=" + lang
+	}
+	geminiHeader(w, 20, meta)
+	io.WriteString(w, p.renderGemtext(gemlog))
+	return 20
+}
+
+// geminiFile writes a file. The MIME type is determined by the extension or by sniffing.
+func geminiFile(w io.Writer, s *site, name string) int {
+	data, err := s.store.readFile(storagePath(name))
+	if err != nil {
+		return geminiHeader(w, 40, "Cannot read file")
+	}
+	mimeType := mime.TypeByExtension(path.Ext(name))
+	if path.Ext(name) == ".gmi" {
+		mimeType = "text/gemini"
+	} else if mimeType == "" {
+		mimeType = mimetype.Detect(data).String()
+	}
+	geminiHeader(w, 20, mimeType)
+	w.Write(data)
+	return 20
+}
+
+// geminiFeed writes the feed for a page in the given format ("rss", "atom" or "json"), with the ten most recent items.
+// The links in the feed are relative unless ODDMU_BASE_URL is set. See [renderFeed] for the web server.
+func geminiFeed(w io.Writer, s *site, name string, ti time.Time, format string) int {
+	p, err := s.loadPage(name)
+	if err != nil {
+		return geminiHeader(w, 40, "Cannot load page")
+	}
+	p.handleTitle(true)
+	f := feed(p, ti, 0, 10, siteFeedOptions(s))
+	var b strings.Builder
+	mimeType := "application/rss+xml"
+	switch format {
+	case "json":
+		mimeType = "application/feed+json"
+		err = writeJsonFeed(&b, f)
+	case "atom":
+		mimeType = "application/atom+xml"
+		fallthrough
+	default:
+		b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+		err = s.templates.execute(&b, p.Dir(), strings.TrimSuffix(feedFormats[format], ".html"), f)
+	}
+	if err != nil {
+		return geminiHeader(w, 40, "Cannot write feed")
+	}
+	geminiHeader(w, 20, mimeType)
+	io.WriteString(w, b.String())
+	return 20
+}
+
+// geminiSearch writes the search results for a directory as gemtext. Without a query, the client is asked for the
+// search terms. The query is the search term, percent-escaped. For the hashtag links in pages, the query may also
+// consist of form parameters: "q" for the search terms and "page" for the page of results. A link to the next page of
+// results is added if there are more results. The filter for the directory applies; see [dirSetting].
+func geminiSearch(w io.Writer, s *site, dir, query string) int {
+	if query == "" {
+		return geminiHeader(w, 10, "Search")
+	}
+	var q string
+	page := 1
+	if strings.HasPrefix(query, "q=") {
+		v, err := url.ParseQuery(query)
+		if err != nil {
+			return geminiHeader(w, 59, "Bad request")
+		}
+		q = v.Get("q")
+		n, err := strconv.Atoi(v.Get("page"))
+		if err == nil && n > 1 {
+			page = n
+		}
+	} else {
+		var err error
+		q, err = url.PathUnescape(query)
+		if err != nil {
+			return geminiHeader(w, 59, "Bad request")
+		}
+	}
+	items, more := s.search(q, dir, s.dirSetting("filter", dir), page, false)
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Search for %s\n\n", q)
+	for _, r := range items {
+		fmt.Fprintf(&b, "=> %s %s\n", geminiPath(r.Name), r.Title)
+	}
+	if len(items) == 0 {
+		b.WriteString("No results.\n")
+	}
+	if more {
+		fmt.Fprintf(&b, "\n=> %s?q=%s&page=%d More results\n", geminiPath("search/"+dir), url.QueryEscape(q), page+1)
+	}
+	geminiHeader(w, 20, "text/gemini")
+	io.WriteString(w, b.String())
+	return 20
+}
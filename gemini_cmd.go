@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+type geminiCmd struct {
+	gemlog bool
+}
+
+func (*geminiCmd) Name() string     { return "gemini" }
+func (*geminiCmd) Synopsis() string { return "render a page as gemtext" }
+func (*geminiCmd) Usage() string {
+	return `gemini [-gemlog] <page name> ...:
+  Render one or more pages as gemtext, for Gemini.
+  Use a single - to read Markdown from stdin.
+`
+}
+
+func (cmd *geminiCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&cmd.gemlog, "gemlog", false,
+		"add dates to the link lines for a gemlog (the default for changes, index and hashtag pages)")
+}
+
+func (cmd *geminiCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	index.load()
+	return geminiCli(os.Stdout, cmd.gemlog, f.Args())
+}
+
+func geminiCli(w io.Writer, gemlog bool, args []string) subcommands.ExitStatus {
+	index.RLock()
+	defer index.RUnlock()
+	if len(args) == 1 && args[0] == "-" {
+		body, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read from stdin: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		p := &Page{Name: "stdin", Body: body}
+		return p.printGemtext(w, gemlog)
+	}
+	for _, name := range args {
+		if !strings.HasSuffix(name, ".md") {
+			fmt.Fprintf(os.Stderr, "%s does not end in '.md'\n", name)
+			return subcommands.ExitFailure
+		}
+		name = name[0 : len(name)-3]
+		p, err := loadPage(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot load %s: %s\n", name, err)
+			return subcommands.ExitFailure
+		}
+		status := p.printGemtext(w, gemlog || isGemlog(p.Name))
+		if status != subcommands.ExitSuccess {
+			return status
+		}
+	}
+	return subcommands.ExitSuccess
+}
+
+// isGemlog reports whether a page (without the ".md" suffix) is rendered as a gemlog: the changes page and the pages
+// that get a feed, i.e. index pages and pages that might be used as a hashtag. The caller must hold a read lock on the
+// index.
+func isGemlog(name string) bool {
+	return index.isGemlog(name)
+}
+
+// isGemlog reports whether a page of the site is rendered as a gemlog. See [isGemlog].
+func (idx *indexStore) isGemlog(name string) bool {
+	base := path.Base(name)
+	_, ok := idx.token[strings.ToLower(base)]
+	return base == "changes" || base == "index" || ok
+}
+
+func (p *Page) printGemtext(w io.Writer, gemlog bool) subcommands.ExitStatus {
+	_, err := io.WriteString(w, p.renderGemtext(gemlog))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write to stdout: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
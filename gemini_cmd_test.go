@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"github.com/google/subcommands"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGeminiCmd(t *testing.T) {
+	b := new(bytes.Buffer)
+	s := geminiCli(b, false, []string{"index.md"})
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	r := `# Welcome to Oddμ
+
+Hello! 🙃
+
+Check out the README and themes.
+=> README
+=> themes
+
+Or create a new page.
+=> test create a new page
+`
+	assert.Equal(t, r, b.String())
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+// geminiServer starts a Gemini server with a self-signed certificate for localhost and returns a function to make
+// requests. The function returns the response header and the body.
+func geminiServer(t *testing.T) func(string) (string, string) {
+	cleanup(t, "testdata/gemini-tls")
+	assert.NoError(t, os.MkdirAll("testdata/gemini-tls", 0755))
+	cert, key := selfSigned(t, "localhost")
+	assert.NoError(t, os.WriteFile("testdata/gemini-tls/cert.pem", cert, 0644))
+	assert.NoError(t, os.WriteFile("testdata/gemini-tls/key.pem", key, 0600))
+	t.Setenv("ODDMU_GEMINI_CERT", "testdata/gemini-tls/cert.pem")
+	t.Setenv("ODDMU_GEMINI_KEY", "testdata/gemini-tls/key.pem")
+	config, err := getGeminiTLSConfig(nil)
+	assert.NoError(t, err)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	assert.NoError(t, err)
+	go serveGemini(listener)
+	t.Cleanup(func() { listener.Close() })
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(cert)
+	return func(request string) (string, string) {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+		assert.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, request+"\r\n")
+		assert.NoError(t, err)
+		b, err := io.ReadAll(conn)
+		assert.NoError(t, err)
+		header, body, ok := strings.Cut(string(b), "\r\n")
+		assert.True(t, ok, "no header in %q", string(b))
+		return header, body
+	}
+}
+
+func TestGeminiTLSConfigNone(t *testing.T) {
+	_, err := getGeminiTLSConfig(nil)
+	assert.Error(t, err)
+	t.Setenv("ODDMU_GEMINI_CERT", "cert.pem")
+	_, err = getGeminiTLSConfig(nil)
+	assert.Error(t, err)
+}
+
+func TestGeminiTLSConfigWeb(t *testing.T) {
+	web := &tls.Config{NextProtos: []string{"h2", "http/1.1", "acme-tls/1"}, MinVersion: tls.VersionTLS12}
+	config, err := getGeminiTLSConfig(web)
+	assert.NoError(t, err)
+	assert.Empty(t, config.NextProtos)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	// the web server still negotiates its protocols
+	assert.Len(t, web.NextProtos, 3)
+}
+
+func TestGeminiServer(t *testing.T) {
+	cleanup(t, "testdata/gemini-server")
+	assert.NoError(t, os.MkdirAll("testdata/gemini-server/.secret", 0755))
+	assert.NoError(t, os.WriteFile("testdata/gemini-server/index.md", []byte(`# Bees
+
+* [Honey](honey)
+
+Read about the #Hive and its bees.
+`), 0644))
+	assert.NoError(t, os.WriteFile("testdata/gemini-server/honey.md", []byte("# Honey\n\nSweet and golden. #Hive\n"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/gemini-server/notes.txt", []byte("Buzz\n"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/gemini-server/.secret/index.md", []byte("# Secret\n"), 0644))
+	index.load()
+	get := geminiServer(t)
+
+	header, body := get("gemini://localhost/testdata/gemini-server/")
+	assert.True(t, strings.HasPrefix(header, "20 text/gemini"), header)
+	assert.Contains(t, body, "# Bees\n")
+	assert.Contains(t, body, "=> honey Honey\n")
+	assert.Contains(t, body, "=> /search/?q=%23Hive #Hive\n")
+
+	header, body = get("gemini://localhost/testdata/gemini-server/honey")
+	assert.True(t, strings.HasPrefix(header, "20 text/gemini"), header)
+	assert.Contains(t, body, "Sweet and golden.")
+
+	header, body = get("gemini://localhost/testdata/gemini-server/notes.txt")
+	assert.True(t, strings.HasPrefix(header, "20 text/plain"), header)
+	assert.Equal(t, "Buzz\n", body)
+
+	header, _ = get("gemini://localhost/testdata/gemini-server")
+	assert.Equal(t, "31 /testdata/gemini-server/", header)
+
+	header, _ = get("gemini://localhost/view/testdata/gemini-server/honey")
+	assert.Equal(t, "31 /testdata/gemini-server/honey", header)
+
+	header, _ = get("gemini://localhost/search/testdata/gemini-server/")
+	assert.Equal(t, "10 Search", header)
+
+	header, body = get("gemini://localhost/search/testdata/gemini-server/?golden")
+	assert.Equal(t, "20 text/gemini", header)
+	assert.Contains(t, body, "=> /testdata/gemini-server/honey Honey\n")
+
+	// the hashtag links of pages
+	_, body = get("gemini://localhost/search/testdata/gemini-server/?q=%23Hive")
+	assert.Contains(t, body, "=> /testdata/gemini-server/honey Honey\n")
+
+	header, body = get("gemini://localhost/testdata/gemini-server/index.atom")
+	assert.Equal(t, "20 application/atom+xml", header)
+	assert.Contains(t, body, "<title>Honey</title>")
+
+	header, body = get("gemini://localhost/testdata/gemini-server/index.json")
+	assert.Equal(t, "20 application/feed+json", header)
+	assert.Contains(t, body, `"title": "Honey"`)
+
+	header, _ = get("gemini://localhost/testdata/gemini-server/.secret/index")
+	assert.Equal(t, "51 Not found", header)
+
+	header, _ = get("gemini://localhost/testdata/gemini-server/bumblebee")
+	assert.Equal(t, "51 Not found", header)
+
+	header, _ = get("https://localhost/testdata/gemini-server/honey")
+	assert.Equal(t, "53 Proxy request refused", header)
+
+	header, _ = get(strings.Repeat("x", 1025))
+	assert.Equal(t, "59 Bad request", header)
+}
+
+func TestGeminiListener(t *testing.T) {
+	cleanup(t, "testdata/gemini-tls")
+	assert.NoError(t, os.MkdirAll("testdata/gemini-tls", 0755))
+	cert, key := selfSigned(t, "localhost")
+	assert.NoError(t, os.WriteFile("testdata/gemini-tls/cert.pem", cert, 0644))
+	assert.NoError(t, os.WriteFile("testdata/gemini-tls/key.pem", key, 0600))
+	t.Setenv("ODDMU_GEMINI_CERT", "testdata/gemini-tls/cert.pem")
+	t.Setenv("ODDMU_GEMINI_KEY", "testdata/gemini-tls/key.pem")
+	t.Setenv("ODDMU_ADDRESS", "127.0.0.1")
+	t.Setenv("ODDMU_GEMINI_PORT", "0")
+	listener, err := getGeminiListener(nil)
+	assert.NoError(t, err)
+	defer listener.Close()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	assert.NoError(t, err)
+	assert.NotEqual(t, "0", port)
+}
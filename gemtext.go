@@ -0,0 +1,232 @@
+package main
+
+import (
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// gemtextDateRe matches headings that are just a date, as used on the changes page.
+var gemtextDateRe = regexp.MustCompile(`^\d\d\d\d-\d\d-\d\d$`)
+
+// gemtextWriter renders the Markdown AST as gemtext, the markup used by Gemini. Gemtext only knows headings up to
+// level three, unnested lists, quotes, preformatted blocks and links on lines of their own. The links of a paragraph,
+// list or quote are collected and written after it.
+type gemtextWriter struct {
+	b strings.Builder
+
+	// links are the link lines waiting to be written.
+	links []string
+
+	// gemlog is set if link lines for dated pages start with the date. See https://geminiprotocol.net/docs/companion/subscription.gmi
+	gemlog bool
+
+	// date is the date of the last heading that was just a date.
+	date string
+}
+
+// renderGemtext renders the Page.Body to gemtext. If gemlog is set, the link lines of list items with nothing but a
+// link start with a date, if possible, so that the page can be subscribed to. The date is the date the page name starts
+// with, if it is a blog page, or the date of the last heading that is just a date, as on the changes page.
+func (p *Page) renderGemtext(gemlog bool) string {
+	parser, _ := wikiParser()
+	return gemtext(markdown.Parse(p.Body, parser), gemlog)
+}
+
+// gemtext renders a Markdown document as gemtext. See [Page.renderGemtext].
+func gemtext(doc ast.Node, gemlog bool) string {
+	w := &gemtextWriter{gemlog: gemlog}
+	w.block(doc)
+	return strings.TrimRight(w.b.String(), "\n") + "\n"
+}
+
+// block writes a block node.
+func (w *gemtextWriter) block(node ast.Node) {
+	switch v := node.(type) {
+	case *ast.Document:
+		for _, child := range v.Children {
+			w.block(child)
+		}
+	case *ast.Heading:
+		text := w.inline(v)
+		if gemtextDateRe.MatchString(text) {
+			w.date = text
+		}
+		w.b.WriteString(strings.Repeat("#", min(v.Level, 3)) + " " + text + "\n")
+		w.flush()
+	case *ast.Paragraph:
+		if line, ok := w.only(v); ok {
+			w.b.WriteString(line + "\n\n")
+			return
+		}
+		w.b.WriteString(w.inline(v) + "\n")
+		w.flush()
+	case *ast.List:
+		w.list(v)
+		w.flush()
+	case *ast.BlockQuote:
+		for _, child := range v.Children {
+			if p, ok := child.(*ast.Paragraph); ok {
+				w.b.WriteString("> " + w.inline(p) + "\n")
+			}
+		}
+		w.flush()
+	case *ast.CodeBlock:
+		w.b.WriteString("```" + string(v.Info) + "\n" + string(v.Literal))
+		if !strings.HasSuffix(string(v.Literal), "\n") {
+			w.b.WriteString("\n")
+		}
+		w.b.WriteString("```\n\n")
+	case *ast.Table:
+		w.table(v)
+		w.flush()
+	case *ast.HorizontalRule:
+		w.b.WriteString("-----\n\n")
+	default:
+		// raw HTML and the like are skipped
+	}
+}
+
+// flush writes the link lines collected, followed by an empty line.
+func (w *gemtextWriter) flush() {
+	for _, line := range w.links {
+		w.b.WriteString(line + "\n")
+	}
+	w.links = nil
+	w.b.WriteString("\n")
+}
+
+// list writes the items of a list, flattening nested lists. Items with nothing but a link become link lines.
+func (w *gemtextWriter) list(l *ast.List) {
+	for _, child := range l.Children {
+		item, ok := child.(*ast.ListItem)
+		if !ok {
+			continue
+		}
+		for _, c := range item.Children {
+			switch v := c.(type) {
+			case *ast.List:
+				w.list(v)
+			case *ast.Paragraph:
+				if line, ok := w.only(v); ok {
+					w.b.WriteString(line + "\n")
+				} else {
+					w.b.WriteString("* " + w.inline(v) + "\n")
+				}
+			}
+		}
+	}
+}
+
+// table writes a table as a preformatted block with the columns aligned.
+func (w *gemtextWriter) table(t *ast.Table) {
+	rows := [][]string{}
+	widths := []int{}
+	ast.WalkFunc(t, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch v := node.(type) {
+		case *ast.TableRow:
+			if entering {
+				rows = append(rows, []string{})
+			}
+		case *ast.TableCell:
+			if entering {
+				text := w.inline(v)
+				i := len(rows[len(rows)-1])
+				if i >= len(widths) {
+					widths = append(widths, 0)
+				}
+				widths[i] = max(widths[i], utf8.RuneCountInString(text))
+				rows[len(rows)-1] = append(rows[len(rows)-1], text)
+				return ast.SkipChildren
+			}
+		}
+		return ast.GoToNext
+	})
+	w.b.WriteString("```\n")
+	for _, row := range rows {
+		line := ""
+		for i, cell := range row {
+			if i > 0 {
+				line += "  "
+			}
+			line += cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+		}
+		w.b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	w.b.WriteString("```\n")
+}
+
+// only returns the link line if a paragraph contains nothing but a link or an image.
+func (w *gemtextWriter) only(p *ast.Paragraph) (string, bool) {
+	children := []ast.Node{}
+	for _, child := range p.Children {
+		if t, ok := child.(*ast.Text); ok && strings.TrimSpace(string(t.Literal)) == "" {
+			continue
+		}
+		children = append(children, child)
+	}
+	if len(children) != 1 {
+		return "", false
+	}
+	switch v := children[0].(type) {
+	case *ast.Link:
+		return w.link(string(v.Destination), w.inline(v), true), true
+	case *ast.Image:
+		return w.link(string(v.Destination), w.inline(v), false), true
+	}
+	return "", false
+}
+
+// link returns a link line. For a gemlog, links to pages start with the date, if known.
+func (w *gemtextWriter) link(dest, label string, page bool) string {
+	if label == "" || label == dest {
+		return "=> " + dest
+	}
+	if w.gemlog && page {
+		date := blogRe.FindString(path.Base(dest))
+		if date == "" {
+			date = w.date
+		}
+		if date != "" && !strings.HasPrefix(label, date) {
+			label = date + " " + label
+		}
+	}
+	return "=> " + dest + " " + label
+}
+
+// inline returns the text of a node's children on a single line. Links and images are collected and their labels
+// are used as text.
+func (w *gemtextWriter) inline(node ast.Node) string {
+	var b strings.Builder
+	for _, child := range node.GetChildren() {
+		switch v := child.(type) {
+		case *ast.Text:
+			b.WriteString(html.UnescapeString(strings.ReplaceAll(string(v.Literal), "\n", " ")))
+		case *ast.Code:
+			b.WriteString("`" + string(v.Literal) + "`")
+		case *ast.Hardbreak, *ast.Softbreak:
+			b.WriteString(" ")
+		case *ast.Link:
+			label := w.inline(v)
+			w.links = append(w.links, w.link(string(v.Destination), label, false))
+			b.WriteString(label)
+		case *ast.Image:
+			label := w.inline(v)
+			w.links = append(w.links, w.link(string(v.Destination), label, false))
+			b.WriteString(label)
+		case *ast.HTMLSpan:
+			// skipped
+		default:
+			if leaf := child.AsLeaf(); leaf != nil {
+				b.WriteString(string(leaf.Literal))
+			} else {
+				b.WriteString(w.inline(child))
+			}
+		}
+	}
+	return b.String()
+}
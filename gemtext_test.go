@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGemtext(t *testing.T) {
+	p := &Page{Body: []byte(`# Solar system
+
+The [sun](sun) is a *star* and the [moon](moon) is not.
+
+#### Planets
+
+* Mercury
+  * Venus, see [[Venus]]
+* [Earth](earth)
+
+> Space is big.
+
+![The sky](sky.jpg)
+
+` + "```" + `
+  orbit
+` + "```" + `
+
+| Name | Moons |
+|------|-------|
+| Mars | 2 |
+| Jupiter | 95 |
+
+#Astronomy
+`)}
+	assert.Equal(t, `# Solar system
+
+The sun is a star and the moon is not.
+=> sun
+=> moon
+
+### Planets
+
+* Mercury
+* Venus, see Venus
+=> earth Earth
+=> Venus
+
+> Space is big.
+
+=> sky.jpg The sky
+
+`+"```"+`
+  orbit
+`+"```"+`
+
+`+"```"+`
+Name     Moons
+Mars     2
+Jupiter  95
+`+"```"+`
+
+=> /search/?q=%23Astronomy #Astronomy
+`, p.renderGemtext(false))
+}
+
+func TestGemlog(t *testing.T) {
+	p := &Page{Body: []byte(`# Changes
+
+## 2024-03-09
+* [Moon](moon)
+* [Sun](2024-03-01-sun)
+`)}
+	assert.Equal(t, `# Changes
+
+## 2024-03-09
+
+=> moon 2024-03-09 Moon
+=> 2024-03-01-sun 2024-03-01 Sun
+`, p.renderGemtext(true))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// defaultContentSecurityPolicy is the Content-Security-Policy used unless ODDMU_CSP is set. Inline scripts and styles
+// are allowed because the default templates use them. Images, video, audio and iframes may come from other sites using
+// HTTPS. Forms may only be submitted to the wiki and the wiki may only be framed by itself.
+const defaultContentSecurityPolicy = "default-src 'self'; img-src 'self' https: data:; media-src 'self' https:; " +
+	"style-src 'self' 'unsafe-inline'; script-src 'self' 'unsafe-inline'; frame-src https:; object-src 'none'; " +
+	"base-uri 'self'; form-action 'self'; frame-ancestors 'self'"
+
+// sandboxContentSecurityPolicy is the Content-Security-Policy for uploaded files that would otherwise be able to run
+// scripts in the context of the wiki.
+const sandboxContentSecurityPolicy = "sandbox; default-src 'none'; img-src 'self' data:; style-src 'unsafe-inline'"
+
+// defaultFilePolicies maps MIME types to the way uploaded files of that type are served. See filePolicies.
+var defaultFilePolicies = map[string]string{
+	"text/html":             "sandbox",
+	"application/xhtml+xml": "sandbox",
+	"image/svg+xml":         "sandbox",
+	"text/xml":              "sandbox",
+	"application/xml":       "sandbox",
+}
+
+// securityHeaders wraps a handler and sets the Content-Security-Policy, X-Content-Type-Options, Referrer-Policy and
+// X-Frame-Options headers on every response. The Content-Security-Policy is taken from the environment variable
+// ODDMU_CSP, if set. If ODDMU_CSP is set to the empty string, no Content-Security-Policy header is sent.
+func securityHeaders(h http.Handler) http.Handler {
+	csp, ok := lookupSetting("csp")
+	if !ok {
+		csp = defaultContentSecurityPolicy
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if csp != "" {
+			w.Header().Set("Content-Security-Policy", csp)
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// filePolicies returns the map of MIME types to the way uploaded files of that type are served: "attachment" means
+// that the browser downloads the file instead of showing it, "sandbox" means that the file is shown with a
+// Content-Security-Policy that doesn't allow scripts, and "inline" means that the file is shown as is. The defaults
+// are in defaultFilePolicies. The environment variable ODDMU_FILE_POLICY can add to them and override them using a
+// comma-separated list of MIME types and policies, e.g. "text/html=attachment,application/pdf=attachment". The setting
+// is taken from the site.
+func filePolicies(w *site) map[string]string {
+	policies := make(map[string]string)
+	for mimeType, policy := range defaultFilePolicies {
+		policies[mimeType] = policy
+	}
+	s := w.setting("file_policy")
+	if s == "" {
+		return policies
+	}
+	for _, entry := range strings.Split(s, ",") {
+		mimeType, policy, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || (policy != "attachment" && policy != "sandbox" && policy != "inline") {
+			slog.Warn("ODDMU_FILE_POLICY entry is invalid", "entry", entry)
+			continue
+		}
+		policies[strings.ToLower(mimeType)] = policy
+	}
+	return policies
+}
+
+// fileHeaders sets the headers for an uploaded file of the given MIME type according to its policy. See filePolicies.
+func fileHeaders(w http.ResponseWriter, s *site, mimeType string) {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = mimeType
+	}
+	switch filePolicies(s)[strings.ToLower(mediaType)] {
+	case "attachment":
+		w.Header().Set("Content-Disposition", "attachment")
+	case "sandbox":
+		w.Header().Set("Content-Security-Policy", sandboxContentSecurityPolicy)
+	}
+}
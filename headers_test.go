@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	h := securityHeaders(makeHandler(viewHandler, false, http.MethodGet))
+	r := httptest.NewRequest("GET", "/view/index", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, defaultContentSecurityPolicy, w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+	t.Setenv("ODDMU_CSP", "")
+	h = securityHeaders(makeHandler(viewHandler, false, http.MethodGet))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+}
+
+func TestFileHeaders(t *testing.T) {
+	cleanup(t, "testdata/headers")
+	assert.NoError(t, os.MkdirAll("testdata/headers", 0755))
+	assert.NoError(t, os.WriteFile("testdata/headers/evil.html", []byte("<script>alert('Gotcha!')</script>"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/headers/evil.svg", []byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/headers/fine.txt", []byte("Hello"), 0644))
+	h := securityHeaders(makeHandler(viewHandler, false, http.MethodGet))
+	get := func(url string) http.Header {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		return w.Header()
+	}
+	header := get("/view/testdata/headers/evil.html")
+	assert.Equal(t, sandboxContentSecurityPolicy, header.Get("Content-Security-Policy"))
+	assert.Empty(t, header.Get("Content-Disposition"))
+	header = get("/view/testdata/headers/evil.svg")
+	assert.Equal(t, sandboxContentSecurityPolicy, header.Get("Content-Security-Policy"))
+	header = get("/view/testdata/headers/fine.txt")
+	assert.Equal(t, defaultContentSecurityPolicy, header.Get("Content-Security-Policy"))
+	t.Setenv("ODDMU_FILE_POLICY", "text/html=attachment, text/plain=attachment")
+	header = get("/view/testdata/headers/evil.html")
+	assert.Equal(t, "attachment", header.Get("Content-Disposition"))
+	header = get("/view/testdata/headers/fine.txt")
+	assert.Equal(t, "attachment", header.Get("Content-Disposition"))
+}
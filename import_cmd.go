@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type importCmd struct {
+	from string
+}
+
+func (cmd *importCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.from, "from", "", "the format to import: oddmuse, mediawiki, dokuwiki or obsidian")
+}
+
+func (*importCmd) Name() string     { return "import" }
+func (*importCmd) Synopsis() string { return "import pages and files from other wikis" }
+func (*importCmd) Usage() string {
+	return `import -from <format> <source>:
+  Import the pages and files of another wiki, converting the markup
+  to Markdown. The format is one of oddmuse, mediawiki, dokuwiki or
+  obsidian. The source is a directory or a file, depending on the
+  format: an Oddmuse data directory, page file or keep file; a
+  MediaWiki XML dump; a DokuWiki data directory; an Obsidian vault.
+  Existing files are not overwritten. Whatever could not be converted
+  is reported.
+`
+}
+
+func (cmd *importCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	args := f.Args()
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Exactly one source is required")
+		return subcommands.ExitFailure
+	}
+	return importCli(os.Stdout, defaultSite.store, cmd.from, args[0])
+}
+
+// importItem is a page or file to import. The name is the name used by the storage, including the extension. If
+// there is no data, the item is not imported and only its problems are reported.
+type importItem struct {
+	name     string
+	data     []byte
+	modTime  time.Time
+	problems []string
+}
+
+// problem adds a problem to the item.
+func (it *importItem) problem(format string, a ...any) {
+	it.problems = append(it.problems, fmt.Sprintf(format, a...))
+}
+
+// importer reads the pages and files of a source and converts the pages to Markdown.
+type importer func(source string) ([]*importItem, error)
+
+// importers maps the formats to their importers.
+var importers = map[string]importer{
+	"oddmuse":   importOddmuse,
+	"mediawiki": importMediaWiki,
+	"dokuwiki":  importDokuWiki,
+	"obsidian":  importObsidian,
+}
+
+// importCli imports the pages and files from the source into the storage. Files that already exist are skipped.
+// Modification times are preserved. The names of the files that were skipped and the problems found are printed,
+// followed by a summary. If the storage is a git repository, all the files imported are committed together.
+func importCli(w io.Writer, store storage, from, source string) subcommands.ExitStatus {
+	fn, ok := importers[from]
+	if !ok {
+		formats := []string{}
+		for format := range importers {
+			formats = append(formats, format)
+		}
+		slices.Sort(formats)
+		fmt.Fprintf(os.Stderr, "Unknown format %q, use one of %s\n", from, strings.Join(formats, ", "))
+		return subcommands.ExitFailure
+	}
+	items, err := fn(source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return subcommands.ExitFailure
+	}
+	slices.SortFunc(items, func(a, b *importItem) int { return strings.Compare(a.name, b.name) })
+	files := store
+	g, isGit := store.(*gitStorage)
+	if isGit {
+		files = &g.fileStorage
+	}
+	names := []string{}
+	problems := 0
+	for _, it := range items {
+		for _, p := range it.problems {
+			fmt.Fprintf(w, "%s: %s\n", it.name, p)
+			problems++
+		}
+		if it.data == nil {
+			continue
+		}
+		if !fs.ValidPath(it.name) || isHiddenName(it.name) {
+			fmt.Fprintf(w, "%s: invalid name, skipped\n", it.name)
+			problems++
+			continue
+		}
+		_, err := store.stat(it.name)
+		if err == nil {
+			fmt.Fprintf(w, "%s: exists, skipped\n", it.name)
+			problems++
+			continue
+		}
+		err = files.writeFile(it.name, it.data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Writing %s: %s\n", it.name, err)
+			return subcommands.ExitFailure
+		}
+		if !it.modTime.IsZero() {
+			err = files.touch(it.name, it.modTime)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Setting the modification time of %s: %s\n", it.name, err)
+				return subcommands.ExitFailure
+			}
+		}
+		names = append(names, it.name)
+	}
+	if isGit && len(names) > 0 {
+		err = g.commit("Import from "+from, names...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return subcommands.ExitFailure
+		}
+	}
+	fmt.Fprintf(w, "Imported %d files with %d problems\n", len(names), problems)
+	return subcommands.ExitSuccess
+}
+
+// importLink returns the URL of a link from one page or file to another, relative to the directory of the first one.
+// Both are names used by the storage. Pages have no extension. The second name may end in a fragment.
+func importLink(from, to string) string {
+	to, fragment, _ := strings.Cut(to, "#")
+	s := to
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(from)), filepath.FromSlash(to))
+	if err == nil {
+		s = filepath.ToSlash(rel)
+	}
+	s = nameEscape(s)
+	if fragment != "" {
+		s += "#" + url.PathEscape(fragment)
+	}
+	return s
+}
+
+// importAnchor returns the anchor for a heading, just like the Markdown parser computes them: lower case letters and
+// numbers, separated by dashes.
+func importAnchor(s string) string {
+	var anchor []rune
+	dash := false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if dash && len(anchor) > 0 {
+				anchor = append(anchor, '-')
+			}
+			dash = false
+			anchor = append(anchor, unicode.ToLower(r))
+		} else {
+			dash = true
+		}
+	}
+	return string(anchor)
+}
+
+// importHashtag returns a hashtag for a tag or category. Spaces are replaced by underscores.
+func importHashtag(s string) string {
+	return "#" + strings.ReplaceAll(strings.TrimSpace(s), " ", "_")
+}
+
+// importTable returns a Markdown table row for the cells. The first row of a table is followed by the separator
+// between the header and the rest of the table.
+func importTable(cells []string, first bool) string {
+	for i, cell := range cells {
+		cells[i] = strings.TrimSpace(cell)
+	}
+	s := "| " + strings.Join(cells, " | ") + " |"
+	if first {
+		s += "\n|" + strings.Repeat(" --- |", len(cells))
+	}
+	return s
+}
+
+// importFile returns an item for a file that is copied as it is, with its modification time.
+func importFile(fp, name string) (*importItem, error) {
+	data, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	it := &importItem{name: name, data: data}
+	fi, err := os.Stat(fp)
+	if err == nil {
+		it.modTime = fi.ModTime()
+	}
+	return it, nil
+}
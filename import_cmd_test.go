@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"github.com/google/subcommands"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// importTest imports the source into a new directory and returns the output.
+func importTest(t *testing.T, from, source, dir string) string {
+	b := new(bytes.Buffer)
+	s := importCli(b, &fileStorage{root: dir}, from, source)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	return b.String()
+}
+
+func TestImportOddmuse(t *testing.T) {
+	cleanup(t, "testdata/import-oddmuse")
+	assert.NoError(t, os.MkdirAll("testdata/import-oddmuse/keep", 0755))
+	assert.NoError(t, os.WriteFile("testdata/import-oddmuse/keep/Moon.kp", []byte(`ts: 1700000000
+revision: 1
+text: = Moon =
+	The moon is old.
+`+"\x1e"+`ts: 1710000000
+revision: 2
+text: = Moon =
+	The ''moon'' is '''bright'''. See [[Sun]] and [[Other Planets|the others]].
+	* [[image:Moon Picture]]
+	* [http://example.org/ Example]
+	[[tag:Night Sky]]
+`), 0644))
+	assert.NoError(t, os.WriteFile("testdata/import-oddmuse/keep/Moon_Picture.kp", []byte(`ts: 1700000000
+revision: 1
+text: #FILE image/png
+	iVBORw0KGgo=
+`), 0644))
+	out := importTest(t, "oddmuse", "testdata/import-oddmuse/keep", "testdata/import-oddmuse/wiki")
+	assert.Equal(t, "Imported 2 files with 0 problems\n", out)
+	b, err := os.ReadFile("testdata/import-oddmuse/wiki/Moon.md")
+	assert.NoError(t, err)
+	assert.Equal(t, `# Moon
+The *moon* is **bright**. See [Sun](Sun) and [the others](Other_Planets).
+* ![Moon_Picture.png](Moon_Picture.png)
+* [Example](http://example.org/)
+#Night_Sky`, string(b))
+	fi, err := os.Stat("testdata/import-oddmuse/wiki/Moon.md")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1710000000, 0), fi.ModTime())
+	b, err = os.ReadFile("testdata/import-oddmuse/wiki/Moon_Picture.png")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x89PNG\r\n\x1a\n"), b)
+	// existing files are skipped
+	out = importTest(t, "oddmuse", "testdata/import-oddmuse/keep", "testdata/import-oddmuse/wiki")
+	assert.Equal(t, `Moon.md: exists, skipped
+Moon_Picture.png: exists, skipped
+Imported 0 files with 2 problems
+`, out)
+	// files without a MIME type are reported
+	assert.NoError(t, os.MkdirAll("testdata/import-oddmuse/broken", 0755))
+	assert.NoError(t, os.WriteFile("testdata/import-oddmuse/broken/Nothing.kp", []byte(`ts: 1700000000
+revision: 1
+text: #FILE 
+	iVBORw0KGgo=
+`), 0644))
+	out = importTest(t, "oddmuse", "testdata/import-oddmuse/broken", "testdata/import-oddmuse/wiki")
+	assert.Equal(t, `Nothing: file without a MIME type not decoded
+Imported 0 files with 1 problems
+`, out)
+}
+
+func TestImportMediaWiki(t *testing.T) {
+	cleanup(t, "testdata/import-mediawiki")
+	assert.NoError(t, os.MkdirAll("testdata/import-mediawiki/dump", 0755))
+	assert.NoError(t, os.WriteFile("testdata/import-mediawiki/dump/Sun.jpg", []byte("sunny"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/import-mediawiki/dump/dump.xml", []byte(`<mediawiki>
+  <page>
+    <title>sun</title>
+    <ns>0</ns>
+    <revision>
+      <timestamp>2023-11-14T22:13:20Z</timestamp>
+      <text>Old text</text>
+    </revision>
+    <revision>
+      <timestamp>2024-03-09T16:00:00Z</timestamp>
+      <text>== The Sun ==
+The [[sun]] burns. {{Citation needed}}
+# [[File:Sun.jpg|thumb|left|The sun at noon]]
+# [[Moon#Phases|phases]]
+[[User:Alex]]
+[[Category:Stars]]</text>
+    </revision>
+  </page>
+  <page>
+    <title>Talk:Sun</title>
+    <ns>1</ns>
+    <revision>
+      <timestamp>2024-03-09T16:00:00Z</timestamp>
+      <text>Is it hot?</text>
+    </revision>
+  </page>
+</mediawiki>
+`), 0644))
+	out := importTest(t, "mediawiki", "testdata/import-mediawiki/dump/dump.xml", "testdata/import-mediawiki/wiki")
+	assert.Equal(t, `Sun.md: template Citation needed not converted
+Sun.md: link to User:Alex not converted
+Talk:Sun.md: namespace 1 skipped
+Imported 2 files with 3 problems
+`, out)
+	b, err := os.ReadFile("testdata/import-mediawiki/wiki/Sun.md")
+	assert.NoError(t, err)
+	assert.Equal(t, `## The Sun
+The [sun](Sun) burns. {{Citation needed}}
+1. ![The sun at noon](Sun.jpg)
+1. [phases](Moon#phases)
+User:Alex
+#Stars`, string(b))
+	fi, err := os.Stat("testdata/import-mediawiki/wiki/Sun.md")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 9, 16, 0, 0, 0, time.UTC), fi.ModTime().UTC())
+	assert.FileExists(t, "testdata/import-mediawiki/wiki/Sun.jpg")
+}
+
+func TestImportDokuWiki(t *testing.T) {
+	cleanup(t, "testdata/import-dokuwiki")
+	assert.NoError(t, os.MkdirAll("testdata/import-dokuwiki/data/pages/space", 0755))
+	assert.NoError(t, os.MkdirAll("testdata/import-dokuwiki/data/media/space", 0755))
+	assert.NoError(t, os.WriteFile("testdata/import-dokuwiki/data/media/space/moon.png", []byte("moony"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/import-dokuwiki/data/pages/start.txt", []byte(`====== Welcome ======
+Go to [[space:moon|the moon]] or [[wp>Moon]].
+`), 0644))
+	assert.NoError(t, os.WriteFile("testdata/import-dokuwiki/data/pages/space/moon.txt", []byte(`===== Moon =====
+The //moon// is ''grey''((Mostly.)).
+{{moon.png|Full moon}}
+  * [[:start]]
+  - [[#Phases]]
+^ Phase ^ Days ^
+| New | 0 |
+<code go>
+fmt.Println("moon")
+</code>
+{{tag>night sky}}
+`), 0644))
+	out := importTest(t, "dokuwiki", "testdata/import-dokuwiki/data", "testdata/import-dokuwiki/wiki")
+	assert.Equal(t, `space/moon.md: footnote not converted
+start.md: interwiki link wp>Moon not converted
+Imported 3 files with 2 problems
+`, out)
+	b, err := os.ReadFile("testdata/import-dokuwiki/wiki/start.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "# Welcome\nGo to [the moon](space/moon) or wp>Moon.\n", string(b))
+	b, err = os.ReadFile("testdata/import-dokuwiki/wiki/space/moon.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "## Moon\nThe *moon* is `grey`((Mostly.)).\n![Full moon](moon.png)\n"+
+		"* [:start](../start)\n1. [#Phases](moon#phases)\n"+
+		"| Phase | Days |\n| --- | --- |\n| New | 0 |\n"+
+		"```go\nfmt.Println(\"moon\")\n```\n#night #sky\n", string(b))
+	assert.FileExists(t, "testdata/import-dokuwiki/wiki/space/moon.png")
+}
+
+func TestImportObsidian(t *testing.T) {
+	cleanup(t, "testdata/import-obsidian")
+	assert.NoError(t, os.MkdirAll("testdata/import-obsidian/vault/.obsidian", 0755))
+	assert.NoError(t, os.MkdirAll("testdata/import-obsidian/vault/notes", 0755))
+	assert.NoError(t, os.MkdirAll("testdata/import-obsidian/vault/attachments", 0755))
+	assert.NoError(t, os.WriteFile("testdata/import-obsidian/vault/.obsidian/app.json", []byte("{}"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/import-obsidian/vault/attachments/moon.png", []byte("moony"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/import-obsidian/vault/notes/Sun.md", []byte("# Sun\n"), 0644))
+	assert.NoError(t, os.WriteFile("testdata/import-obsidian/vault/notes/Moon.md", []byte(`---
+tags: [night, night sky]
+aliases: Luna
+---
+# Moon
+Brighter than [[Stars]] but not the [[Sun]] or the [[Sun|day star]].
+%%Is it cheese?%%
+![[moon.png]]
+`), 0644))
+	out := importTest(t, "obsidian", "testdata/import-obsidian/vault", "testdata/import-obsidian/wiki")
+	assert.Equal(t, `notes/Moon.md: front matter aliases removed
+notes/Moon.md: comments removed
+notes/Moon.md: link to Stars not found
+Imported 3 files with 3 problems
+`, out)
+	b, err := os.ReadFile("testdata/import-obsidian/wiki/notes/Moon.md")
+	assert.NoError(t, err)
+	assert.Equal(t, `# Moon
+Brighter than [[Stars]] but not the [[Sun]] or the [day star](Sun).
+
+![moon.png](../attachments/moon.png)
+
+#night #night_sky
+`, string(b))
+	assert.NoDirExists(t, "testdata/import-obsidian/wiki/.obsidian")
+}
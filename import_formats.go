@@ -0,0 +1,805 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The Oddmuse and MediaWiki markup is similar enough to share the conversion. See [wikiMarkdown].
+var (
+	wikiHeadingRe  = regexp.MustCompile(`^(={1,6})\s*(.*?)\s*=*\s*$`)
+	wikiListRe     = regexp.MustCompile(`^([*#]+)\s*(.*)$`)
+	wikiIndentRe   = regexp.MustCompile(`^:+\s*`)
+	wikiLinkRe     = regexp.MustCompile(`\[\[([^\]|]+)(?:\|([^\]]*))?\]\]`)
+	wikiUrlRe      = regexp.MustCompile(`\[((?:https?|ftp|mailto|gemini):[^\s\]]+)(?:\s+([^\]]+))?\]`)
+	wikiBoldRe     = regexp.MustCompile(`'''(.+?)'''`)
+	wikiItalicRe   = regexp.MustCompile(`''(.+?)''`)
+	wikiRedirectRe = regexp.MustCompile(`(?i)^#REDIRECT\s*(\[\[[^\]]+\]\])`)
+	wikiTemplateRe = regexp.MustCompile(`\{\{\s*([^{}|]+)`)
+	wikiMagicRe    = regexp.MustCompile(`__[A-Z]+__`)
+	wikiNowikiRe   = regexp.MustCompile(`</?nowiki\s*/?>`)
+)
+
+// wikiMarkdown converts Oddmuse or MediaWiki markup to Markdown: headings, lists, indentation, bold and italic text,
+// preformatted text, Oddmuse tables, external links and redirects. Links in double square brackets are converted by
+// the link function, given the target and the label, if any. MediaWiki tables, templates and references are left as
+// they are and reported as problems of the item.
+func wikiMarkdown(it *importItem, text string, link func(target, label string) string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r", ""), "\n")
+	out := []string{}
+	pre := false
+	table := false
+	mwTable := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if pre {
+			if trimmed == "</pre>" || trimmed == "}}}" {
+				out = append(out, "```")
+				pre = false
+			} else {
+				out = append(out, line)
+			}
+			continue
+		}
+		if trimmed == "<pre>" || trimmed == "{{{" {
+			out = append(out, "```")
+			pre = true
+			continue
+		}
+		if mwTable {
+			out = append(out, line)
+			if strings.HasPrefix(trimmed, "|}") {
+				mwTable = false
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "{|") {
+			it.problem("table not converted")
+			out = append(out, line)
+			mwTable = true
+			continue
+		}
+		if strings.HasPrefix(line, "||") {
+			cells := strings.Split(strings.TrimSuffix(strings.TrimPrefix(trimmed, "||"), "||"), "||")
+			for i, cell := range cells {
+				cells[i] = wikiInline(it, cell, link)
+			}
+			out = append(out, importTable(cells, !table))
+			table = true
+			continue
+		}
+		table = false
+		if m := wikiRedirectRe.FindStringSubmatch(line); m != nil {
+			out = append(out, "Redirect: "+wikiInline(it, m[1], link))
+			continue
+		}
+		if m := wikiHeadingRe.FindStringSubmatch(line); m != nil && strings.HasSuffix(trimmed, "=") {
+			out = append(out, strings.Repeat("#", len(m[1]))+" "+wikiInline(it, m[2], link))
+			continue
+		}
+		if m := wikiListRe.FindStringSubmatch(line); m != nil {
+			indent := strings.Repeat("    ", len(m[1])-1)
+			bullet := "* "
+			if strings.HasSuffix(m[1], "#") {
+				bullet = "1. "
+			}
+			out = append(out, indent+bullet+wikiInline(it, m[2], link))
+			continue
+		}
+		if wikiIndentRe.MatchString(line) {
+			out = append(out, "> "+wikiInline(it, wikiIndentRe.ReplaceAllString(line, ""), link))
+			continue
+		}
+		out = append(out, wikiInline(it, line, link))
+	}
+	if pre {
+		out = append(out, "```")
+	}
+	return strings.Join(out, "\n")
+}
+
+// wikiInline converts the inline markup of a line. See [wikiMarkdown].
+func wikiInline(it *importItem, line string, link func(target, label string) string) string {
+	for _, m := range wikiTemplateRe.FindAllStringSubmatch(line, -1) {
+		it.problem("template %s not converted", strings.TrimSpace(m[1]))
+	}
+	if strings.Contains(line, "<ref") {
+		it.problem("reference not converted")
+	}
+	line = wikiMagicRe.ReplaceAllString(line, "")
+	line = wikiNowikiRe.ReplaceAllString(line, "")
+	line = wikiLinkRe.ReplaceAllStringFunc(line, func(s string) string {
+		m := wikiLinkRe.FindStringSubmatch(s)
+		return link(strings.TrimSpace(m[1]), m[2])
+	})
+	line = wikiUrlRe.ReplaceAllStringFunc(line, func(s string) string {
+		m := wikiUrlRe.FindStringSubmatch(s)
+		if m[2] == "" {
+			return "<" + m[1] + ">"
+		}
+		return "[" + m[2] + "](" + m[1] + ")"
+	})
+	line = wikiBoldRe.ReplaceAllString(line, "**$1**")
+	line = wikiItalicRe.ReplaceAllString(line, "*$1*")
+	return line
+}
+
+// oddmuseFields parses an Oddmuse page or revision: lines with a key, a colon, a space and a value. Continuation lines
+// of a value start with a tab.
+func oddmuseFields(s string) map[string]string {
+	fields := make(map[string]string)
+	key := ""
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			if key != "" {
+				fields[key] += "\n" + line[1:]
+			}
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if ok && k != "" && !strings.ContainsAny(k, " \t") {
+			key = k
+			fields[k] = strings.TrimPrefix(v, " ")
+		}
+	}
+	return fields
+}
+
+// oddmuseRead reads an Oddmuse page file (".pg") or keep file (".kp") and returns the fields of the page. Keep files
+// contain old revisions; the revision with the highest number is used.
+func oddmuseRead(fp string) (map[string]string, error) {
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	if !strings.HasSuffix(fp, ".kp") {
+		return oddmuseFields(s), nil
+	}
+	var fields map[string]string
+	n := -1
+	for _, rev := range strings.FieldsFunc(s, func(r rune) bool { return r == '\f' || r == '\x1e' }) {
+		f := oddmuseFields(rev)
+		if _, ok := f["text"]; !ok {
+			continue
+		}
+		i, _ := strconv.Atoi(f["revision"])
+		if i >= n {
+			fields, n = f, i
+		}
+	}
+	if fields == nil {
+		return nil, fmt.Errorf("%s contains no revisions", fp)
+	}
+	return fields, nil
+}
+
+// importOddmuse imports an Oddmuse wiki. The source is a data directory, a page file or a keep file. In a data
+// directory, all the page files are imported. Keep files are only imported if there is no page file for the page.
+// Uploaded files are decoded and get an extension based on their MIME type. Page names keep their underscores.
+// Tags become hashtags and images become Markdown images.
+func importOddmuse(source string) ([]*importItem, error) {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[string]string) // page name → filepath
+	if fi.IsDir() {
+		err = filepath.WalkDir(source, func(fp string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := filepath.Ext(fp)
+			if d.IsDir() || ext != ".pg" && ext != ".kp" {
+				return nil
+			}
+			name := strings.TrimSuffix(filepath.Base(fp), ext)
+			if ext == ".pg" || sources[name] == "" {
+				sources[name] = fp
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		sources[strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))] = source
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%s contains no Oddmuse pages", source)
+	}
+	items := []*importItem{}
+	pages := make(map[string]map[string]string)
+	files := make(map[string]string) // page name → filename of the uploaded file
+	for name, fp := range sources {
+		fields, err := oddmuseRead(fp)
+		if err != nil {
+			return nil, err
+		}
+		text := fields["text"]
+		modTime := time.Time{}
+		ts, err := strconv.ParseInt(fields["ts"], 10, 64)
+		if err == nil {
+			modTime = time.Unix(ts, 0)
+		}
+		if !strings.HasPrefix(text, "#FILE ") {
+			fields["name"] = name
+			pages[name] = fields
+			continue
+		}
+		header, data, _ := strings.Cut(text, "\n")
+		it := &importItem{name: name, modTime: modTime}
+		words := strings.Fields(header)
+		if len(words) < 2 {
+			it.problem("file without a MIME type not decoded")
+			items = append(items, it)
+			continue
+		}
+		exts, _ := mime.ExtensionsByType(words[1])
+		if path.Ext(name) == "" && len(exts) > 0 {
+			it.name += exts[0]
+		}
+		b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+		if err == nil && len(words) > 2 && words[2] == "gzip" {
+			var r *gzip.Reader
+			r, err = gzip.NewReader(bytes.NewReader(b))
+			if err == nil {
+				b, err = io.ReadAll(r)
+			}
+		}
+		if err != nil {
+			it.problem("file not decoded: %s", err)
+		} else {
+			it.data = b
+		}
+		files[name] = it.name
+		items = append(items, it)
+	}
+	for name, fields := range pages {
+		it := &importItem{name: name + ".md"}
+		ts, err := strconv.ParseInt(fields["ts"], 10, 64)
+		if err == nil {
+			it.modTime = time.Unix(ts, 0)
+		}
+		link := func(target, label string) string {
+			switch {
+			case strings.HasPrefix(target, "tag:"):
+				return importHashtag(target[4:])
+			case strings.HasPrefix(target, "image:"):
+				target = strings.ReplaceAll(strings.TrimSpace(target[6:]), " ", "_")
+				if f, ok := files[target]; ok {
+					target = f
+				} else {
+					it.problem("image %s not found", target)
+				}
+				if label == "" {
+					label = target
+				}
+				return "![" + label + "](" + importLink(it.name, target) + ")"
+			}
+			target = strings.ReplaceAll(target, " ", "_")
+			if label == "" {
+				label = strings.ReplaceAll(target, "_", " ")
+			}
+			if f, ok := files[target]; ok {
+				target = f
+			}
+			return "[" + label + "](" + importLink(it.name, target) + ")"
+		}
+		it.data = []byte(wikiMarkdown(it, fields["text"], link))
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// mediawikiPage is a page in a MediaWiki XML dump. Only the information needed is decoded.
+type mediawikiPage struct {
+	Title     string `xml:"title"`
+	Ns        int    `xml:"ns"`
+	Revisions []struct {
+		Timestamp string `xml:"timestamp"`
+		Text      string `xml:"text"`
+	} `xml:"revision"`
+}
+
+// mediawikiNamespaces are the namespaces of links that are not converted.
+var mediawikiNamespaces = []string{"talk", "user", "user talk", "project", "project talk", "file talk", "mediawiki",
+	"template", "template talk", "help", "help talk", "category talk", "special", "media", "portal", "module"}
+
+// mediawikiName returns the page name for a MediaWiki title: the first letter is upper case and spaces are replaced
+// by underscores.
+func mediawikiName(title string) string {
+	title = strings.ReplaceAll(strings.TrimSpace(title), " ", "_")
+	r, n := utf8.DecodeRuneInString(title)
+	return string(unicode.ToUpper(r)) + title[n:]
+}
+
+// importMediaWiki imports the pages in the main namespace of a MediaWiki XML dump, using the last revision of each
+// page. Pages in other namespaces are skipped. As the dump contains no uploaded files, the files used by the pages
+// are copied from the directory of the dump, if they are there. Categories become hashtags.
+func importMediaWiki(source string) ([]*importItem, error) {
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dir := filepath.Dir(source)
+	items := []*importItem{}
+	files := make(map[string]bool)
+	dec := xml.NewDecoder(f)
+	for {
+		t, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Local != "page" {
+			continue
+		}
+		var p mediawikiPage
+		err = dec.DecodeElement(&p, &se)
+		if err != nil {
+			return nil, err
+		}
+		it := &importItem{name: mediawikiName(p.Title) + ".md"}
+		items = append(items, it)
+		if p.Ns != 0 {
+			it.problem("namespace %d skipped", p.Ns)
+			continue
+		}
+		if len(p.Revisions) == 0 {
+			it.problem("no revision found")
+			continue
+		}
+		rev := p.Revisions[len(p.Revisions)-1]
+		ti, err := time.Parse(time.RFC3339, rev.Timestamp)
+		if err == nil {
+			it.modTime = ti
+		}
+		link := func(target, label string) string {
+			ns, rest, ok := strings.Cut(target, ":")
+			ns = strings.ToLower(strings.TrimSpace(ns))
+			switch {
+			case ok && ns == "category":
+				return importHashtag(rest)
+			case ok && (ns == "file" || ns == "image"):
+				name := mediawikiName(rest)
+				files[name] = true
+				caption := name
+				for _, param := range strings.Split(label, "|") {
+					if !mediawikiImageOption(param) {
+						caption = strings.TrimSpace(param)
+					}
+				}
+				return "![" + caption + "](" + importLink(it.name, name) + ")"
+			case ok && slices.Contains(mediawikiNamespaces, ns), strings.HasPrefix(target, ":"):
+				it.problem("link to %s not converted", target)
+				if label == "" {
+					label = target
+				}
+				return label
+			}
+			if label == "" {
+				label = target
+			}
+			name, fragment, _ := strings.Cut(target, "#")
+			if name == "" {
+				name = strings.TrimSuffix(it.name, ".md")
+			} else {
+				name = mediawikiName(name)
+			}
+			if fragment != "" {
+				name += "#" + importAnchor(fragment)
+			}
+			return "[" + label + "](" + importLink(it.name, name) + ")"
+		}
+		it.data = []byte(wikiMarkdown(it, rev.Text, link))
+	}
+	for name := range files {
+		fp := filepath.Join(dir, name)
+		if _, err := os.Stat(fp); err != nil {
+			fp = filepath.Join(dir, strings.ReplaceAll(name, "_", " "))
+		}
+		it, err := importFile(fp, name)
+		if err != nil {
+			it = &importItem{name: name}
+			it.problem("file not found next to the dump")
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// mediawikiImageOption reports whether a parameter of a MediaWiki image link is an option and not the caption.
+func mediawikiImageOption(s string) bool {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "thumb", "thumbnail", "frame", "framed", "frameless", "border", "left", "right", "center", "centre",
+		"none", "upright", "baseline", "middle", "sub", "super", "top", "text-top", "bottom", "text-bottom":
+		return true
+	}
+	return strings.HasSuffix(s, "px") || strings.Contains(s, "=")
+}
+
+var (
+	dokuwikiHeadingRe = regexp.MustCompile(`^\s*(={2,6})\s*(.*?)\s*={2,6}\s*$`)
+	dokuwikiListRe    = regexp.MustCompile(`^( {2,})([*-])\s*(.*)$`)
+	dokuwikiLinkRe    = regexp.MustCompile(`\[\[([^\]|]+)(?:\|([^\]]*))?\]\]`)
+	dokuwikiMediaRe   = regexp.MustCompile(`\{\{\s*([^}|?]+?)\s*(?:\?[^}|]*)?(?:\|([^}]*))?\}\}`)
+	dokuwikiTagRe     = regexp.MustCompile(`\{\{tag>([^}]*)\}\}`)
+	dokuwikiItalicRe  = regexp.MustCompile(`(^|[^:])//(.+?[^:])//`)
+	dokuwikiMonoRe    = regexp.MustCompile(`''(.+?)''`)
+	dokuwikiNowikiRe  = regexp.MustCompile(`%%(.*?)%%|</?nowiki>`)
+	dokuwikiMacroRe   = regexp.MustCompile(`~~[A-Z]+~~`)
+	dokuwikiCodeRe    = regexp.MustCompile(`^\s*<(code|file)(?:\s+([^\s>]+))?[^>]*>(.*)$`)
+)
+
+// dokuwikiId returns the page or media name for a DokuWiki id, relative to the namespace the id is used in. Ids
+// without a colon are in the same namespace, ids starting with a colon are in the root namespace, ids starting with
+// a period are relative to the namespace and all other ids are absolute. Colons separate namespaces and become
+// slashes. Ids ending in a colon refer to the start page of a namespace.
+func dokuwikiId(ns, id string) string {
+	id = strings.ToLower(strings.TrimSpace(id))
+	id = strings.ReplaceAll(id, " ", "_")
+	switch {
+	case strings.HasPrefix(id, "."):
+		id = path.Join(ns, strings.ReplaceAll(id, ":", "/"))
+	case strings.HasPrefix(id, ":"):
+		id = strings.ReplaceAll(id[1:], ":", "/")
+	case !strings.Contains(id, ":"):
+		id = path.Join(ns, id)
+	default:
+		id = strings.ReplaceAll(id, ":", "/")
+	}
+	if strings.HasSuffix(id, "/") || id == "" {
+		id += "start"
+	}
+	return strings.TrimPrefix(path.Clean("/"+id), "/")
+}
+
+// importDokuWiki imports a DokuWiki site. The source is the data directory with the "pages" and "media"
+// subdirectories, or a directory with the pages. Namespaces become directories, media files are put into the
+// directory of their namespace, tags become hashtags and tables are converted to Markdown tables. Footnotes are left
+// as they are and reported.
+func importDokuWiki(source string) ([]*importItem, error) {
+	pages, media := source, ""
+	if fi, err := os.Stat(filepath.Join(source, "pages")); err == nil && fi.IsDir() {
+		pages, media = filepath.Join(source, "pages"), filepath.Join(source, "media")
+	}
+	items := []*importItem{}
+	err := filepath.WalkDir(pages, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(fp) != ".txt" {
+			return nil
+		}
+		rel, err := filepath.Rel(pages, fp)
+		if err != nil {
+			return err
+		}
+		it, err := importFile(fp, strings.TrimSuffix(filepath.ToSlash(rel), ".txt")+".md")
+		if err != nil {
+			return err
+		}
+		it.data = []byte(dokuwikiMarkdown(it, string(it.data)))
+		items = append(items, it)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%s contains no DokuWiki pages", source)
+	}
+	if media == "" {
+		return items, nil
+	}
+	err = filepath.WalkDir(media, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(media, fp)
+		if err != nil {
+			return err
+		}
+		it, err := importFile(fp, filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		items = append(items, it)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return items, nil
+}
+
+// dokuwikiMarkdown converts DokuWiki markup to Markdown. See [importDokuWiki].
+func dokuwikiMarkdown(it *importItem, text string) string {
+	ns := path.Dir(it.name)
+	lines := strings.Split(strings.ReplaceAll(text, "\r", ""), "\n")
+	out := []string{}
+	code := ""
+	table := false
+	for _, line := range lines {
+		if code != "" {
+			before, found := strings.CutSuffix(strings.TrimRight(line, " "), "</"+code+">")
+			if found {
+				if before != "" {
+					out = append(out, before)
+				}
+				out = append(out, "```")
+				code = ""
+			} else {
+				out = append(out, line)
+			}
+			continue
+		}
+		if m := dokuwikiCodeRe.FindStringSubmatch(line); m != nil {
+			out = append(out, "```"+m[2])
+			rest, found := strings.CutSuffix(strings.TrimRight(m[3], " "), "</"+m[1]+">")
+			if rest != "" {
+				out = append(out, rest)
+			}
+			if found {
+				out = append(out, "```")
+			} else {
+				code = m[1]
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "^") || strings.HasPrefix(trimmed, "|") {
+			cells := strings.FieldsFunc(strings.Trim(trimmed, "^|"), func(r rune) bool { return r == '^' || r == '|' })
+			for i, cell := range cells {
+				cells[i] = dokuwikiInline(it, ns, cell)
+			}
+			out = append(out, importTable(cells, !table))
+			table = true
+			continue
+		}
+		table = false
+		if m := dokuwikiHeadingRe.FindStringSubmatch(line); m != nil {
+			out = append(out, strings.Repeat("#", 7-len(m[1]))+" "+dokuwikiInline(it, ns, m[2]))
+			continue
+		}
+		if m := dokuwikiListRe.FindStringSubmatch(line); m != nil {
+			indent := strings.Repeat("    ", len(m[1])/2-1)
+			bullet := "* "
+			if m[2] == "-" {
+				bullet = "1. "
+			}
+			out = append(out, indent+bullet+dokuwikiInline(it, ns, m[3]))
+			continue
+		}
+		if strings.HasPrefix(line, "  ") && trimmed != "" {
+			// preformatted text
+			out = append(out, "    "+line[2:])
+			continue
+		}
+		out = append(out, dokuwikiInline(it, ns, line))
+	}
+	if code != "" {
+		out = append(out, "```")
+	}
+	return strings.Join(out, "\n")
+}
+
+// dokuwikiInline converts the inline markup of a line. See [dokuwikiMarkdown].
+func dokuwikiInline(it *importItem, ns, line string) string {
+	if strings.Contains(line, "((") {
+		it.problem("footnote not converted")
+	}
+	line = dokuwikiMacroRe.ReplaceAllString(line, "")
+	line = dokuwikiNowikiRe.ReplaceAllString(line, "$1")
+	line = dokuwikiTagRe.ReplaceAllStringFunc(line, func(s string) string {
+		tags := strings.Fields(dokuwikiTagRe.FindStringSubmatch(s)[1])
+		for i, tag := range tags {
+			tags[i] = importHashtag(tag)
+		}
+		return strings.Join(tags, " ")
+	})
+	line = dokuwikiMediaRe.ReplaceAllStringFunc(line, func(s string) string {
+		m := dokuwikiMediaRe.FindStringSubmatch(s)
+		target, caption := m[1], strings.TrimSpace(m[2])
+		if !strings.Contains(target, "://") {
+			target = importLink(it.name, dokuwikiId(ns, target))
+		}
+		if caption == "" {
+			caption = path.Base(target)
+		}
+		return "![" + caption + "](" + target + ")"
+	})
+	line = dokuwikiLinkRe.ReplaceAllStringFunc(line, func(s string) string {
+		m := dokuwikiLinkRe.FindStringSubmatch(s)
+		target, label := strings.TrimSpace(m[1]), strings.TrimSpace(m[2])
+		if label == "" {
+			label = target
+		}
+		if strings.Contains(target, "://") || strings.HasPrefix(target, "mailto:") {
+			return "[" + label + "](" + target + ")"
+		}
+		if strings.Contains(target, ">") {
+			it.problem("interwiki link %s not converted", target)
+			return label
+		}
+		id, fragment, _ := strings.Cut(target, "#")
+		name := strings.TrimSuffix(it.name, ".md")
+		if id != "" {
+			name = dokuwikiId(ns, id)
+		}
+		if fragment != "" {
+			name += "#" + importAnchor(fragment)
+		}
+		return "[" + label + "](" + importLink(it.name, name) + ")"
+	})
+	line = dokuwikiItalicRe.ReplaceAllString(line, "$1*$2*")
+	line = dokuwikiMonoRe.ReplaceAllString(line, "`$1`")
+	return line
+}
+
+var (
+	obsidianLinkRe    = regexp.MustCompile(`(!?)\[\[([^\]|#]*)(#[^\]|]*)?(?:\|([^\]]*))?\]\]`)
+	obsidianCommentRe = regexp.MustCompile(`(?s)%%.*?%%`)
+)
+
+// importObsidian imports an Obsidian vault. Hidden directories such as ".obsidian" are skipped. Attachments are
+// copied as they are. Wiki links are converted to Markdown links unless they refer to a page in the same directory
+// without an alias or a heading. As Obsidian finds the notes and attachments linked anywhere in the vault, links are
+// resolved by name. Embedded images become Markdown images. Front matter is removed; tags in the front matter become
+// hashtags. Comments are removed.
+func importObsidian(source string) ([]*importItem, error) {
+	items := []*importItem{}
+	names := make(map[string]string) // lower case name without ".md" → name used by the storage
+	err := filepath.WalkDir(source, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fp != source && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(source, fp)
+		if err != nil {
+			return err
+		}
+		it, err := importFile(fp, filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		key := strings.ToLower(strings.TrimSuffix(path.Base(it.name), ".md"))
+		if _, ok := names[key]; !ok {
+			names[key] = it.name
+		}
+		items = append(items, it)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range items {
+		if strings.HasSuffix(it.name, ".md") {
+			it.data = []byte(obsidianMarkdown(it, string(it.data), names))
+		}
+	}
+	return items, nil
+}
+
+// obsidianMarkdown converts an Obsidian note to Oddmu Markdown. See [importObsidian].
+func obsidianMarkdown(it *importItem, text string, names map[string]string) string {
+	text = strings.ReplaceAll(text, "\r", "")
+	tags := []string{}
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		front, body, ok := strings.Cut(rest, "\n---\n")
+		if ok {
+			text = body
+			tags = obsidianTags(it, front)
+		}
+	}
+	if obsidianCommentRe.MatchString(text) {
+		it.problem("comments removed")
+		text = obsidianCommentRe.ReplaceAllString(text, "")
+	}
+	text = obsidianLinkRe.ReplaceAllStringFunc(text, func(s string) string {
+		m := obsidianLinkRe.FindStringSubmatch(s)
+		embed, target, heading, label := m[1] == "!", strings.TrimSpace(m[2]), m[3], m[4]
+		name := strings.TrimSuffix(it.name, ".md")
+		if target != "" {
+			key := strings.ToLower(strings.TrimSuffix(path.Base(target), ".md"))
+			n, ok := names[key]
+			if !ok {
+				it.problem("link to %s not found", target)
+				n = path.Join(path.Dir(it.name), target)
+			}
+			name = n
+		}
+		if embed && !strings.HasSuffix(name, ".md") {
+			if label == "" {
+				label = path.Base(name)
+			}
+			return "![" + label + "](" + importLink(it.name, name) + ")"
+		}
+		if embed {
+			it.problem("embedded note %s not converted", target)
+		}
+		name = strings.TrimSuffix(name, ".md")
+		if heading == "" && label == "" && path.Dir(name) == path.Dir(it.name) && path.Base(name) == target {
+			return "[[" + target + "]]"
+		}
+		if label == "" {
+			label = target + strings.ReplaceAll(heading, "#", " › ")
+			label = strings.TrimPrefix(label, " › ")
+		}
+		if heading != "" {
+			name += "#" + importAnchor(heading[1:])
+		}
+		return "[" + label + "](" + importLink(it.name, name) + ")"
+	})
+	if len(tags) > 0 {
+		text = strings.TrimRight(text, "\n") + "\n\n" + strings.Join(tags, " ") + "\n"
+	}
+	return text
+}
+
+// obsidianTags returns the hashtags for the tags in the front matter of a note. Other keys are reported as problems.
+// Only the simple forms "tags: a, b", "tags: [a, b]" and a list of tags on the following lines are understood.
+func obsidianTags(it *importItem, front string) []string {
+	tags := []string{}
+	inTags := false
+	for _, line := range strings.Split(front, "\n") {
+		if inTags {
+			if tag, ok := strings.CutPrefix(strings.TrimSpace(line), "- "); ok {
+				tags = append(tags, importHashtag(strings.Trim(tag, `"'#`)))
+				continue
+			}
+			inTags = false
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, " ") {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if key != "tags" && key != "tag" {
+			it.problem("front matter %s removed", key)
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), "[]")
+		if value == "" {
+			inTags = true
+			continue
+		}
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.Trim(strings.TrimSpace(tag), `"'#`)
+			if tag != "" {
+				tags = append(tags, importHashtag(tag))
+			}
+		}
+	}
+	return tags
+}
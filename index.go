@@ -8,8 +8,8 @@ import (
 	"golang.org/x/exp/constraints"
 	"html/template"
 	"io/fs"
-	"log"
-	"path/filepath"
+	"log/slog"
+	"path"
 	"sort"
 	"strings"
 	"sync"
@@ -30,6 +30,9 @@ type ImageData struct {
 type indexStore struct {
 	sync.RWMutex
 
+	// site is the site whose pages are indexed. If nil, the default site is used.
+	site *site
+
 	// next_id is the number of the next document added to the index
 	next_id docid
 
@@ -130,7 +133,7 @@ func (idx *indexStore) remove(p *Page) {
 func (idx *indexStore) load() (int, error) {
 	idx.Lock()
 	defer idx.Unlock()
-	err := filepath.Walk(".", idx.walk)
+	err := fs.WalkDir(idx.wiki().store, ".", idx.walk)
 	if err != nil {
 		return 0, err
 	}
@@ -138,15 +141,24 @@ func (idx *indexStore) load() (int, error) {
 	return n, nil
 }
 
-// walk reads a file and adds it to the index. This assumes that the index is locked.
-func (idx *indexStore) walk(fp string, info fs.FileInfo, err error) error {
+// wiki returns the site whose pages are indexed.
+func (idx *indexStore) wiki() *site {
+	if idx.site == nil {
+		return defaultSite
+	}
+	return idx.site
+}
+
+// walk reads a file and adds it to the index. The path is a name used by the storage of the site. This assumes that
+// the index is locked.
+func (idx *indexStore) walk(fp string, d fs.DirEntry, err error) error {
 	if err != nil {
 		return err
 	}
 	// skip hidden directories and files
-	if fp != "." && strings.HasPrefix(filepath.Base(fp), ".") {
-		if info.IsDir() {
-			return filepath.SkipDir
+	if fp != "." && strings.HasPrefix(path.Base(fp), ".") {
+		if d.IsDir() {
+			return fs.SkipDir
 		} else {
 			return nil
 		}
@@ -155,10 +167,12 @@ func (idx *indexStore) walk(fp string, info fs.FileInfo, err error) error {
 	if !strings.HasSuffix(fp, ".md") {
 		return nil
 	}
-	p, err := loadPage(strings.TrimSuffix(filepath.ToSlash(fp), ".md"))
+	body, err := idx.wiki().store.readFile(fp)
 	if err != nil {
 		return err
 	}
+	name := strings.TrimSuffix(fp, ".md")
+	p := &Page{Title: name, Name: name, Body: body}
 	p.handleTitle(false)
 	idx.addPage(p)
 	return nil
@@ -185,7 +199,7 @@ func (idx *indexStore) dump() {
 	idx.RLock()
 	defer idx.RUnlock()
 	for token, ids := range idx.token {
-		log.Printf("%s: %v", token, ids)
+		slog.Debug("Index", "token", token, "pages", ids)
 	}
 }
 
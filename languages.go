@@ -3,13 +3,14 @@ package main
 import (
 	"errors"
 	"github.com/pemistahl/lingua-go"
-	"os"
+	"path"
 	"strings"
+	"sync"
 )
 
-// getLanguages returns the environment variable ODDMU_LANGUAGES or all languages.
-func getLanguages() ([]lingua.Language, error) {
-	v := os.Getenv("ODDMU_LANGUAGES")
+// getLanguages returns the languages for a comma-separated list of ISO 639-1 codes such as the setting "languages"
+// (or the environment variable ODDMU_LANGUAGES), or all languages if the list is empty.
+func getLanguages(v string) ([]lingua.Language, error) {
 	if v == "" {
 		return lingua.AllLanguages(), nil
 	}
@@ -28,11 +29,23 @@ func getLanguages() ([]lingua.Language, error) {
 // detector is the LanguageDetector initialized at startup by loadLanguages.
 var detector lingua.LanguageDetector
 
+// detectorStore controls access to the detectors for directories with their own "languages" setting. Make sure to
+// lock and unlock as appropriate.
+type detectorStore struct {
+	sync.Mutex
+
+	// detectors maps the comma-separated lists of ISO 639-1 codes to their detectors. If detection is unnecessary,
+	// the detector is nil.
+	detectors map[string]lingua.LanguageDetector
+}
+
+var detectors = detectorStore{detectors: make(map[string]lingua.LanguageDetector)}
+
 // loadLanguages initializes the detector using the languages returned by getLanguages and returns the number of
 // languages loaded. If this is skipped, no language detection happens and the templates cannot use {{.Language}} to use
-// this. Usually this is used for correct hyphenation by the browser.
+// this. Usually this is used for correct hyphenation by the browser. The detectors for directories are discarded.
 func loadLanguages() int {
-	langs, err := getLanguages()
+	langs, err := getLanguages(setting("languages"))
 	if err == nil {
 		detector = lingua.NewLanguageDetectorBuilder().
 			FromLanguages(langs...).
@@ -42,6 +55,9 @@ func loadLanguages() int {
 	} else {
 		detector = nil
 	}
+	detectors.Lock()
+	defer detectors.Unlock()
+	detectors.detectors = make(map[string]lingua.LanguageDetector)
 	return len(langs)
 }
 
@@ -49,16 +65,49 @@ func loadLanguages() int {
 // ISO 639-1 string, e.g. "en" or "de".
 func language(s string) string {
 	if detector == nil {
-		return os.Getenv("ODDMU_LANGUAGES")
+		return setting("languages")
+	}
+	return detect(detector, s)
+}
+
+// languageIn returns the language used for a string, as a lower case ISO 639-1 string, given a comma-separated list
+// of ISO 639-1 codes to choose from. The detector for the list is created the first time it is needed and the
+// language models are loaded on demand.
+func languageIn(v, s string) string {
+	detectors.Lock()
+	d, ok := detectors.detectors[v]
+	if !ok {
+		langs, err := getLanguages(v)
+		if err == nil {
+			d = lingua.NewLanguageDetectorBuilder().
+				FromLanguages(langs...).
+				WithLowAccuracyMode().
+				Build()
+		}
+		detectors.detectors[v] = d
+	}
+	detectors.Unlock()
+	if d == nil {
+		return v
 	}
-	if language, ok := detector.DetectLanguageOf(s); ok {
+	return detect(d, s)
+}
+
+// detect returns the language detected, as a lower case ISO 639-1 string, or the empty string.
+func detect(d lingua.LanguageDetector, s string) string {
+	if language, ok := d.DetectLanguageOf(s); ok {
 		return strings.ToLower(language.IsoCode639_1().String())
 	}
 	return ""
 }
 
 // Language returns the language used for the page, as a lower case
-// ISO 639-1 string, e.g. "en" or "de".
+// ISO 639-1 string, e.g. "en" or "de". If the directory of the page or the site of the page has its own "languages"
+// setting, that is used.
 func (p *Page) Language() string {
+	v := p.wiki().dirSetting("languages", path.Dir(p.Name))
+	if v != setting("languages") {
+		return languageIn(v, p.plainText())
+	}
 	return language(p.plainText())
 }
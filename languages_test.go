@@ -48,3 +48,15 @@ Drowning as we stare
 `)
 	assert.NotEqual(t, "en", l)
 }
+
+func TestDirLanguages(t *testing.T) {
+	cleanup(t, "testdata/languages")
+	useConfig(t, "testdata/languages", `
+languages = "en,de"
+[dir."testdata/languages/fr"]
+languages = "fr"
+`)
+	os.Unsetenv("ODDMU_LANGUAGES")
+	p := &Page{Name: "testdata/languages/fr/haiku", Body: []byte("Le vent se lève, il faut tenter de vivre")}
+	assert.Equal(t, "fr", p.Language())
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateWindow counts the requests of a client in the current one-minute window.
+type rateWindow struct {
+	start time.Time
+	n     int
+}
+
+// rateStore controls access to the map of rate windows. Make sure to lock and unlock as appropriate. See allow.
+type rateStore struct {
+	sync.Mutex
+
+	// clients maps clients to their current window. Clients are either "user:" followed by the username or "ip:"
+	// followed by the IP number.
+	clients map[string]*rateWindow
+}
+
+var rates = rateStore{clients: make(map[string]*rateWindow)}
+
+// allow reports whether the client may make another request, given the maximum number of requests per minute. If
+// not, the time until the client may try again is returned, too. Old windows are removed as a side effect.
+func (s *rateStore) allow(client string, max int, now time.Time) (bool, time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	for key, window := range s.clients {
+		if now.Sub(window.start) >= time.Minute {
+			delete(s.clients, key)
+		}
+	}
+	window, ok := s.clients[client]
+	if !ok {
+		window = &rateWindow{start: now}
+		s.clients[client] = window
+	}
+	if window.n >= max {
+		return false, window.start.Add(time.Minute).Sub(now)
+	}
+	window.n++
+	return true, 0
+}
+
+// clientIP returns the IP number of the client. If the request comes from a trusted proxy (see [trustedProxy]), the
+// X-Forwarded-For header is used: going from the last entry to the first, the first entry that isn't a trusted proxy
+// itself is the client. Otherwise, the header is ignored because any client can set it.
+func clientIP(r *http.Request) string {
+	host := peerIP(r)
+	if !trustedProxy(host) {
+		return host
+	}
+	h := r.Header.Get("X-Forwarded-For")
+	if h == "" {
+		return host
+	}
+	entries := strings.Split(h, ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(entries[i])
+		if !trustedProxy(ip) || i == 0 {
+			return ip
+		}
+	}
+	return host
+}
+
+// peerIP returns the IP number of the direct peer of the connection, ignoring any headers.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// trustedProxy reports whether an IP number belongs to a reverse proxy whose X-Forwarded-* headers can be trusted.
+// The environment variable ODDMU_TRUSTED_PROXIES is a comma-separated list of IP numbers and networks, e.g.
+// "127.0.0.1,::1" or "10.0.0.0/8". If it is not set, no proxy is trusted.
+func trustedProxy(ip string) bool {
+	s := setting("trusted_proxies")
+	if s == "" {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err == nil && prefix.Contains(addr) {
+				return true
+			}
+		} else if other, err := netip.ParseAddr(entry); err == nil && other.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSize parses a size in bytes. The suffixes "K", "M" and "G" multiply by 1024, 1024² and 1024³, respectively.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	var m int64 = 1
+	switch {
+	case strings.HasSuffix(s, "K"):
+		m = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		m = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		m = 1 << 30
+	}
+	if m > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * m, nil
+}
+
+// getSize returns the size in bytes given by a setting of the site, or 0 if it is not set or invalid.
+func (w *site) getSize(key string) int64 {
+	s := w.setting(key)
+	if s == "" {
+		return 0
+	}
+	n, err := parseSize(s)
+	if err != nil {
+		slog.Warn("Not a size", "key", key, "value", s, "err", err)
+		return 0
+	}
+	return n
+}
+
+// getRate returns the number of requests per minute given by a setting of the site, or 0 if it is not set or invalid.
+func (w *site) getRate(key string) int {
+	s := w.setting(key)
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		slog.Warn("Not a number", "key", key, "value", s, "err", err)
+		return 0
+	}
+	return n
+}
+
+// limit wraps a handler that changes files. If the environment variable ODDMU_RATE_LIMIT_USER is set, users that
+// logged in may only make that many requests per minute. Only a login with a valid password counts; see
+// [authenticatedUser]. If the environment variable ODDMU_RATE_LIMIT_IP is set, everybody else may only make that many
+// requests per minute from the same IP number. Violations result in the status
+// 429. If the environment variable ODDMU_MAX_REQUEST_SIZE is set, requests with a larger body result in the status 413.
+// The form is parsed before calling the handler.
+func limit(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := siteOf(r)
+		client := ""
+		max := 0
+		user := authenticatedUser(r)
+		if user != "" {
+			client = "user:" + user
+			max = s.getRate("rate_limit_user")
+		} else {
+			client = "ip:" + clientIP(r)
+			max = s.getRate("rate_limit_ip")
+		}
+		if max > 0 {
+			ok, wait := rates.allow(client, max, time.Now())
+			if !ok {
+				slog.Warn("Rate limit exceeded", "client", client, "path", r.URL.Path)
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				http.Error(w, "too many requests, please wait a minute", http.StatusTooManyRequests)
+				return
+			}
+		}
+		n := s.getSize("max_request_size")
+		if n > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			var err error
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType == "multipart/form-data" {
+				err = r.ParseMultipartForm(32 << 20)
+			} else {
+				err = r.ParseForm()
+			}
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				slog.Warn("Request too large", "client", client, "path", r.URL.Path)
+				http.Error(w, fmt.Sprintf("the request is larger than %d bytes", n), http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+		fn(w, r)
+	}
+}
+
+// checkSize returns an error if the page body is larger than the environment variable ODDMU_MAX_PAGE_SIZE or if
+// saving it would exceed the quota of its directory. Only the difference to the size of the existing page counts
+// towards the quota. See checkQuota.
+func (p *Page) checkSize() error {
+	n := p.wiki().getSize("max_page_size")
+	if n > 0 && int64(len(p.Body)) > n {
+		return fmt.Errorf("the page is larger than %d bytes", n)
+	}
+	size := int64(len(p.Body)) - p.wiki().fileSize(p.Name+".md")
+	return p.wiki().checkQuota(filepath.Dir(filepath.FromSlash(p.Name)), size)
+}
+
+// checkUploadSize returns an error if the uploaded file is larger than the environment variable
+// ODDMU_MAX_UPLOAD_SIZE or if saving it would exceed the quota of its directory. The name is the file that is
+// replaced, if any. Only the difference to its size counts towards the quota. See checkQuota.
+func (w *site) checkUploadSize(dir, name string, size int64) error {
+	n := w.getSize("max_upload_size")
+	if n > 0 && size > n {
+		return fmt.Errorf("the file is larger than %d bytes", n)
+	}
+	if name != "" {
+		size -= w.fileSize(name)
+	}
+	return w.checkQuota(dir, size)
+}
+
+// fileSize returns the size of an existing file, or 0 if it doesn't exist.
+func (w *site) fileSize(name string) int64 {
+	fi, err := w.store.stat(name)
+	if err != nil || fi.IsDir() {
+		return 0
+	}
+	return fi.Size()
+}
+
+// checkQuota returns an error if adding the given number of bytes to a directory exceeds its quota. The number of
+// bytes is negative if a file gets smaller, which is always allowed. The environment
+// variable ODDMU_QUOTA is a comma-separated list of directories and their quota, e.g. "guests=50M,alex=1G". The quota
+// is for the directory including its subdirectories. Use "." for the whole wiki. If several directories apply, all of
+// them are checked. The directories are relative to the root directory of the site.
+func (w *site) checkQuota(dir string, size int64) error {
+	s := w.setting("quota")
+	if s == "" {
+		return nil
+	}
+	dir = filepath.Clean(dir)
+	for _, entry := range strings.Split(s, ",") {
+		d, q, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			slog.Warn("ODDMU_QUOTA entry is invalid", "entry", entry)
+			continue
+		}
+		quota, err := parseSize(q)
+		if err != nil {
+			slog.Warn("ODDMU_QUOTA entry is invalid", "entry", entry, "err", err)
+			continue
+		}
+		d = filepath.Clean(filepath.FromSlash(d))
+		if d != "." && dir != d && !strings.HasPrefix(dir, d+string(filepath.Separator)) {
+			continue
+		}
+		used, err := diskUsage(w.store, filepath.ToSlash(d))
+		if err != nil {
+			return err
+		}
+		if size > 0 && used+size > quota {
+			return fmt.Errorf("the quota of %d bytes for %s would be exceeded", quota, filepath.ToSlash(d))
+		}
+	}
+	return nil
+}
+
+// diskUsage returns the size of all the files in a directory of a file system and its subdirectories. Hidden files and
+// directories are skipped, but backup files are not.
+func diskUsage(fsys fs.FS, dir string) (int64, error) {
+	var n int64
+	err := fs.WalkDir(fsys, dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if fp != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			fi, err := d.Info()
+			if err == nil {
+				n += fi.Size()
+			}
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	n, err := parseSize("10")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+	n, err = parseSize("2k")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2048), n)
+	n, err = parseSize("1M")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1<<20), n)
+	_, err = parseSize("many")
+	assert.Error(t, err)
+}
+
+func TestRateLimit(t *testing.T) {
+	s := rateStore{clients: make(map[string]*rateWindow)}
+	now := time.Now()
+	ok, _ := s.allow("ip:192.0.2.1", 2, now)
+	assert.True(t, ok)
+	ok, _ = s.allow("ip:192.0.2.1", 2, now)
+	assert.True(t, ok)
+	ok, wait := s.allow("ip:192.0.2.1", 2, now.Add(time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, 59*time.Second, wait)
+	ok, _ = s.allow("ip:192.0.2.2", 2, now.Add(time.Second))
+	assert.True(t, ok)
+	ok, _ = s.allow("ip:192.0.2.1", 2, now.Add(time.Minute))
+	assert.True(t, ok)
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	cleanup(t, "testdata/rate")
+	t.Setenv("ODDMU_RATE_LIMIT_IP", "1")
+	h := limit(makeHandler(saveHandler, true, http.MethodPost))
+	post := func(ip string) int {
+		r := httptest.NewRequest("POST", "/save/testdata/rate/haiku", strings.NewReader("body=Rain"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-Forwarded-For", ip)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusFound, post("192.0.2.42"))
+	// without a trusted proxy, a different X-Forwarded-For header doesn't help
+	assert.Equal(t, http.StatusTooManyRequests, post("192.0.2.43"))
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/view/index", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7, 192.0.2.42")
+	assert.Equal(t, "127.0.0.1", clientIP(r))
+	t.Setenv("ODDMU_TRUSTED_PROXIES", "127.0.0.1")
+	assert.Equal(t, "192.0.2.42", clientIP(r))
+	t.Setenv("ODDMU_TRUSTED_PROXIES", "127.0.0.1,192.0.2.0/24")
+	assert.Equal(t, "198.51.100.7", clientIP(r))
+	r.RemoteAddr = "203.0.113.9:1234"
+	assert.Equal(t, "203.0.113.9", clientIP(r))
+}
+
+func TestSizeLimits(t *testing.T) {
+	cleanup(t, "testdata/size")
+	post := func(h http.HandlerFunc, path, body string) int {
+		data := url.Values{"body": {body}}
+		r := httptest.NewRequest("POST", path, strings.NewReader(data.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	save := limit(makeHandler(saveHandler, true, http.MethodPost))
+	add := limit(makeHandler(appendHandler, true, http.MethodPost))
+	t.Setenv("ODDMU_MAX_PAGE_SIZE", "10")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(save, "/save/testdata/size/haiku", "The wind is too strong"))
+	assert.Equal(t, http.StatusFound, post(save, "/save/testdata/size/haiku", "Wind"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(add, "/append/testdata/size/haiku", "Rain falls"))
+	t.Setenv("ODDMU_MAX_PAGE_SIZE", "")
+	t.Setenv("ODDMU_MAX_REQUEST_SIZE", "10")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(save, "/save/testdata/size/haiku", "The wind is too strong"))
+}
+
+func TestQuota(t *testing.T) {
+	cleanup(t, "testdata/quota")
+	assert.NoError(t, os.MkdirAll("testdata/quota/sub", 0755))
+	assert.NoError(t, os.WriteFile("testdata/quota/sub/data.txt", []byte("0123456789"), 0644))
+	t.Setenv("ODDMU_QUOTA", "testdata/quota=15")
+	assert.NoError(t, defaultSite.checkQuota("testdata/quota", 5))
+	assert.Error(t, defaultSite.checkQuota("testdata/quota/sub", 6))
+	assert.NoError(t, defaultSite.checkQuota("testdata/other", 6))
+	assert.Error(t, defaultSite.checkUploadSize("testdata/quota", "", 6))
+	// replacing a file only counts the difference
+	assert.NoError(t, defaultSite.checkUploadSize("testdata/quota/sub", "testdata/quota/sub/data.txt", 12))
+	assert.Error(t, defaultSite.checkUploadSize("testdata/quota/sub", "testdata/quota/sub/data.txt", 16))
+	t.Setenv("ODDMU_MAX_UPLOAD_SIZE", "1K")
+	assert.Error(t, defaultSite.checkUploadSize("testdata/other", "", 2000))
+}
+
+func TestRateLimitFakeLogin(t *testing.T) {
+	cleanup(t, "testdata/rate-login")
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll("testdata/rate-login", 0755))
+	assert.NoError(t, os.WriteFile("testdata/rate-login/.htpasswd", []byte("alex:"+string(hash)+"\n"), 0644))
+	t.Setenv("ODDMU_AUTH", "testdata/rate-login/.htpasswd")
+	t.Setenv("ODDMU_RATE_LIMIT_IP", "1")
+	t.Setenv("ODDMU_RATE_LIMIT_USER", "2")
+	rates.Lock()
+	rates.clients = make(map[string]*rateWindow)
+	rates.Unlock()
+	h := limit(makeHandler(saveHandler, true, http.MethodPost))
+	post := func(username, password string) int {
+		r := httptest.NewRequest("POST", "/save/testdata/rate-login/haiku", strings.NewReader("body=Rain"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	// a wrong password counts against the IP number, so new usernames don't help
+	assert.Equal(t, http.StatusUnauthorized, post("mallory", "x"))
+	assert.Equal(t, http.StatusTooManyRequests, post("eve", "x"))
+	// a valid login gets a bucket of its own
+	assert.Equal(t, http.StatusFound, post("alex", "secret"))
+	assert.Equal(t, http.StatusFound, post("alex", "secret"))
+	assert.Equal(t, http.StatusTooManyRequests, post("alex", "secret"))
+}
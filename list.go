@@ -0,0 +1,258 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// File is a file in a directory listing.
+type File struct {
+	// Name is the filename. The ".md" suffix for pages is part of the name.
+	Name string
+
+	// Title is the page title, if the file is a page.
+	Title string
+
+	// IsDir is set for directories.
+	IsDir bool
+
+	// IsUp is set for the entry for the parent directory.
+	IsUp bool
+
+	// Date is the last modification date of the file. As the pages used by Oddmu are plain Markdown files, they
+	// don't contain any metadata. Instead, the last modification date of the file is used.
+	Date string
+
+	// modified is the last modification date of the file, for sorting.
+	modified time.Time
+}
+
+// Path returns the filename, percent-encoded.
+func (f *File) Path() string {
+	return pathEncode(f.Name)
+}
+
+// List is a directory listing, used by the list.html template.
+type List struct {
+	// Dir is the directory being listed, percent-encoded and ending in a slash (unless it is the top directory).
+	Dir string
+
+	// Files are the files and subdirectories. The parent directory is listed first, followed by the
+	// subdirectories and the files.
+	Files []File
+
+	// Sort is the sort order used: "name", "title" or "date".
+	Sort string
+
+	// User is the name of the user, if logged in. Only logged in users can delete and rename files.
+	User string
+}
+
+// listUser returns the name of the user, if logged in. Oddmu doesn't do access control: the web server does. If the
+// web server requires a password for an action, the browser sends the credentials along.
+func listUser(r *http.Request) string {
+	user, _, ok := r.BasicAuth()
+	if !ok {
+		return ""
+	}
+	return user
+}
+
+// listHandler lists the files and subdirectories of a directory using the "list.html" template. Hidden files and
+// backup files are skipped. If the environment variable ODDMU_FILTER is a regular expression that doesn't match the
+// directory, this is the "main site" and the files and subdirectories matching the regular expression are skipped.
+// The query parameter "sort" determines the sort order: "name" (the default), "title" or "date" (the most recently
+// modified file first).
+func listHandler(w http.ResponseWriter, r *http.Request, dir string) {
+	s := siteOf(r)
+	filter := s.dirSetting("filter", dir)
+	re, err := regexp.Compile(filter)
+	if err != nil {
+		slog.Error("ODDMU_FILTER does not compile", "filter", filter, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		http.Redirect(w, r, "/list/"+nameEscape(dir)+"/", http.StatusFound)
+		return
+	}
+	entries, err := s.store.readDir(storagePath(dir))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	matches := re.MatchString(dir)
+	l := &List{Dir: pathEncode(dir), Sort: r.FormValue("sort"), User: listUser(r)}
+	if l.Sort != "title" && l.Sort != "date" {
+		l.Sort = "name"
+	}
+	files := []File{}
+	s.index.RLock()
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		f := File{Name: name, IsDir: e.IsDir(), modified: fi.ModTime(), Date: fi.ModTime().Format(time.DateTime)}
+		if f.IsDir {
+			f.Name += "/"
+		}
+		if filter != "" && !matches && re.MatchString(dir+f.Name) {
+			continue
+		}
+		if !f.IsDir && strings.HasSuffix(name, ".md") {
+			f.Title = s.index.titles[dir+strings.TrimSuffix(name, ".md")]
+		}
+		files = append(files, f)
+	}
+	s.index.RUnlock()
+	slices.SortStableFunc(files, func(a, b File) int {
+		if a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+		switch l.Sort {
+		case "title":
+			return strings.Compare(strings.ToLower(a.sortTitle()), strings.ToLower(b.sortTitle()))
+		case "date":
+			return b.modified.Compare(a.modified)
+		default:
+			return strings.Compare(a.Name, b.Name)
+		}
+	})
+	if dir != "" {
+		l.Files = append(l.Files, File{Name: "../", IsDir: true, IsUp: true})
+	}
+	l.Files = append(l.Files, files...)
+	s.templates.render(w, dir, "list", l)
+}
+
+// sortTitle returns the title, or the name if there is no title.
+func (f *File) sortTitle() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	return f.Name
+}
+
+// deleteHandler deletes a file by renaming it to the backup file, appending "~", unless the storage keeps old versions
+// by itself. Directories cannot be deleted. If the file is a page, it is removed from the index. The browser is
+// redirected to the list of the directory.
+func deleteHandler(w http.ResponseWriter, r *http.Request, name string) {
+	s := siteOf(r)
+	fi, err := s.store.stat(storagePath(name))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if fi.IsDir() {
+		http.Error(w, "directories cannot be deleted", http.StatusBadRequest)
+		return
+	}
+	username := author(r, name)
+	slog.Info("Delete", "page", name, "user", username)
+	s.watches.ignore(filepath.FromSlash(name))
+	err = s.deleteFile(name, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if strings.HasSuffix(name, ".md") {
+		s.index.deletePageName(strings.TrimSuffix(name, ".md"))
+	}
+	http.Redirect(w, r, listUrl(name), http.StatusFound)
+}
+
+// renameHandler renames a file or directory. The new name is taken from the form parameter "name" and is relative to
+// the directory of the file. Missing directories are created. Existing files are not overwritten. If the file is a
+// page, the index is updated. The browser is redirected to the list of the original directory.
+func renameHandler(w http.ResponseWriter, r *http.Request, name string) {
+	name = strings.TrimSuffix(name, "/")
+	target := r.FormValue("name")
+	if target == "" {
+		http.Error(w, "the new name is missing", http.StatusBadRequest)
+		return
+	}
+	target = path.Join(path.Dir(name), target)
+	if isHiddenName(target) || strings.HasPrefix(target, "/") {
+		http.Error(w, "can neither confirm nor deny the existence of this resource", http.StatusForbidden)
+		return
+	}
+	s := siteOf(r)
+	fi, err := s.store.stat(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// the new name may be in a directory that requires a different login
+	dest := target
+	if fi.IsDir() {
+		dest += "/"
+	}
+	if !checkAuth(w, r, "rename", dest) {
+		return
+	}
+	_, err = s.store.stat(target)
+	if err == nil {
+		http.Error(w, target+" already exists", http.StatusConflict)
+		return
+	}
+	username := author(r, name, dest)
+	slog.Info("Rename", "page", name, "target", target, "user", username)
+	s.watches.ignore(filepath.FromSlash(name))
+	s.watches.ignore(filepath.FromSlash(target))
+	err = authored(s.store, username).rename(name, target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.renameIndex(name, target, fi.IsDir())
+	http.Redirect(w, r, listUrl(name), http.StatusFound)
+}
+
+// renameIndex updates the index of the site after a file or directory was renamed. Only files ending in ".md" are
+// pages.
+func (s *site) renameIndex(name, target string, dir bool) {
+	if dir {
+		// the pages in the directory all changed their names
+		fs.WalkDir(s.store, target, func(fp string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				rel := strings.TrimPrefix(fp, target+"/")
+				s.renameIndex(path.Join(name, rel), fp, false)
+			}
+			return nil
+		})
+		return
+	}
+	if strings.HasSuffix(name, ".md") {
+		s.index.deletePageName(strings.TrimSuffix(name, ".md"))
+	}
+	if strings.HasSuffix(target, ".md") {
+		p, err := s.loadPage(strings.TrimSuffix(target, ".md"))
+		if err == nil {
+			s.index.update(p)
+		}
+	}
+}
+
+// listUrl returns the URL of the list of the directory a file is in.
+func listUrl(name string) string {
+	dir := path.Dir(name)
+	if dir == "." {
+		return "/list/"
+	}
+	return "/list/" + nameEscape(dir) + "/"
+}
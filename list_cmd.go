@@ -7,7 +7,6 @@ import (
 	"github.com/google/subcommands"
 	"io"
 	"os"
-	"path/filepath"
 	"strings"
 )
 
@@ -54,7 +53,7 @@ func listCli(w io.Writer, dir string, args []string) subcommands.ExitStatus {
 // for substring matching of page names.
 func checkDir(dir string) (string, error) {
 	if dir != "" {
-		fi, err := os.Stat(filepath.FromSlash(dir))
+		fi, err := defaultSite.store.stat(storagePath(dir))
 		if err != nil {
 			fmt.Println(err)
 			return "", err
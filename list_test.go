@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestListHandler(t *testing.T) {
+	cleanup(t, "testdata/list")
+	assert.NoError(t, os.MkdirAll("testdata/list/sub", 0755))
+	p := &Page{Name: "testdata/list/zebra", Body: []byte("# Aardvark\nStriped and busy\n")}
+	p.save()
+	p = &Page{Name: "testdata/list/ant", Body: []byte("# Zebrafish\nTiny and many\n")}
+	p.save()
+	assert.NoError(t, os.WriteFile("testdata/list/.hidden", []byte("secret"), 0644))
+	// redirect to the directory
+	HTTPRedirectTo(t, makeHandler(listHandler, false, http.MethodGet),
+		"GET", "/list/testdata/list", nil, "/list/testdata/list/")
+	// sorted by name, directories first
+	body := assert.HTTPBody(makeHandler(listHandler, false, http.MethodGet), "GET", "/list/testdata/list/", nil)
+	assert.Contains(t, body, "Aardvark")
+	assert.NotContains(t, body, ".hidden")
+	assert.NotContains(t, body, "ant.md~")
+	assert.NotContains(t, body, "Delete")
+	up := strings.Index(body, `href="/list/testdata/list/../"`)
+	sub := strings.Index(body, `href="/list/testdata/list/sub/"`)
+	ant := strings.Index(body, `href="/view/testdata/list/ant.md"`)
+	zebra := strings.Index(body, `href="/view/testdata/list/zebra.md"`)
+	assert.True(t, up > 0 && up < sub && sub < ant && ant < zebra, "Sorted by name: %d %d %d %d", up, sub, ant, zebra)
+	// sorted by title
+	body = assert.HTTPBody(makeHandler(listHandler, false, http.MethodGet), "GET", "/list/testdata/list/", url.Values{"sort": {"title"}})
+	ant = strings.Index(body, `href="/view/testdata/list/ant.md"`)
+	zebra = strings.Index(body, `href="/view/testdata/list/zebra.md"`)
+	assert.True(t, zebra < ant, "Sorted by title: %d %d", zebra, ant)
+}
+
+func TestListFilter(t *testing.T) {
+	cleanup(t, "testdata/list-filter")
+	assert.NoError(t, os.MkdirAll("testdata/list-filter/public", 0755))
+	assert.NoError(t, os.MkdirAll("testdata/list-filter/secret", 0755))
+	t.Setenv("ODDMU_FILTER", "^testdata/list-filter/secret/")
+	body := assert.HTTPBody(makeHandler(listHandler, false, http.MethodGet), "GET", "/list/testdata/list-filter/", nil)
+	assert.Contains(t, body, "public/")
+	assert.NotContains(t, body, "secret/")
+	// the filtered directory itself can be listed
+	assert.HTTPStatusCode(t, makeHandler(listHandler, false, http.MethodGet),
+		"GET", "/list/testdata/list-filter/secret/", nil, http.StatusOK)
+}
+
+func TestListRedirect(t *testing.T) {
+	cleanup(t, "testdata/list-redirect")
+	assert.NoError(t, os.MkdirAll("testdata/list-redirect/sub", 0755))
+	HTTPRedirectTo(t, makeHandler(viewHandler, false, http.MethodGet),
+		"GET", "/view/testdata/list-redirect/sub/", nil, "/list/testdata/list-redirect/sub/")
+}
+
+func TestListUser(t *testing.T) {
+	cleanup(t, "testdata/list-user")
+	p := &Page{Name: "testdata/list-user/index", Body: []byte("# Index\n")}
+	p.save()
+	r := httptest.NewRequest("GET", "/list/testdata/list-user/", nil)
+	r.SetBasicAuth("alex", "secret")
+	w := httptest.NewRecorder()
+	makeHandler(listHandler, false, http.MethodGet)(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `action="/delete/testdata/list-user/index.md"`)
+	assert.Contains(t, w.Body.String(), `action="/rename/testdata/list-user/index.md"`)
+}
+
+func TestDeleteRename(t *testing.T) {
+	cleanup(t, "testdata/delete")
+	p := &Page{Name: "testdata/delete/old", Body: []byte("# Old\nDusty pages\n")}
+	p.save()
+	assert.Equal(t, "Old", index.titles["testdata/delete/old"])
+	// rename a page
+	HTTPRedirectTo(t, makeHandler(renameHandler, true, http.MethodPost),
+		"POST", "/rename/testdata/delete/old.md", url.Values{"name": {"new/page.md"}}, "/list/testdata/delete/")
+	assert.FileExists(t, "testdata/delete/new/page.md")
+	assert.NoFileExists(t, "testdata/delete/old.md")
+	assert.Empty(t, index.titles["testdata/delete/old"])
+	assert.Equal(t, "Old", index.titles["testdata/delete/new/page"])
+	// renaming to an existing file fails
+	p = &Page{Name: "testdata/delete/other", Body: []byte("# Other\n")}
+	p.save()
+	assert.HTTPStatusCode(t, makeHandler(renameHandler, true, http.MethodPost),
+		"POST", "/rename/testdata/delete/other.md", url.Values{"name": {"new/page.md"}}, http.StatusConflict)
+	// rename a directory
+	HTTPRedirectTo(t, makeHandler(renameHandler, true, http.MethodPost),
+		"POST", "/rename/testdata/delete/new/", url.Values{"name": {"newer"}}, "/list/testdata/delete/")
+	assert.FileExists(t, "testdata/delete/newer/page.md")
+	assert.Equal(t, "Old", index.titles["testdata/delete/newer/page"])
+	// delete a page
+	HTTPRedirectTo(t, makeHandler(deleteHandler, true, http.MethodPost),
+		"POST", "/delete/testdata/delete/newer/page.md", nil, "/list/testdata/delete/newer/")
+	assert.NoFileExists(t, "testdata/delete/newer/page.md")
+	assert.FileExists(t, "testdata/delete/newer/page.md~")
+	assert.Empty(t, index.titles["testdata/delete/newer/page"])
+	// directories cannot be deleted
+	assert.HTTPStatusCode(t, makeHandler(deleteHandler, true, http.MethodPost),
+		"POST", "/delete/testdata/delete/newer/", nil, http.StatusBadRequest)
+}
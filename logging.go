@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// initLogging sets up the default logger. The environment variable ODDMU_LOG_LEVEL is one of "debug", "info", "warn"
+// or "error" and defaults to "info". The environment variable ODDMU_LOG_FORMAT is either "text" or "json" and
+// defaults to "text". The standard logger used by libraries writes to the same logger.
+func initLogging() {
+	slog.SetDefault(slog.New(logHandler(os.Stderr)))
+}
+
+// logHandler returns the handler for log records written to w, according to ODDMU_LOG_LEVEL and ODDMU_LOG_FORMAT.
+func logHandler(w io.Writer) slog.Handler {
+	var level slog.Level
+	s := setting("log_level")
+	if s != "" {
+		err := level.UnmarshalText([]byte(s))
+		if err != nil {
+			level = slog.LevelInfo
+			defer slog.Warn("ODDMU_LOG_LEVEL is invalid", "level", s, "err", err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(setting("log_format")) {
+	case "json":
+		return slog.NewJSONHandler(w, opts)
+	case "", "text":
+		return slog.NewTextHandler(w, opts)
+	default:
+		defer slog.Warn("ODDMU_LOG_FORMAT is invalid", "format", setting("log_format"))
+		return slog.NewTextHandler(w, opts)
+	}
+}
+
+// accessLog wraps a handler and logs every request with the method, path, status code, number of bytes written,
+// duration, IP number and user, if logged in.
+func accessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		username, _, _ := r.BasicAuth()
+		slog.Info("Request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.code,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"ip", clientIP(r),
+			"user", username)
+	})
+}
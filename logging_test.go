@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// captureLog sends the log to a buffer until the test is done.
+func captureLog(t *testing.T) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	logger, w, flags := slog.Default(), log.Writer(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(logger)
+		log.SetOutput(w)
+		log.SetFlags(flags)
+	})
+	slog.SetDefault(slog.New(logHandler(buf)))
+	return buf
+}
+
+func TestLogLevel(t *testing.T) {
+	t.Setenv("ODDMU_LOG_LEVEL", "warn")
+	buf := captureLog(t)
+	slog.Info("Hidden")
+	slog.Warn("Shown", "page", "index")
+	assert.NotContains(t, buf.String(), "Hidden")
+	assert.Contains(t, buf.String(), "level=WARN msg=Shown page=index")
+}
+
+func TestLogFormat(t *testing.T) {
+	t.Setenv("ODDMU_LOG_FORMAT", "json")
+	buf := captureLog(t)
+	slog.Info("Save", "page", "index", "user", "alex")
+	record := map[string]any{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "Save", record["msg"])
+	assert.Equal(t, "index", record["page"])
+	assert.Equal(t, "alex", record["user"])
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Setenv("ODDMU_LOG_FORMAT", "json")
+	buf := captureLog(t)
+	h := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not here", http.StatusNotFound)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/view/nowhere", nil)
+	r.SetBasicAuth("alex", "secret")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	record := map[string]any{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "Request", record["msg"])
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/view/nowhere", record["path"])
+	assert.Equal(t, float64(http.StatusNotFound), record["status"])
+	assert.Equal(t, float64(len("Not here\n")), record["bytes"])
+	assert.Equal(t, "alex", record["user"])
+	assert.Contains(t, record, "duration")
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsBuckets are the upper bounds of the request duration histogram buckets, in seconds.
+var metricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// actionMetrics are the metrics collected for an action.
+type actionMetrics struct {
+	// codes maps HTTP status codes to the number of requests.
+	codes map[int]int
+
+	// buckets counts the requests per duration bucket. The last element is for requests taking longer than the
+	// last bucket in metricsBuckets.
+	buckets []int
+
+	// sum is the total duration of all the requests, in seconds.
+	sum float64
+
+	// count is the number of requests.
+	count int
+}
+
+// metricStore controls access to the metrics. Make sure to lock and unlock as appropriate.
+type metricStore struct {
+	sync.Mutex
+
+	// actions maps actions like "view" to their metrics. Requests that don't map to an action use "root".
+	actions map[string]*actionMetrics
+
+	// templateReloads counts the number of templates reloaded.
+	templateReloads int
+
+	// uploads counts the number of files uploaded.
+	uploads int
+}
+
+var metrics = metricStore{actions: make(map[string]*actionMetrics)}
+
+// ready is set once the indexing, language loading and watcher installation started by serve are done.
+var ready atomic.Bool
+
+// startup runs the functions in parallel and sets ready once all of them are done.
+func startup(fns ...func()) {
+	var wg sync.WaitGroup
+	for _, fn := range fns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	wg.Wait()
+	ready.Store(true)
+}
+
+// record records a request for an action with its status code and duration.
+func (m *metricStore) record(action string, code int, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	a, ok := m.actions[action]
+	if !ok {
+		a = &actionMetrics{codes: make(map[int]int), buckets: make([]int, len(metricsBuckets)+1)}
+		m.actions[action] = a
+	}
+	a.codes[code]++
+	s := d.Seconds()
+	i, _ := slices.BinarySearch(metricsBuckets, s)
+	a.buckets[i]++
+	a.sum += s
+	a.count++
+}
+
+// templateReloaded counts a template reload.
+func (m *metricStore) templateReloaded(n int) {
+	m.Lock()
+	defer m.Unlock()
+	m.templateReloads += n
+}
+
+// uploaded counts an upload.
+func (m *metricStore) uploaded() {
+	m.Lock()
+	defer m.Unlock()
+	m.uploads++
+}
+
+// statusRecorder is a http.ResponseWriter that remembers the status code and the number of bytes written. It is used
+// by [measure] and [accessLog].
+type statusRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+// WriteHeader remembers the status code and writes the header.
+func (w *statusRecorder) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write counts the bytes written.
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap returns the original http.ResponseWriter, for http.ResponseController.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// measure wraps the handlers of the mux and records the status code and duration of every request. The action is
+// determined by the pattern the request matches: "/view/" is the "view" action. Everything else is the "root" action.
+func measure(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		mux.ServeHTTP(rec, r)
+		_, pattern := mux.Handler(r)
+		action := strings.Trim(pattern, "/")
+		if action == "" {
+			action = "root"
+		}
+		metrics.record(action, rec.code, time.Since(start))
+	})
+}
+
+// healthHandler reports that the server is running.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok\n")
+}
+
+// readyHandler reports whether the server is ready: the pages have been indexed, the languages have been loaded and
+// the watchers have been installed. If not, the status is 503.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ready\n")
+}
+
+// metricsHandler writes the metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
+
+// writeMetrics writes the metrics in the Prometheus text format.
+func writeMetrics(w io.Writer) {
+	metrics.Lock()
+	actions := []string{}
+	for action := range metrics.actions {
+		actions = append(actions, action)
+	}
+	slices.Sort(actions)
+	fmt.Fprintln(w, "# HELP oddmu_requests_total Number of HTTP requests by action and status code.")
+	fmt.Fprintln(w, "# TYPE oddmu_requests_total counter")
+	for _, action := range actions {
+		a := metrics.actions[action]
+		codes := []int{}
+		for code := range a.codes {
+			codes = append(codes, code)
+		}
+		slices.Sort(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "oddmu_requests_total{action=%q,code=\"%d\"} %d\n", action, code, a.codes[code])
+		}
+	}
+	fmt.Fprintln(w, "# HELP oddmu_request_duration_seconds Duration of HTTP requests by action.")
+	fmt.Fprintln(w, "# TYPE oddmu_request_duration_seconds histogram")
+	for _, action := range actions {
+		a := metrics.actions[action]
+		n := 0
+		for i, le := range metricsBuckets {
+			n += a.buckets[i]
+			fmt.Fprintf(w, "oddmu_request_duration_seconds_bucket{action=%q,le=%q} %d\n",
+				action, strconv.FormatFloat(le, 'g', -1, 64), n)
+		}
+		fmt.Fprintf(w, "oddmu_request_duration_seconds_bucket{action=%q,le=\"+Inf\"} %d\n", action, a.count)
+		fmt.Fprintf(w, "oddmu_request_duration_seconds_sum{action=%q} %g\n", action, a.sum)
+		fmt.Fprintf(w, "oddmu_request_duration_seconds_count{action=%q} %d\n", action, a.count)
+	}
+	fmt.Fprintln(w, "# HELP oddmu_template_reloads_total Number of templates reloaded.")
+	fmt.Fprintln(w, "# TYPE oddmu_template_reloads_total counter")
+	fmt.Fprintf(w, "oddmu_template_reloads_total %d\n", metrics.templateReloads)
+	fmt.Fprintln(w, "# HELP oddmu_uploads_total Number of files uploaded.")
+	fmt.Fprintln(w, "# TYPE oddmu_uploads_total counter")
+	fmt.Fprintf(w, "oddmu_uploads_total %d\n", metrics.uploads)
+	metrics.Unlock()
+	pages, tokens, dirs, queue := 0, 0, 0, 0
+	for _, s := range hostSites.all() {
+		s.index.RLock()
+		pages += len(s.index.titles)
+		tokens += len(s.index.token)
+		s.index.RUnlock()
+		s.watches.RLock()
+		queue += len(s.watches.files)
+		if s.watches.watcher != nil {
+			dirs += len(s.watches.watcher.WatchList())
+		}
+		s.watches.RUnlock()
+	}
+	fmt.Fprintln(w, "# HELP oddmu_index_pages Number of pages in the index.")
+	fmt.Fprintln(w, "# TYPE oddmu_index_pages gauge")
+	fmt.Fprintf(w, "oddmu_index_pages %d\n", pages)
+	fmt.Fprintln(w, "# HELP oddmu_index_tokens Number of distinct tokens in the index.")
+	fmt.Fprintln(w, "# TYPE oddmu_index_tokens gauge")
+	fmt.Fprintf(w, "oddmu_index_tokens %d\n", tokens)
+	fmt.Fprintln(w, "# HELP oddmu_watched_directories Number of directories watched for changes.")
+	fmt.Fprintln(w, "# TYPE oddmu_watched_directories gauge")
+	fmt.Fprintf(w, "oddmu_watched_directories %d\n", dirs)
+	fmt.Fprintln(w, "# HELP oddmu_watch_queue_length Number of changed files waiting to be handled.")
+	fmt.Fprintln(w, "# TYPE oddmu_watch_queue_length gauge")
+	fmt.Fprintf(w, "oddmu_watch_queue_length %d\n", queue)
+	fmt.Fprintln(w, "# HELP oddmu_ready Whether the server is ready.")
+	fmt.Fprintln(w, "# TYPE oddmu_ready gauge")
+	if ready.Load() {
+		fmt.Fprintln(w, "oddmu_ready 1")
+	} else {
+		fmt.Fprintln(w, "oddmu_ready 0")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRecord(t *testing.T) {
+	m := metricStore{actions: make(map[string]*actionMetrics)}
+	m.record("view", http.StatusOK, 3*time.Millisecond)
+	m.record("view", http.StatusNotFound, 300*time.Millisecond)
+	m.record("view", http.StatusOK, time.Minute)
+	a := m.actions["view"]
+	assert.Equal(t, 3, a.count)
+	assert.Equal(t, 2, a.codes[http.StatusOK])
+	assert.Equal(t, 1, a.codes[http.StatusNotFound])
+	assert.Equal(t, 1, a.buckets[0])
+	assert.Equal(t, 1, a.buckets[6])
+	assert.Equal(t, 1, a.buckets[len(metricsBuckets)])
+}
+
+func TestMetricsHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/view/", makeHandler(viewHandler, false, http.MethodGet, http.MethodHead))
+	mux.HandleFunc("/metrics", metricsHandler)
+	h := measure(mux)
+	r := httptest.NewRequest(http.MethodGet, "/view/does-not-exist-for-metrics", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	r = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	body := w.Body.String()
+	assert.Contains(t, body, `oddmu_requests_total{action="view",code="302"}`)
+	assert.Contains(t, body, `oddmu_request_duration_seconds_bucket{action="view",le="+Inf"}`)
+	assert.Contains(t, body, "# TYPE oddmu_index_pages gauge")
+	assert.Contains(t, body, "oddmu_watched_directories ")
+	assert.Contains(t, body, "oddmu_uploads_total ")
+}
+
+func TestHealthAndReady(t *testing.T) {
+	assert.HTTPStatusCode(t, healthHandler, http.MethodGet, "/healthz", nil, http.StatusOK)
+	ready.Store(false)
+	t.Cleanup(func() { ready.Store(false) })
+	assert.HTTPStatusCode(t, readyHandler, http.MethodGet, "/readyz", nil, http.StatusServiceUnavailable)
+	done := 0
+	startup(func() { done++ })
+	assert.Equal(t, 1, done)
+	assert.HTTPStatusCode(t, readyHandler, http.MethodGet, "/readyz", nil, http.StatusOK)
+}
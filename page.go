@@ -4,13 +4,13 @@ import (
 	"bytes"
 	"github.com/microcosm-cc/bluemonday"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/url"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,6 +23,15 @@ type Page struct {
 	Body     []byte
 	Html     template.HTML
 	Hashtags []string
+
+	// site is the site the page belongs to. If nil, it's the default site. See [Page.wiki].
+	site *site
+
+	// author is the user making changes to the page, if known. See [authored].
+	author string
+
+	// rev is the hash of the commit shown by [Page.Diff], if the page is stored in git.
+	rev string
 }
 
 // Link is a struct containing a title and a name. Name is the path without extension (so a path of "foo.md" results in
@@ -45,12 +54,115 @@ func sanitizeStrict(s string) template.HTML {
 }
 
 // unsafeBytes does not use bluemonday to sanitize the HTML used for pages. This is where you make changes if you want
-// to be more lenient. If you look at the git repository, there are older versions containing the function sanitizeBytes
-// which would do elaborate checking.
+// to be more lenient. See sanitizeBytes for the alternative.
 func unsafeBytes(bytes []byte) template.HTML {
 	return template.HTML(bytes)
 }
 
+// sanitizeBytes uses bluemonday to sanitize the HTML used for pages using the policy for user-generated content. The
+// links Oddmu generates for hashtags and accounts keep their class, code blocks keep their language class, images
+// keep lazy loading, video and audio elements are allowed, and iframes are allowed if their source is on one of the
+// hosts listed in the environment variable ODDMU_EMBED_HOSTS (comma-separated).
+func sanitizeBytes(bytes []byte) template.HTML {
+	return sanitizeBytesFor(defaultSite, bytes)
+}
+
+// sanitizeBytesFor is like sanitizeBytes but uses the setting "embed_hosts" of the site.
+func sanitizeBytesFor(s *site, bytes []byte) template.HTML {
+	return template.HTML(policies.lookup(s.setting("embed_hosts")).SanitizeBytes(bytes))
+}
+
+// Regular expressions used by the policy for user-generated content. See [newPolicy].
+var (
+	tagClassRe      = regexp.MustCompile(`^(tag|account)$`)
+	languageClassRe = regexp.MustCompile(`^language-[\w+-]+$`)
+	loadingRe       = regexp.MustCompile(`^(lazy|eager)$`)
+	emptyRe         = regexp.MustCompile(`^$`)
+	preloadRe       = regexp.MustCompile(`^(none|metadata|auto)$`)
+	mimeTypeRe      = regexp.MustCompile(`^[\w.+-]+/[\w.+-]+$`)
+)
+
+// policyStore caches the policies for user-generated content. Building a policy is expensive and the setting
+// "embed_hosts" rarely changes, so there is a policy for every value of the setting used.
+type policyStore struct {
+	sync.Mutex
+
+	// policies maps the values of the setting "embed_hosts" to their policy.
+	policies map[string]*bluemonday.Policy
+}
+
+var policies = policyStore{policies: make(map[string]*bluemonday.Policy)}
+
+// lookup returns the policy for a value of the setting "embed_hosts", creating it if necessary.
+func (p *policyStore) lookup(embedHosts string) *bluemonday.Policy {
+	p.Lock()
+	defer p.Unlock()
+	policy, ok := p.policies[embedHosts]
+	if !ok {
+		policy = newPolicy(embedHosts)
+		p.policies[embedHosts] = policy
+	}
+	return policy
+}
+
+// newPolicy returns the policy for user-generated content. Iframes are allowed if their source is on one of the hosts
+// listed (comma-separated).
+func newPolicy(embedHosts string) *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("class").Matching(tagClassRe).OnElements("a")
+	policy.AllowAttrs("class").Matching(languageClassRe).OnElements("code")
+	policy.AllowAttrs("loading").Matching(loadingRe).OnElements("img")
+	policy.AllowElements("video", "audio")
+	policy.AllowAttrs("src", "poster").OnElements("video", "audio")
+	policy.AllowAttrs("controls", "loop", "muted", "playsinline").Matching(emptyRe).OnElements("video", "audio")
+	policy.AllowAttrs("preload").Matching(preloadRe).OnElements("video", "audio")
+	policy.AllowAttrs("width", "height").Matching(bluemonday.NumberOrPercent).OnElements("video", "iframe")
+	policy.AllowAttrs("src").OnElements("source", "track")
+	policy.AllowAttrs("type").Matching(mimeTypeRe).OnElements("source")
+	policy.AllowAttrs("kind", "label", "srclang").OnElements("track")
+	hosts := []string{}
+	for _, host := range strings.Split(embedHosts, ",") {
+		host = strings.TrimSpace(host)
+		if host != "" {
+			hosts = append(hosts, regexp.QuoteMeta(host))
+		}
+	}
+	if len(hosts) > 0 {
+		re := regexp.MustCompile(`^https://(` + strings.Join(hosts, "|") + `)/`)
+		policy.AllowAttrs("src").Matching(re).OnElements("iframe")
+		policy.AllowAttrs("title", "allow").OnElements("iframe")
+		policy.AllowAttrs("allowfullscreen").Matching(emptyRe).OnElements("iframe")
+	}
+	return policy
+}
+
+// sanitize returns the HTML for the page. If the environment variable ODDMU_SANITIZE is a regular expression matching
+// the page name, the HTML is sanitized using sanitizeBytes. If the regular expression doesn't compile, all pages are
+// sanitized. Otherwise, the HTML is used as is, see unsafeBytes.
+func (p *Page) sanitize(bytes []byte) template.HTML {
+	s := p.wiki().setting("sanitize")
+	if s == "" {
+		return unsafeBytes(bytes)
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		slog.Error("ODDMU_SANITIZE does not compile", "sanitize", s, "err", err)
+		return sanitizeBytesFor(p.wiki(), bytes)
+	}
+	if re.MatchString(p.Name) {
+		return sanitizeBytesFor(p.wiki(), bytes)
+	}
+	return unsafeBytes(bytes)
+}
+
+// wiki returns the site the page belongs to.
+func (p *Page) wiki() *site {
+	if p.site == nil {
+		return defaultSite
+	}
+	return p.site
+}
+
 // nameEscape returns the page name safe for use in URLs. That is, percent escaping is used except for the slashes.
 func nameEscape(s string) string {
 	parts := strings.Split(s, "/")
@@ -64,33 +176,26 @@ func nameEscape(s string) string {
 // carriage return characters ("\r"). Page.Title and Page.Html are not saved. There is no caching. Before removing or
 // writing a file, the old copy is renamed to a backup, appending "~". Errors are not logged but returned.
 func (p *Page) save() error {
-	fp := filepath.FromSlash(p.Name) + ".md"
-	watches.ignore(fp)
+	w := p.wiki()
+	name := p.Name + ".md"
+	w.watches.ignore(filepath.FromSlash(name))
 	s := bytes.ReplaceAll(p.Body, []byte{'\r'}, []byte{})
 	if len(s) == 0 {
-		log.Println("Delete", p.Name)
-		index.remove(p)
-		return os.Rename(fp, fp+"~")
+		slog.Info("Delete", "page", p.Name)
+		w.index.remove(p)
+		return w.deleteFile(name, p.author)
 	}
 	p.Body = s
-	index.update(p)
-	d := filepath.Dir(fp)
-	if d != "." {
-		err := os.MkdirAll(d, 0755)
-		if err != nil {
-			return err
-		}
-	}
-	err := backup(fp)
+	w.index.update(p)
+	err := w.backup(name)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(fp, s, 0644)
+	return authored(w.store, p.author).writeFile(name, s)
 }
 
 func (p *Page) ModTime() (time.Time, error) {
-	fp := filepath.FromSlash(p.Name) + ".md"
-	fi, err := os.Stat(fp)
+	fi, err := p.wiki().store.stat(p.Name + ".md")
 	if err != nil {
 		return time.Now(), err
 	}
@@ -99,36 +204,53 @@ func (p *Page) ModTime() (time.Time, error) {
 
 // backup a file by renaming it unless the existing backup is less than an hour old. A backup gets a tilde appended to
 // it ("~"). This is true even if the file refers to a binary file like "image.png" and most applications don't know
-// what to do with a file called "image.png~". This expects a filepath. The backup file gets its modification time set
-// to now so that subsequent edits don't immediately overwrite it again.
-func backup(fp string) error {
-	_, err := os.Stat(fp)
+// what to do with a file called "image.png~". This expects a name used by the storage. The backup file gets its
+// modification time set to now so that subsequent edits don't immediately overwrite it again. If the storage keeps
+// old versions by itself, no backup is made.
+func (s *site) backup(name string) error {
+	if s.store.versioned() {
+		return nil
+	}
+	_, err := s.store.stat(name)
 	if err != nil {
 		return nil
 	}
-	bp := fp + "~"
-	fi, err := os.Stat(bp)
+	bp := name + "~"
+	fi, err := s.store.stat(bp)
 	if err != nil || time.Since(fi.ModTime()).Minutes() >= 60 {
-		err = os.Rename(fp, bp)
+		err = s.store.rename(name, bp)
 		if err != nil {
 			return err
 		}
-		ts := time.Now()
-		return os.Chtimes(bp, ts, ts)
+		return s.store.touch(bp, time.Now())
 	}
 	return nil
 }
 
+// deleteFile deletes a file by renaming it to the backup file, appending "~". This expects a name used by the
+// storage. If the storage keeps old versions by itself, the file is removed instead, on behalf of the user.
+func (s *site) deleteFile(name, user string) error {
+	if s.store.versioned() {
+		return authored(s.store, user).remove(name)
+	}
+	return s.store.rename(name, name+"~")
+}
+
 // loadPage loads a Page given a name. The path loaded is that Page.Name with the ".md" extension. The Page.Title is set
 // to the Page.Name (and possibly changed, later). The Page.Body is set to the file content. The Page.Html remains
 // undefined (there is no caching).
 func loadPage(name string) (*Page, error) {
+	return defaultSite.loadPage(name)
+}
+
+// loadPage loads a Page of the site given a name. See [loadPage].
+func (s *site) loadPage(name string) (*Page, error) {
 	name = strings.TrimPrefix(name, "./") // result of a path.TreeWalk starting with "."
-	body, err := os.ReadFile(filepath.FromSlash(name) + ".md")
+	body, err := s.store.readFile(name + ".md")
 	if err != nil {
 		return nil, err
 	}
-	return &Page{Title: name, Name: name, Body: body}, nil
+	return &Page{Title: name, Name: name, Body: body, site: s}, nil
 }
 
 // handleTitle extracts the title from a Page and sets Page.Title, if any. If replace is true, the page title is also
@@ -218,8 +340,9 @@ func (p *Page) Today() string {
 // Parents returns a Link array to parent pages, up the directory structure.
 func (p *Page) Parents() []*Link {
 	links := make([]*Link, 0)
-	index.RLock()
-	defer index.RUnlock()
+	idx := p.wiki().index
+	idx.RLock()
+	defer idx.RUnlock()
 	// foo/bar/baz ⇒ index, foo/index
 	elems := strings.Split(p.Name, "/")
 	if len(elems) == 1 {
@@ -228,7 +351,7 @@ func (p *Page) Parents() []*Link {
 	s := ""
 	for i := 0; i < len(elems)-1; i++ {
 		name := s + "index"
-		title, ok := index.titles[name]
+		title, ok := idx.titles[name]
 		if !ok {
 			title = "…"
 		}
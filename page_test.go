@@ -67,3 +67,34 @@ And untouchable`)}
 	assert.Equal(t, "../index", parents[3].Url)
 	assert.Equal(t, 4, len(parents))
 }
+
+func TestPageSanitize(t *testing.T) {
+	body := []byte(`<script>alert("Gotcha!")</script>
+#Stars glittering
+<iframe src="https://www.youtube-nocookie.com/embed/stars"></iframe>
+<iframe src="https://example.org/stars"></iframe>
+<video controls src="stars.mp4"></video>`)
+	p := &Page{Name: "public/stars", Body: body}
+	p.renderHtml()
+	assert.Contains(t, string(p.Html), "<script>")
+	t.Setenv("ODDMU_SANITIZE", "^public/")
+	t.Setenv("ODDMU_EMBED_HOSTS", "www.youtube-nocookie.com")
+	p.renderHtml()
+	assert.NotContains(t, string(p.Html), "<script>")
+	assert.Contains(t, string(p.Html), `<a class="tag" href="/search/?q=%23Stars" rel="nofollow">#Stars</a>`)
+	assert.Contains(t, string(p.Html), `<iframe src="https://www.youtube-nocookie.com/embed/stars"></iframe>`)
+	assert.NotContains(t, string(p.Html), "example.org")
+	assert.Contains(t, string(p.Html), `<video controls="" src="stars.mp4"></video>`)
+	// other pages are not sanitized
+	p = &Page{Name: "private/stars", Body: body}
+	p.renderHtml()
+	assert.Contains(t, string(p.Html), "<script>")
+	// policies are reused until the embedded hosts change
+	policy := policies.lookup("www.youtube-nocookie.com")
+	assert.Same(t, policy, policies.lookup("www.youtube-nocookie.com"))
+	assert.NotSame(t, policy, policies.lookup(""))
+	t.Setenv("ODDMU_EMBED_HOSTS", "")
+	p = &Page{Name: "public/stars", Body: body}
+	p.renderHtml()
+	assert.NotContains(t, string(p.Html), "<iframe")
+}
@@ -80,7 +80,7 @@ func wikiParser() (*parser.Parser, *[]string) {
 }
 
 // wikiRenderer is a Renderer for Markdown that adds lazy loading of images and disables fractions support. Remember
-// that there is no HTML sanitization.
+// that there is no HTML sanitization unless ODDMU_SANITIZE is set. See Page.sanitize.
 func wikiRenderer() *html.Renderer {
 	// sync with staticPage
 	htmlFlags := html.CommonFlags & ^html.SmartypantsFractions | html.LazyLoadImages
@@ -94,7 +94,7 @@ func (p *Page) renderHtml() {
 	parser, hashtags := wikiParser()
 	renderer := wikiRenderer()
 	maybeUnsafeHTML := markdown.ToHTML(p.Body, parser, renderer)
-	p.Html = unsafeBytes(maybeUnsafeHTML)
+	p.Html = p.sanitize(maybeUnsafeHTML)
 	p.Hashtags = *hashtags
 }
 
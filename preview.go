@@ -18,8 +18,9 @@ func previewHandler(w http.ResponseWriter, r *http.Request, path string) {
 		return
 	}
 	body := strings.ReplaceAll(r.FormValue("body"), "\r", "")
-	p := &Page{Name: path, Body: []byte(body)}
+	s := siteOf(r)
+	p := &Page{Name: path, Body: []byte(body), site: s}
 	p.handleTitle(true)
 	p.renderHtml()
-	renderTemplate(w, p.Dir(), "preview", p)
+	s.templates.render(w, p.Dir(), "preview", p)
 }
@@ -11,7 +11,7 @@ import (
 	"io"
 	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
 	"regexp"
 	"slices"
 	"strings"
@@ -55,14 +55,22 @@ func replaceCli(w io.Writer, isConfirmed bool, isRegexp bool, args []string) sub
 	}
 	repl := []byte(args[1])
 	changes := 0
-	err := filepath.Walk(".", func(fp string, info fs.FileInfo, err error) error {
+	store := defaultSite.store
+	versioned := store.versioned()
+	// with git, all the changes are committed together at the end
+	g, isGit := store.(*gitStorage)
+	if isGit {
+		store = &g.fileStorage
+	}
+	names := []string{}
+	err := fs.WalkDir(store, ".", func(fp string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 		// skip hidden directories and files
-		if fp != "." && strings.HasPrefix(filepath.Base(fp), ".") {
-			if info.IsDir() {
-				return filepath.SkipDir
+		if fp != "." && strings.HasPrefix(path.Base(fp), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
 			} else {
 				return nil
 			}
@@ -71,7 +79,7 @@ func replaceCli(w io.Writer, isConfirmed bool, isRegexp bool, args []string) sub
 		if !strings.HasSuffix(fp, ".md") {
 			return nil
 		}
-		body, err := os.ReadFile(fp)
+		body, err := store.readFile(fp)
 		if err != nil {
 			return err
 		}
@@ -80,11 +88,14 @@ func replaceCli(w io.Writer, isConfirmed bool, isRegexp bool, args []string) sub
 			changes++
 			if isConfirmed {
 				fmt.Fprintln(w, fp)
-				_ = os.Rename(fp, fp+"~")
-				err = os.WriteFile(fp, result, 0644)
+				if !versioned {
+					_ = store.rename(fp, fp+"~")
+				}
+				err = store.writeFile(fp, result)
 				if err != nil {
 					return err
 				}
+				names = append(names, fp)
 			} else {
 				edits := myers.ComputeEdits(span.URIFromPath(fp+"~"), string(body), string(result))
 				diff := fmt.Sprint(gotextdiff.ToUnified(fp+"~", fp, string(body), edits))
@@ -93,6 +104,13 @@ func replaceCli(w io.Writer, isConfirmed bool, isRegexp bool, args []string) sub
 		}
 		return nil
 	})
+	// commit the files changed, even if there was an error
+	if isGit && len(names) > 0 {
+		e := g.commit("Replace "+args[0]+" with "+args[1], names...)
+		if err == nil {
+			err = e
+		}
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return subcommands.ExitFailure
@@ -2,9 +2,8 @@ package main
 
 import (
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"path"
 	"regexp"
 	"slices"
@@ -41,17 +40,17 @@ type Search struct {
 // whether the query string matches the page title; 2. descending if
 // the page titles start with a digit; 3. otherwise ascending.
 // Access to the index requires a read lock!
-func sortNames(tokens []string) func(a, b string) int {
+func (idx *indexStore) sortNames(tokens []string) func(a, b string) int {
 	return func(a, b string) int {
 		// If only one page contains the query string, it
 		// takes precedence.
 		ia := false
 		ib := false
 		for _, token := range tokens {
-			if !ia && strings.Contains(index.titles[a], token) {
+			if !ia && strings.Contains(idx.titles[a], token) {
 				ia = true
 			}
-			if !ib && strings.Contains(index.titles[b], token) {
+			if !ib && strings.Contains(idx.titles[b], token) {
 				ib = true
 			}
 		}
@@ -79,9 +78,9 @@ func sortNames(tokens []string) func(a, b string) int {
 			}
 		}
 		// Otherwise sort by title, ascending.
-		if index.titles[a] < index.titles[b] {
+		if idx.titles[a] < idx.titles[b] {
 			return -1
-		} else if index.titles[a] > index.titles[b] {
+		} else if idx.titles[a] > idx.titles[b] {
 			return 1
 		}
 		// Either the titles are equal or the index isn't
@@ -104,37 +103,43 @@ const itemsPerPage = 20
 // results if runtime is not an issue, like on the command line. The boolean return value indicates whether there are
 // more results.
 func search(q, dir, filter string, page int, all bool) ([]*Result, bool) {
+	return defaultSite.search(q, dir, filter, page, all)
+}
+
+// search returns the search results for the site. See [search].
+func (s *site) search(q, dir, filter string, page int, all bool) ([]*Result, bool) {
 	if len(q) == 0 {
 		return make([]*Result, 0), false
 	}
-	names := index.search(q) // hashtags or all names
+	names := s.index.search(q) // hashtags or all names
 	names = filterPath(names, dir, filter)
 	predicates, terms := predicatesAndTokens(q)
-	names = filterNames(names, predicates)
-	index.RLock()
-	slices.SortFunc(names, sortNames(terms))
-	index.RUnlock() // unlock because grep takes long
-	names, keepFirst := prependQueryPage(names, dir, q)
+	names = s.index.filterNames(names, predicates)
+	s.index.RLock()
+	slices.SortFunc(names, s.index.sortNames(terms))
+	s.index.RUnlock() // unlock because grep takes long
+	names, keepFirst := s.index.prependQueryPage(names, dir, q)
 	from := itemsPerPage * (page - 1)
 	to := from + itemsPerPage - 1
-	items, more := grep(terms, names, from, to, all, keepFirst)
+	items, more := s.grep(terms, names, from, to, all, keepFirst)
 	results := make([]*Result, len(items))
 	for i, p := range items {
 		r := &Result{}
 		r.Title = p.Title
 		r.Name = p.Name
 		r.Body = p.Body
+		r.site = s
 		// Hashtags aren't computed and Html is getting overwritten anyway
 		r.summarize(q)
 		r.score(q)
 		results[i] = r
 	}
 	if len(terms) > 0 {
-		index.RLock()
+		s.index.RLock()
 		for _, r := range results {
 			res := make([]ImageData, 0)
 		ImageLoop:
-			for _, img := range index.images[r.Name] {
+			for _, img := range s.index.images[r.Name] {
 				title := strings.ToLower(img.Title)
 				for _, term := range terms {
 					if strings.Contains(title, term) {
@@ -149,7 +154,7 @@ func search(q, dir, filter string, page int, all bool) ([]*Result, bool) {
 			}
 			r.Images = res
 		}
-		index.RUnlock()
+		s.index.RUnlock()
 	}
 	return results, more
 }
@@ -173,7 +178,7 @@ func (r *Result) score(q string) {
 func filterPath(names []string, prefix, filter string) []string {
 	re, err := regexp.Compile(filter)
 	if err != nil {
-		log.Println("ODDMU_FILTER does not compile:", filter, err)
+		slog.Error("ODDMU_FILTER does not compile", "filter", filter, "err", err)
 		return []string{}
 	}
 	matches := re.MatchString(prefix)
@@ -189,20 +194,20 @@ func filterPath(names []string, prefix, filter string) []string {
 
 // filterNames filters the names by all the predicats such as
 // "title:foo" or "blog:true".
-func filterNames(names, predicates []string) []string {
+func (idx *indexStore) filterNames(names, predicates []string) []string {
 	if len(predicates) == 0 {
 		return names
 	}
 	// the intersection requires sorted lists
 	slices.Sort(names)
-	index.RLock()
-	defer index.RUnlock()
+	idx.RLock()
+	defer idx.RUnlock()
 	for _, predicate := range predicates {
 		r := make([]string, 0)
 		if strings.HasPrefix(predicate, "title:") {
 			token := predicate[6:]
 			for _, name := range names {
-				if strings.Contains(strings.ToLower(index.titles[name]), token) {
+				if strings.Contains(strings.ToLower(idx.titles[name]), token) {
 					r = append(r, name)
 				}
 			}
@@ -216,7 +221,7 @@ func filterNames(names, predicates []string) []string {
 				}
 			}
 		} else {
-			log.Printf("Unsupported predicate: %s", predicate)
+			slog.Warn("Unsupported predicate", "predicate", predicate)
 		}
 		names = intersection(names, r)
 	}
@@ -226,14 +231,14 @@ func filterNames(names, predicates []string) []string {
 // grep searches the files for matches to all the tokens. It returns just a single page of results based [from:to-1] and
 // returns if there are more results. The all parameter ignores pagination (the from and to parameters). The keepFirst
 // parameter keeps the first page in the list, even if there is no match. This is used for hashtag pages.
-func grep(tokens, names []string, from, to int, all, keepFirst bool) ([]*Page, bool) {
+func (s *site) grep(tokens, names []string, from, to int, all, keepFirst bool) ([]*Page, bool) {
 	pages := make([]*Page, 0)
 	i := 0
 NameLoop:
 	for n, name := range names {
-		p, err := loadPage(name)
+		p, err := s.loadPage(name)
 		if err != nil {
-			log.Printf("grep: cannot load %s: %s", name, err)
+			slog.Warn("grep: cannot load page", "page", name, "err", err)
 			continue NameLoop
 		}
 		if n != 0 || !keepFirst {
@@ -258,9 +263,9 @@ NameLoop:
 // prependQueryPage prepends the query itself, if a matching page name exists. This helps if people remember the name
 // exactly, or if searching for a hashtag. This function assumes that q is not the empty string. Return wether a page
 // was prepended or not.
-func prependQueryPage(names []string, dir, q string) ([]string, bool) {
-	index.RLock()
-	defer index.RUnlock()
+func (idx *indexStore) prependQueryPage(names []string, dir, q string) ([]string, bool) {
+	idx.RLock()
+	defer idx.RUnlock()
 	if q[0] == '#' && !strings.Contains(q[1:], "#") {
 		q = q[1:]
 	}
@@ -276,7 +281,7 @@ func prependQueryPage(names []string, dir, q string) ([]string, bool) {
 		return r, false
 	}
 	// otherwise, if q is a known page name, prepend it
-	_, ok := index.titles[q]
+	_, ok := idx.titles[q]
 	if ok {
 		return append([]string{q}, names...), true
 	}
@@ -294,11 +299,12 @@ func searchHandler(w http.ResponseWriter, r *http.Request, dir string) {
 	if err != nil {
 		page = 1
 	}
-	filter := os.Getenv("ODDMU_FILTER")
-	items, more := search(q, dir, filter, page, false)
-	s := &Search{Query: q, Dir: dir, Items: items, Previous: page - 1, Page: page, Next: page + 1,
+	s := siteOf(r)
+	filter := s.dirSetting("filter", dir)
+	items, more := s.search(q, dir, filter, page, false)
+	data := &Search{Query: q, Dir: dir, Items: items, Previous: page - 1, Page: page, Next: page + 1,
 		Results: len(items) > 0, More: more}
-	renderTemplate(w, dir, "search", s)
+	s.templates.render(w, dir, "search", data)
 }
 
 // Path returns the ImageData.Name with some characters escaped because html/template doesn't escape those. This is
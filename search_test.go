@@ -16,7 +16,7 @@ func TestSortNames(t *testing.T) {
 		index.titles[s] = s
 	}
 	terms := []string{"Z"}
-	fn := sortNames(terms)
+	fn := index.sortNames(terms)
 	assert.Equal(t, 1, fn("Berta", "Alex"), "B is after A")
 	assert.Equal(t, -1, fn("Alex", "Berta"), "A is before B")
 	assert.Equal(t, 0, fn("Berta", "Berta"), "B and B are equal")
@@ -26,7 +26,7 @@ func TestSortNames(t *testing.T) {
 	assert.Equal(t, 1, fn("2015-06-14", "2023-09-26"), "lower numbers after higher numbers")
 
 	names := []string{"Berta", "Chris", "Alex"}
-	slices.SortFunc(names, sortNames(terms))
+	slices.SortFunc(names, index.sortNames(terms))
 	assert.True(t, slices.IsSorted(names), fmt.Sprintf("Sorted: %v", names))
 }
 
@@ -38,21 +38,21 @@ func TestPrependMatches(t *testing.T) {
 	index.Unlock()
 	r := []string{"Berta", "Chris"}         // does not prepend
 	u := []string{"Alex", "Berta", "Chris"} // does prepend
-	v, _ := prependQueryPage(r, "", "Alex")
+	v, _ := index.prependQueryPage(r, "", "Alex")
 	assert.Equal(t, u, v, "prepend q")
-	v, _ = prependQueryPage(r, "", "lex")
+	v, _ = index.prependQueryPage(r, "", "lex")
 	assert.Equal(t, r, v, "exact matches only")
-	v, _ = prependQueryPage(r, "", "#Alex")
+	v, _ = index.prependQueryPage(r, "", "#Alex")
 	assert.Equal(t, u, v, "prepend hashtag")
-	v, _ = prependQueryPage(r, "", "#Alex #Berta")
+	v, _ = index.prependQueryPage(r, "", "#Alex #Berta")
 	assert.Equal(t, r, v, "do not prepend two hashtags")
-	v, _ = prependQueryPage(r, "", "#alex")
+	v, _ = index.prependQueryPage(r, "", "#alex")
 	assert.Equal(t, r, v, "do not ignore case")
-	v, _ = prependQueryPage(u, "", "Alex")
+	v, _ = index.prependQueryPage(u, "", "Alex")
 	assert.Equal(t, u, v, "do not prepend q twice")
-	v, _ = prependQueryPage([]string{"Berta", "Alex", "Chris"}, "", "Alex")
+	v, _ = index.prependQueryPage([]string{"Berta", "Alex", "Chris"}, "", "Alex")
 	assert.Equal(t, u, v, "sort q to the front")
-	v, _ = prependQueryPage([]string{"Berta", "Chris", "Alex"}, "", "Alex")
+	v, _ = index.prependQueryPage([]string{"Berta", "Chris", "Alex"}, "", "Alex")
 	assert.Equal(t, u, v, "sort q to the front")
 }
 
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is how long the server waits for requests in progress when shutting down.
+const shutdownTimeout = time.Minute
+
+// notifySignals returns a channel receiving SIGHUP, SIGINT and SIGTERM.
+func notifySignals() chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	return c
+}
+
+// handleSignals waits for signals on the channel. SIGHUP reloads the templates and languages, see reload. SIGINT and
+// SIGTERM shut the server down: no new connections are accepted, the requests in progress get to finish (up to
+// shutdownTimeout), and the files waiting to be handled by the watcher are handled. Then handleSignals returns.
+func handleSignals(srv *http.Server, c chan os.Signal) {
+	defer signal.Stop(c)
+	for sig := range c {
+		if sig == syscall.SIGHUP {
+			reload()
+			continue
+		}
+		slog.Info("Shutting down", "signal", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		err := srv.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			slog.Error("Shutdown failed", "err", err)
+		}
+		for _, s := range hostSites.all() {
+			s.watches.flush()
+		}
+		slog.Info("Shutdown complete")
+		return
+	}
+}
+
+// reload rereads the configuration files and reloads the templates of all the sites and the languages. Use this after
+// changing a configuration file or after making changes the watcher cannot see, such as templates changed on a network
+// filesystem.
+func reload() {
+	slog.Info("Reloading")
+	for _, s := range hostSites.all() {
+		s.config.reset()
+		s.templates.reload()
+	}
+	scheduleLoadLanguages()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "Slow upload done")
+	})}
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		handleSignals(srv, c)
+		close(done)
+	}()
+	go srv.Serve(listener)
+	body := make(chan string)
+	go func() {
+		res, err := http.Get("http://" + listener.Addr().String() + "/")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		body <- string(b)
+	}()
+	<-started
+	c <- syscall.SIGTERM
+	// the request in progress is finished
+	assert.Equal(t, "Slow upload done", <-body)
+	<-done
+	// new connections are refused
+	_, err = http.Get("http://" + listener.Addr().String() + "/")
+	assert.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	t.Cleanup(reloadTemplates) // after the cleanup below
+	cleanup(t, "testdata/reload")
+	assert.NoError(t, os.MkdirAll("testdata/reload", 0755))
+	loadTemplates()
+	assert.NoError(t, os.WriteFile("testdata/reload/view.html", []byte("Reloaded {{.Title}}"), 0644))
+	srv := &http.Server{}
+	c := make(chan os.Signal, 1)
+	c <- syscall.SIGHUP
+	close(c)
+	handleSignals(srv, c)
+	templates.RLock()
+	_, ok := templates.template["testdata/reload/view.html"]
+	templates.RUnlock()
+	assert.True(t, ok)
+}
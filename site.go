@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// site is a wiki: a root directory with its pages, files and templates, together with the storage, the index, the
+// templates, the watches and the configuration that belong to it. Usually there is just one site, [defaultSite], in the working
+// directory. If the setting "hosts" is used, the hosts listed each get a site of their own; see [hostSites].
+type site struct {
+	// root is the root directory of the site. Page names and the filepaths used by the index, the templates and
+	// the watches are relative to it.
+	root string
+
+	// store is where the pages and files are kept. See [storage].
+	store storage
+
+	index     *indexStore
+	templates *templateStore
+	watches   *watchStore
+	config    *configStore
+}
+
+// defaultSite is the site in the working directory, using the global stores. It serves all requests for hosts that
+// don't have a site of their own and it is the site used by all the subcommands. Use [setRoot] to move it to a
+// different directory.
+var defaultSite = &site{root: ".", store: &fileStorage{root: "."}, index: &index, templates: &templates, watches: &watches,
+	config: &config}
+
+func init() {
+	index.site = defaultSite
+	templates.site = defaultSite
+	watches.site = defaultSite
+}
+
+// newSite returns a site for a root directory, with stores of its own. The configuration file is the one in the root
+// directory and the pages and files are kept in the root directory. Use [site.openStorage] to get the storage named
+// by the configuration. The pages are not indexed and the watches are not installed.
+func newSite(root string) *site {
+	s := &site{
+		root:      root,
+		store:     &fileStorage{root: root},
+		index:     &indexStore{},
+		templates: &templateStore{},
+		watches:   &watchStore{},
+		config:    &configStore{file: filepath.Join(root, configFile)},
+	}
+	s.index.reset()
+	s.index.site = s
+	s.templates.site = s
+	s.watches.site = s
+	s.watches.ignores = make(map[string]time.Time)
+	s.watches.files = make(map[string]time.Time)
+	return s
+}
+
+// join returns the filepath for a filepath relative to the root of the site.
+func (s *site) join(fp string) string {
+	return filepath.Join(s.root, fp)
+}
+
+// setting returns the value of a setting for the site. See [lookupSetting].
+func (s *site) setting(key string) string {
+	v, _ := s.config.lookup(key)
+	return v
+}
+
+// dirSetting returns the value of a setting for a directory of the site. See [dirSetting].
+func (s *site) dirSetting(key, dir string) string {
+	return s.config.dirLookup(key, dir)
+}
+
+// setRoot sets the root directory of the default site. If dir is empty, the environment variable ODDMU_ROOT is used.
+// If that is also empty, the working directory remains the root directory. The configuration file in the new root
+// directory is used unless ODDMU_CONFIG names a different file, and so is the storage it names. This must be called
+// before the pages are indexed and the templates are loaded.
+func setRoot(dir string) error {
+	if dir == "" {
+		dir = os.Getenv("ODDMU_ROOT")
+	}
+	if dir == "" {
+		dir = "."
+	}
+	dir = filepath.Clean(dir)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	defaultSite.root = dir
+	config.reset()
+	store, err := defaultSite.openStorage()
+	if err != nil {
+		return err
+	}
+	defaultSite.store = store
+	return nil
+}
+
+// siteStore controls access to the sites for the hosts named by the setting "hosts". Make sure to lock and unlock as
+// appropriate.
+type siteStore struct {
+	sync.RWMutex
+
+	// sites maps lower case host names without port to their sites. Hosts sharing a root directory share a site.
+	sites map[string]*site
+}
+
+var hostSites siteStore
+
+// load parses the setting "hosts" and creates the sites. The setting is a comma-separated list of host names and root
+// directories, separated by an equals sign, e.g. "example.org=/srv/example,www.example.org=/srv/example". The sites
+// created are returned; their pages are not indexed and their watches are not installed. The sites that existed
+// before are discarded.
+func (h *siteStore) load() []*site {
+	h.Lock()
+	defer h.Unlock()
+	h.sites = make(map[string]*site)
+	roots := make(map[string]*site)
+	sites := make([]*site, 0)
+	for _, entry := range strings.Split(setting("hosts"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, root, ok := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		root = filepath.Clean(strings.TrimSpace(root))
+		if !ok || host == "" || root == "." {
+			slog.Error("ODDMU_HOSTS entries must have the form host=directory", "entry", entry)
+			continue
+		}
+		s, ok := roots[root]
+		if !ok {
+			s = newSite(root)
+			store, err := s.openStorage()
+			if err != nil {
+				slog.Error("Opening storage failed", "dir", root, "err", err)
+				continue
+			}
+			s.store = store
+			roots[root] = s
+			sites = append(sites, s)
+		}
+		h.sites[host] = s
+	}
+	return sites
+}
+
+// all returns the default site and the sites for the hosts.
+func (h *siteStore) all() []*site {
+	h.RLock()
+	defer h.RUnlock()
+	sites := []*site{defaultSite}
+	for _, s := range h.sites {
+		if !slices.Contains(sites, s) {
+			sites = append(sites, s)
+		}
+	}
+	return sites
+}
+
+// lookup returns the site for a host name, with or without a port. Hosts without a site of their own get the default
+// site.
+func (h *siteStore) lookup(host string) *site {
+	name, _, err := net.SplitHostPort(host)
+	if err == nil {
+		host = name
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	h.RLock()
+	defer h.RUnlock()
+	s, ok := h.sites[host]
+	if !ok {
+		return defaultSite
+	}
+	return s
+}
+
+// siteKey is the key for the site in the context of a request.
+type siteKey struct{}
+
+// virtualHosts returns a handler that determines the site based on the Host header of the request and adds it to the
+// context of the request. See [siteOf].
+func virtualHosts(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := hostSites.lookup(r.Host)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), siteKey{}, s)))
+	})
+}
+
+// siteOf returns the site for a request. Without a site in the context of the request, this is the default site.
+func siteOf(r *http.Request) *site {
+	s, ok := r.Context().Value(siteKey{}).(*site)
+	if !ok {
+		return defaultSite
+	}
+	return s
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"github.com/google/subcommands"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useHosts creates the root directories of the hosts with copies of the templates, loads the sites and discards them
+// when the test is done.
+func useHosts(t *testing.T, hosts map[string]string) []*site {
+	s := []string{}
+	for host, root := range hosts {
+		assert.NoError(t, os.MkdirAll(root, 0755))
+		for _, fn := range []string{"view.html", "search.html"} {
+			b, err := os.ReadFile(fn)
+			assert.NoError(t, err)
+			assert.NoError(t, os.WriteFile(filepath.Join(root, fn), b, 0644))
+		}
+		s = append(s, host+"="+root)
+	}
+	t.Setenv("ODDMU_HOSTS", strings.Join(s, ","))
+	t.Cleanup(func() {
+		hostSites.Lock()
+		defer hostSites.Unlock()
+		hostSites.sites = nil
+	})
+	return hostSites.load()
+}
+
+// hostRequest sends a request for the host to the handler and returns the response.
+func hostRequest(h http.Handler, method, host, path string, values url.Values) *httptest.ResponseRecorder {
+	var r *http.Request
+	if method == http.MethodPost {
+		r = httptest.NewRequest(method, path, strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, path+"?"+values.Encode(), nil)
+	}
+	r.Host = host
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestSiteLookup(t *testing.T) {
+	cleanup(t, "testdata/hosts")
+	sites := useHosts(t, map[string]string{
+		"example.org":     "testdata/hosts/example",
+		"www.example.org": "testdata/hosts/example",
+		"campaign.org":    "testdata/hosts/campaign",
+	})
+	assert.Len(t, sites, 2)
+	assert.Len(t, hostSites.all(), 3)
+	assert.Equal(t, "testdata/hosts/example", hostSites.lookup("example.org").root)
+	assert.Equal(t, "testdata/hosts/example", hostSites.lookup("WWW.Example.org:8080").root)
+	assert.Same(t, hostSites.lookup("example.org"), hostSites.lookup("www.example.org"))
+	assert.Equal(t, "testdata/hosts/campaign", hostSites.lookup("campaign.org.").root)
+	assert.Same(t, defaultSite, hostSites.lookup("localhost:8080"))
+	assert.Same(t, defaultSite, siteOf(httptest.NewRequest(http.MethodGet, "/", nil)))
+}
+
+func TestSiteSeparation(t *testing.T) {
+	cleanup(t, "testdata/hosts")
+	sites := useHosts(t, map[string]string{
+		"example.org":  "testdata/hosts/example",
+		"campaign.org": "testdata/hosts/campaign",
+	})
+	for _, s := range sites {
+		_, err := s.index.load()
+		assert.NoError(t, err)
+	}
+	save := virtualHosts(makeHandler(saveHandler, true, http.MethodPost))
+	w := hostRequest(save, http.MethodPost, "example.org", "/save/secret",
+		url.Values{"body": {"# Secret\n\nThe bird sings #quietly"}})
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.FileExists(t, "testdata/hosts/example/secret.md")
+	assert.NoFileExists(t, "testdata/hosts/campaign/secret.md")
+	assert.NoFileExists(t, "secret.md")
+	// the page is only indexed by its own site
+	example := hostSites.lookup("example.org")
+	campaign := hostSites.lookup("campaign.org")
+	assert.Equal(t, "Secret", example.index.titles["secret"])
+	assert.NotContains(t, campaign.index.titles, "secret")
+	assert.NotContains(t, index.titles, "secret")
+	// view and search
+	view := virtualHosts(makeHandler(viewHandler, false, http.MethodGet))
+	w = hostRequest(view, http.MethodGet, "example.org", "/view/secret", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "The bird sings")
+	w = hostRequest(view, http.MethodGet, "campaign.org", "/view/secret", nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	search := virtualHosts(makeHandler(searchHandler, false, http.MethodGet))
+	w = hostRequest(search, http.MethodGet, "example.org", "/search/", url.Values{"q": {"#quietly"}})
+	assert.Contains(t, w.Body.String(), "secret")
+	w = hostRequest(search, http.MethodGet, "campaign.org", "/search/", url.Values{"q": {"#quietly"}})
+	assert.NotContains(t, w.Body.String(), "secret")
+}
+
+func TestSiteConfig(t *testing.T) {
+	cleanup(t, "testdata/hosts")
+	useHosts(t, map[string]string{"example.org": "testdata/hosts/example"})
+	os.Unsetenv("ODDMU_MAX_PAGE_SIZE")
+	assert.NoError(t, os.WriteFile("testdata/hosts/example/.oddmu.toml", []byte("max_page_size = 10\n"), 0644))
+	hostSites.lookup("example.org").config.reset()
+	save := virtualHosts(limit(makeHandler(saveHandler, true, http.MethodPost)))
+	w := hostRequest(save, http.MethodPost, "example.org", "/save/long", url.Values{"body": {"This is way too long."}})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "", setting("max_page_size"))
+}
+
+func TestSetRoot(t *testing.T) {
+	cleanup(t, "testdata/root")
+	assert.NoError(t, os.MkdirAll("testdata/root/notes", 0755))
+	assert.NoError(t, os.WriteFile("testdata/root/index.md", []byte("# Root\n\nThe sun rises."), 0644))
+	assert.NoError(t, os.WriteFile("testdata/root/notes/dawn.md", []byte("# Dawn\n\nThe sun rises."), 0644))
+	assert.NoError(t, os.WriteFile("testdata/root/.oddmu.toml", []byte("filter = \"^notes/\"\n"), 0644))
+	os.Unsetenv("ODDMU_CONFIG")
+	os.Unsetenv("ODDMU_FILTER")
+	assert.Error(t, setRoot("testdata/root/index.md"))
+	assert.NoError(t, setRoot("testdata/root"))
+	t.Cleanup(func() {
+		setRoot(".")
+		index.reset()
+		index.load()
+	})
+	index.reset()
+	_, err := index.load()
+	assert.NoError(t, err)
+	assert.Equal(t, "Dawn", index.titles["notes/dawn"])
+	assert.Equal(t, "Root", index.titles["index"])
+	assert.Equal(t, "^notes/", setting("filter"))
+	p, err := loadPage("notes/dawn")
+	assert.NoError(t, err)
+	assert.Contains(t, string(p.Body), "The sun rises")
+	// subcommands
+	b := new(bytes.Buffer)
+	assert.Equal(t, subcommands.ExitSuccess, listCli(b, "notes", nil))
+	assert.Equal(t, "dawn\tDawn\n", b.String())
+	b.Reset()
+	assert.Equal(t, subcommands.ExitSuccess, replaceCli(b, true, false, []string{"rises", "sets"}))
+	assert.Contains(t, b.String(), "notes/dawn.md")
+	s, err := os.ReadFile("testdata/root/notes/dawn.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "# Dawn\n\nThe sun sets.", string(s))
+	assert.FileExists(t, "testdata/root/notes/dawn.md~")
+	// the environment variable is used without option
+	t.Setenv("ODDMU_ROOT", "testdata/root/notes")
+	assert.NoError(t, setRoot(""))
+	assert.Equal(t, "testdata/root/notes", defaultSite.root)
+}
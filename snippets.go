@@ -1,7 +1,7 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"regexp"
 	"strings"
 )
@@ -15,7 +15,7 @@ func re(q string) (*regexp.Regexp, error) {
 	}
 	re, err := regexp.Compile(`(?i)(` + strings.Join(quoted, "|") + `)`)
 	if err != nil {
-		log.Printf("Cannot compile %s %v: %s", q, quoted, err)
+		slog.Warn("Cannot compile snippet regexp", "query", q, "quoted", quoted, "err", err)
 		return nil, err
 	}
 	return re, nil
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// bannedContentPage is the page with the regular expressions for content that may not be saved. Every line that isn't
+// empty and doesn't start with "#" is a regular expression.
+const bannedContentPage = "banned-content"
+
+// bannedHostsPage is the page with the IP numbers and networks that may not save pages. Every line that isn't empty
+// and doesn't start with "#" is an IP number like "192.0.2.1" or a network like "192.0.2.0/24".
+const bannedHostsPage = "banned-hosts"
+
+// isBanList reports whether a page or file name refers to one of the ban lists, the bannedContentPage or the
+// bannedHostsPage. Only administrators may change them; see [checkAdmin].
+func isBanList(name string) bool {
+	name = strings.TrimSuffix(storagePath(name), ".md")
+	return name == bannedContentPage || name == bannedHostsPage
+}
+
+// honeypotField is the name of a form field in the "edit.html", "add.html" and "preview.html" templates that humans
+// don't see and therefore leave empty.
+const honeypotField = "website"
+
+// spamCheck is a function that checks an edit before it is saved. It gets the request, the page with its new body and
+// the old body (nil if the page is new). If the edit is spam, an error is returned explaining why.
+type spamCheck func(r *http.Request, p *Page, old []byte) error
+
+// spamChecks are the checks run by checkSpam, in order. If you want to write your own checks, this is where you add
+// them.
+var spamChecks = []spamCheck{honeypotCheck, bannedHostsCheck, externalLinksCheck, bannedContentCheck}
+
+// errSpam is wrapped by all the errors returned by the spam checks.
+var errSpam = errors.New("this looks like spam")
+
+// checkSpam runs all the spamChecks and returns the first error. Rejections are logged, together with the IP number of
+// the client and the user, if logged in.
+func (p *Page) checkSpam(r *http.Request, old []byte) error {
+	for _, check := range spamChecks {
+		err := check(r, p, old)
+		if err != nil {
+			username, _, _ := r.BasicAuth()
+			slog.Warn("Spam rejected", "page", p.Name, "ip", clientIP(r), "user", username, "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// honeypotCheck rejects edits where the honeypot field has been filled in. Humans don't see it, but some bots fill in
+// all the fields.
+func honeypotCheck(r *http.Request, p *Page, old []byte) error {
+	if r.FormValue(honeypotField) != "" {
+		return fmt.Errorf("%w: the hidden field was filled in", errSpam)
+	}
+	return nil
+}
+
+// bannedHostsCheck rejects edits from IP numbers listed on the bannedHostsPage.
+func bannedHostsCheck(r *http.Request, p *Page, old []byte) error {
+	addr, err := netip.ParseAddr(clientIP(r))
+	if err != nil {
+		return nil
+	}
+	for _, line := range p.wiki().banList(bannedHostsPage) {
+		if strings.Contains(line, "/") {
+			prefix, err := netip.ParsePrefix(line)
+			if err != nil {
+				slog.Warn("Cannot parse", "line", line, "page", bannedHostsPage, "err", err)
+			} else if prefix.Contains(addr) {
+				return fmt.Errorf("%w: %s is banned", errSpam, addr)
+			}
+		} else {
+			a, err := netip.ParseAddr(line)
+			if err != nil {
+				slog.Warn("Cannot parse", "line", line, "page", bannedHostsPage, "err", err)
+			} else if a == addr {
+				return fmt.Errorf("%w: %s is banned", errSpam, addr)
+			}
+		}
+	}
+	return nil
+}
+
+// bannedContentCheck rejects edits where the page matches any of the regular expressions on the bannedContentPage.
+// The regular expressions are case-insensitive. The bannedContentPage itself is exempt, of course.
+func bannedContentCheck(r *http.Request, p *Page, old []byte) error {
+	if p.Name == bannedContentPage {
+		return nil
+	}
+	for _, line := range p.wiki().banList(bannedContentPage) {
+		re, err := regexp.Compile("(?i)" + line)
+		if err != nil {
+			slog.Warn("Cannot compile", "line", line, "page", bannedContentPage, "err", err)
+			continue
+		}
+		if re.Match(p.Body) {
+			return fmt.Errorf("%w: the page contains banned content", errSpam)
+		}
+	}
+	return nil
+}
+
+// externalLinksCheck rejects edits that add more external links than the environment variable ODDMU_MAX_LINKS allows.
+// Links that were already on the page don't count.
+func externalLinksCheck(r *http.Request, p *Page, old []byte) error {
+	s := p.wiki().setting("max_links")
+	if s == "" {
+		return nil
+	}
+	max, err := strconv.Atoi(s)
+	if err != nil {
+		slog.Warn("ODDMU_MAX_LINKS is not a number", "value", s, "err", err)
+		return nil
+	}
+	known := make(map[string]bool)
+	for _, link := range externalLinks(old) {
+		known[link] = true
+	}
+	n := 0
+	for _, link := range externalLinks(p.Body) {
+		if !known[link] {
+			known[link] = true
+			n++
+		}
+	}
+	if n > max {
+		return fmt.Errorf("%w: %d new external links but only %d are allowed", errSpam, n, max)
+	}
+	return nil
+}
+
+// externalLinks returns the destinations of all the links and images with an absolute URL.
+func externalLinks(body []byte) []string {
+	links := []string{}
+	if len(body) == 0 {
+		return links
+	}
+	parser, _ := wikiParser()
+	doc := markdown.Parse(body, parser)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if entering {
+			var dest []byte
+			switch v := node.(type) {
+			case *ast.Link:
+				dest = v.Destination
+			case *ast.Image:
+				dest = v.Destination
+			}
+			u, err := url.Parse(string(dest))
+			if err == nil && u.IsAbs() && u.Host != "" {
+				links = append(links, u.String())
+			}
+		}
+		return ast.GoToNext
+	})
+	return links
+}
+
+// banList returns the lines of a ban list page of the site that aren't empty and don't start with "#". If the page
+// doesn't exist, the list is empty.
+func (s *site) banList(name string) []string {
+	lines := []string{}
+	p, err := s.loadPage(name)
+	if err != nil {
+		return lines
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(p.Body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// spamPost posts the form to the handler and returns the status code.
+func spamPost(h http.HandlerFunc, path string, data url.Values, ip string) int {
+	r := httptest.NewRequest("POST", path, strings.NewReader(data.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w.Code
+}
+
+func TestSpamHoneypot(t *testing.T) {
+	cleanup(t, "testdata/spam-honeypot")
+	h := makeHandler(saveHandler, true, http.MethodPost)
+	assert.Equal(t, http.StatusForbidden, spamPost(h, "/save/testdata/spam-honeypot/haiku",
+		url.Values{"body": {"Buy now"}, "website": {"https://example.org/"}}, "192.0.2.1"))
+	assert.NoFileExists(t, "testdata/spam-honeypot/haiku.md")
+	assert.Equal(t, http.StatusFound, spamPost(h, "/save/testdata/spam-honeypot/haiku",
+		url.Values{"body": {"Wind in the trees"}, "website": {""}}, "192.0.2.1"))
+	assert.FileExists(t, "testdata/spam-honeypot/haiku.md")
+	// bots cannot delete pages, either
+	assert.Equal(t, http.StatusForbidden, spamPost(h, "/save/testdata/spam-honeypot/haiku",
+		url.Values{"body": {""}, "website": {"https://example.org/"}}, "192.0.2.1"))
+	assert.FileExists(t, "testdata/spam-honeypot/haiku.md")
+}
+
+func TestSpamLinks(t *testing.T) {
+	cleanup(t, "testdata/spam-links")
+	t.Setenv("ODDMU_MAX_LINKS", "1")
+	save := makeHandler(saveHandler, true, http.MethodPost)
+	add := makeHandler(appendHandler, true, http.MethodPost)
+	assert.Equal(t, http.StatusForbidden, spamPost(save, "/save/testdata/spam-links/links",
+		url.Values{"body": {"[a](https://example.org/a) [b](https://example.org/b) [c](c)"}}, "192.0.2.1"))
+	assert.Equal(t, http.StatusFound, spamPost(save, "/save/testdata/spam-links/links",
+		url.Values{"body": {"[a](https://example.org/a) [c](c)"}}, "192.0.2.1"))
+	// the old link doesn't count
+	assert.Equal(t, http.StatusFound, spamPost(save, "/save/testdata/spam-links/links",
+		url.Values{"body": {"[a](https://example.org/a) [b](https://example.org/b)"}}, "192.0.2.1"))
+	assert.Equal(t, http.StatusForbidden, spamPost(add, "/append/testdata/spam-links/links",
+		url.Values{"body": {"[d](https://example.org/d) [e](https://example.org/e)"}}, "192.0.2.1"))
+}
+
+func TestSpamBanLists(t *testing.T) {
+	cleanup(t, "testdata/spam-bans")
+	for _, name := range []string{bannedContentPage, bannedHostsPage} {
+		_, err := os.Stat(name + ".md")
+		assert.Error(t, err, "%s.md must not exist before running this test", name)
+	}
+	t.Cleanup(func() {
+		_ = os.Remove(bannedContentPage + ".md")
+		_ = os.Remove(bannedHostsPage + ".md")
+	})
+	assert.NoError(t, os.WriteFile(bannedContentPage+".md", []byte("# Banned content\n\ncasino\n[invalid\n"), 0644))
+	assert.NoError(t, os.WriteFile(bannedHostsPage+".md", []byte("# Banned hosts\n\n192.0.2.1\n198.51.100.0/24\n"), 0644))
+	h := makeHandler(saveHandler, true, http.MethodPost)
+	assert.Equal(t, http.StatusForbidden, spamPost(h, "/save/testdata/spam-bans/haiku",
+		url.Values{"body": {"Best CASINO online"}}, "203.0.113.1"))
+	assert.Equal(t, http.StatusForbidden, spamPost(h, "/save/testdata/spam-bans/haiku",
+		url.Values{"body": {"Wind in the trees"}}, "192.0.2.1"))
+	assert.Equal(t, http.StatusForbidden, spamPost(h, "/save/testdata/spam-bans/haiku",
+		url.Values{"body": {"Wind in the trees"}}, "198.51.100.7"))
+	assert.Equal(t, http.StatusFound, spamPost(h, "/save/testdata/spam-bans/haiku",
+		url.Values{"body": {"Wind in the trees"}}, "203.0.113.1"))
+	// banned hosts cannot delete pages, either
+	assert.Equal(t, http.StatusForbidden, spamPost(h, "/save/testdata/spam-bans/haiku",
+		url.Values{"body": {""}}, "192.0.2.1"))
+	assert.FileExists(t, "testdata/spam-bans/haiku.md")
+}
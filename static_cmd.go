@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/gomarkdown/markdown"
@@ -19,19 +20,39 @@ import (
 )
 
 type staticCmd struct {
-	jobs int
+	jobs     int
+	format   string
+	feeds    string
+	dryRun   bool
+	sitemap  bool
+	search   bool
+	notFound bool
 }
 
 func (cmd *staticCmd) SetFlags(f *flag.FlagSet) {
 	f.IntVar(&cmd.jobs, "jobs", 2, "how many jobs to use")
+	f.StringVar(&cmd.format, "format", "html", "the output format: html or gemini")
+	f.StringVar(&cmd.feeds, "feeds", "rss", "comma-separated list of feed formats to write: rss, atom, json")
+	f.BoolVar(&cmd.dryRun, "dry-run", false, "only list the files that would be updated or removed")
+	f.BoolVar(&cmd.sitemap, "sitemap", false, "write sitemap.xml (requires ODDMU_BASE_URL)")
+	f.BoolVar(&cmd.search, "search", false, "write search.json for the search form of static.html")
+	f.BoolVar(&cmd.notFound, "not-found", false, "write 404.html using the 404.html template")
 }
 
 func (*staticCmd) Name() string     { return "static" }
 func (*staticCmd) Synopsis() string { return "generate static HTML files for all pages" }
 func (*staticCmd) Usage() string {
-	return `static [-jobs n] <dir name>:
+	return `static [-jobs n] [-format html|gemini] [-feeds rss,atom,json]
+  [-dry-run] [-sitemap] [-search] [-not-found] <dir name>:
   Create static copies in the given directory. Per default, two jobs
-  are used to read and write files, but more can be assigned.
+  are used to read and write files, but more can be assigned. Per
+  default, pages are written as HTML, but they can be written as
+  gemtext for Gemini instead. Per default, only RSS feeds are
+  written, but Atom feeds and JSON feeds can be added. Only stale
+  files are regenerated and files whose source is gone are removed.
+  Use -dry-run to list these files without changing anything. A
+  sitemap, a search index and a page for files not found can be
+  added.
 `
 }
 
@@ -42,34 +63,124 @@ func (cmd *staticCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface
 		return subcommands.ExitFailure
 	}
 	dir := filepath.Clean(args[0])
-	return staticCli(".", dir, cmd.jobs, false)
+	return staticCli(".", dir, cmd, false)
 }
 
+// staticFormats maps the output formats to the extension used for pages.
+var staticFormats = map[string]string{
+	"html":   ".html",
+	"gemini": ".gmi",
+}
+
+// args are the tasks for a staticWorker: the source file, the target file and the relative URL of the root of the
+// static site as seen from the target file. See [staticRoot].
 type args struct {
-	source, target string
-	info           fs.FileInfo
+	source, target, root string
+	info                 fs.FileInfo
+}
+
+// staticPageData is what the "static.html" and "404.html" templates get: the page, the relative URL of the root of
+// the static site and whether there is a search index.
+type staticPageData struct {
+	*Page
+
+	// Root is the relative URL of the root of the static site, e.g. "../" for a page in a subdirectory. For pages in
+	// the root directory, this is the empty string.
+	Root string
+
+	// Search is set if the search index "search.json" is written.
+	Search bool
+}
+
+// staticResult is what a staticWorker reports for a source file: the manifest entry or an error. In a dry run, the
+// entry is nil.
+type staticResult struct {
+	source string
+	entry  *staticEntry
+	err    error
 }
 
 // staticCli generates a static site in the designated directory. The quiet flag is used to suppress output when running
-// tests. The source directory cannot be set from the command-line. The current directory (".") is assumed.
-func staticCli(source, target string, jobs int, quiet bool) subcommands.ExitStatus {
+// tests. The source directory cannot be set from the command-line. The current directory (".") is assumed. The command
+// provides the remaining options. The manifest in the target directory is used to skip files that are up to date and
+// to remove files whose source files are gone.
+func staticCli(source, target string, cmd *staticCmd, quiet bool) subcommands.ExitStatus {
+	format := cmd.format
+	if format == "" {
+		format = "html"
+	}
+	ext, ok := staticFormats[format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown format %s\n", format)
+		return subcommands.ExitFailure
+	}
+	if ext != ".html" && (cmd.sitemap || cmd.search || cmd.notFound) {
+		fmt.Fprintln(os.Stderr, "The sitemap, the search index and the page for files not found require the html format")
+		return subcommands.ExitFailure
+	}
+	feeds := []string{}
+	for _, format := range strings.Split(cmd.feeds, ",") {
+		format = strings.TrimSpace(format)
+		if format == "" {
+			continue
+		}
+		if _, ok := feedFormats[format]; !ok {
+			fmt.Fprintf(os.Stderr, "Unknown feed format %s\n", format)
+			return subcommands.ExitFailure
+		}
+		feeds = append(feeds, format)
+	}
+	if ext != ".html" {
+		// gemlogs instead of feeds
+		feeds = nil
+	}
+	var base *url.URL
+	if cmd.sitemap {
+		base = getFeedOptions().base
+		if base == nil {
+			fmt.Fprintln(os.Stderr, "The sitemap requires ODDMU_BASE_URL to be set")
+			return subcommands.ExitFailure
+		}
+	}
+	old, err := loadStaticManifest(target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return subcommands.ExitFailure
+	}
+	m := &staticManifest{Format: format, Feeds: strings.Join(feeds, ","), Search: cmd.search,
+		Files: make(map[string]*staticEntry)}
 	index.load()
 	index.RLock()
 	defer index.RUnlock()
 	loadLanguages()
 	loadTemplates()
 	tasks := make(chan args)
-	results := make(chan error)
+	results := make(chan staticResult)
 	done := make(chan bool)
-	stop := make(chan error)
-	for i := 0; i < jobs; i++ {
-		go staticWorker(tasks, results, done)
+	stop := make(chan error, 1) // the walk might be done already
+	seen := make(map[string]bool)
+	for i := 0; i < cmd.jobs; i++ {
+		go staticWorker(tasks, results, done, ext, feeds, cmd.search, cmd.dryRun)
+	}
+	go staticWalk(source, target, tasks, stop, old, m.Format, m.Feeds, m.Search, seen, cmd.dryRun)
+	go staticWatch(cmd.jobs, results, done)
+	n, err := staticProgressIndicator(results, stop, old, m, target, cmd.dryRun, quiet)
+	if err == nil {
+		m.merge(old, seen)
+		err = staticSiteFiles(source, target, cmd, ext, feeds, base, old, m, seen, quiet)
+	}
+	if err == nil {
+		err = staticPrune(old, m, seen, target, cmd.dryRun, quiet)
+	}
+	if err == nil && !cmd.dryRun {
+		err = m.save(target)
 	}
-	go staticWalk(source, target, tasks, stop)
-	go staticWatch(jobs, results, done)
-	n, err := staticProgressIndicator(results, stop, quiet)
 	if !quiet {
-		fmt.Printf("\r%d files processed\n", n)
+		if cmd.dryRun {
+			fmt.Printf("%d files to update\n", n)
+		} else {
+			fmt.Printf("\r%d files processed\n", n)
+		}
 	}
 	if err != nil {
 		fmt.Println(err)
@@ -78,13 +189,34 @@ func staticCli(source, target string, jobs int, quiet bool) subcommands.ExitStat
 	return subcommands.ExitSuccess
 }
 
-// staticWalk walks the source directory tree. Any directory it finds, it recreates in the target directory. Any file it
-// finds, it puts into the tasks channel for the staticWorker. When the directory walk is finished, the tasks channel is
-// closed. If there's an error on the stop channel, the walk returns that error.
-func staticWalk(source, target string, tasks chan (args), stop chan (error)) {
+// staticWalk walks the source directory tree. Any directory it finds, it recreates in the target directory. Any file
+// it finds that is stale according to the old manifest, it puts into the tasks channel for the staticWorker. All the
+// files found are recorded in the seen map. The format, the feeds and the search flag are used to determine whether
+// files are stale.
+// When the directory walk is finished, the tasks channel is closed. If
+// there's an error on the stop channel, the walk returns that error. In a dry run, no directories are created. The
+// source directory is relative to the root directory of the default site, the target directory is not.
+func staticWalk(source, target string, tasks chan (args), stop chan (error), old *staticManifest, format, feeds string, search bool, seen map[string]bool, dryRun bool) {
+	// avoid recursion if the target is inside the root directory
+	skip := target
+	root, err := filepath.Abs(defaultSite.root)
+	if err == nil {
+		abs, err := filepath.Abs(target)
+		if err == nil {
+			rel, err := filepath.Rel(root, abs)
+			if err == nil {
+				skip = rel
+			}
+		}
+	}
 	// The error returned here is what's in the stop channel but at the very end, a worker might return an error
 	// even though the walk is already done. This is why we cannot rely on the return value of the walk.
-	filepath.Walk(source, func(fp string, info fs.FileInfo, err error) error {
+	fs.WalkDir(defaultSite.store, storagePath(filepath.ToSlash(source)), func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fp := filepath.FromSlash(name)
+		info, err := d.Info()
 		if err != nil {
 			return err
 		}
@@ -95,13 +227,13 @@ func staticWalk(source, target string, tasks chan (args), stop chan (error)) {
 			// skip hidden directories and files
 			if fp != "." && strings.HasPrefix(filepath.Base(fp), ".") {
 				if info.IsDir() {
-					return filepath.SkipDir
+					return fs.SkipDir
 				} else {
 					return nil
 				}
 			}
 			// skip backup files, avoid recursion
-			if strings.HasSuffix(fp, "~") || strings.HasPrefix(fp, target) {
+			if strings.HasSuffix(fp, "~") || strings.HasPrefix(fp, skip) {
 				return nil
 			}
 			// determine the actual target: if source is a/ and target is b/ and path is a/file, then the
@@ -117,12 +249,19 @@ func staticWalk(source, target string, tasks chan (args), stop chan (error)) {
 			}
 			// recreate subdirectories
 			if info.IsDir() {
-				return os.Mkdir(actualTarget, 0755)
+				if dryRun {
+					return nil
+				}
+				err := os.Mkdir(actualTarget, 0755)
+				if errors.Is(err, fs.ErrExist) {
+					return nil
+				}
+				return err
 			}
-			// do the task if the target file doesn't exist or if the source file is newer
-			other, err := os.Stat(actualTarget)
-			if err != nil || info.ModTime().After(other.ModTime()) {
-				tasks <- args{source: fp, target: actualTarget, info: info}
+			// do the task if the file is stale
+			seen[fp] = true
+			if old.stale(fp, format, feeds, search) {
+				tasks <- args{source: fp, target: actualTarget, root: staticRoot(target, actualTarget), info: info}
 			}
 			return nil
 		}
@@ -132,36 +271,59 @@ func staticWalk(source, target string, tasks chan (args), stop chan (error)) {
 
 // staticWatch counts the values coming out of the done channel. When the count matches the number of jobs started, we
 // know that all the tasks have been processed and the results channel is closed.
-func staticWatch(jobs int, results chan (error), done chan (bool)) {
+func staticWatch(jobs int, results chan (staticResult), done chan (bool)) {
 	for i := 0; i < jobs; i++ {
 		<-done
 	}
 	close(results)
 }
 
-// staticWorker takes arguments off the tasks channel (the file to process) and put results in the results channel (any
-// errors encountered); when they're done they send true on the done channel.
-func staticWorker(tasks chan (args), results chan (error), done chan (bool)) {
+// staticWorker takes arguments off the tasks channel (the file to process) and put results in the results channel (the
+// manifest entry or any errors encountered); when they're done they send true on the done channel. The extension
+// determines the output format for pages. The feeds are the feed formats to write. If search is set, pages get the
+// search form. In a dry run, the files are not processed.
+func staticWorker(tasks chan (args), results chan (staticResult), done chan (bool), ext string, feeds []string, search, dryRun bool) {
 	task, ok := <-tasks
 	for ok {
-		results <- staticFile(task.source, task.target, task.info)
+		if dryRun {
+			results <- staticResult{source: task.source}
+		} else {
+			e, err := staticFile(task, ext, feeds, search)
+			results <- staticResult{source: task.source, entry: e, err: err}
+		}
 		task, ok = <-tasks
 	}
 	done <- true
 }
 
 // staticProgressIndicator watches the results channel and does a countdown. If the result channel reports an error,
-// that is put into the stop channel so that staticWalk stops adding to the tasks channel.
-func staticProgressIndicator(results chan (error), stop chan (error), quiet bool) (int, error) {
+// that is put into the stop channel so that staticWalk stops adding to the tasks channel. The entries are added to the
+// new manifest and outputs the old entries had but the new entries don't are removed. In a dry run, the source files
+// are listed instead.
+func staticProgressIndicator(results chan (staticResult), stop chan (error), old, m *staticManifest, target string, dryRun, quiet bool) (int, error) {
 	n := 0
 	t := time.Now()
 	var err error
 	for result := range results {
-		if result != nil {
-			err := result
-			// this stops the walker from adding more tasks
-			stop <- err
+		if result.err != nil {
+			// only the first error is reported; it stops the walker from adding more tasks
+			if err == nil {
+				err = result.err
+				stop <- err
+			}
+		} else if dryRun {
+			n++
+			if !quiet {
+				fmt.Printf("update %s\n", result.source)
+			}
 		} else {
+			m.Files[result.source] = result.entry
+			for _, fp := range orphans(old.Files[result.source], result.entry) {
+				err := prune(fp, target)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Cannot remove %s: %s\n", fp, err)
+				}
+			}
 			n++
 			if !quiet && n%13 == 0 {
 				if time.Since(t) > time.Second {
@@ -174,26 +336,81 @@ func staticProgressIndicator(results chan (error), stop chan (error), quiet bool
 	return n, err
 }
 
+// staticPrune removes the outputs of all the source files in the old manifest that weren't seen during the walk,
+// unless they are outputs in the new manifest. Site files that were generated again lose the outputs they no longer
+// have. In a dry run, the outputs are listed instead.
+func staticPrune(old, m *staticManifest, seen map[string]bool, target string, dryRun, quiet bool) error {
+	outputs := make(map[string]bool)
+	for _, e := range m.Files {
+		for _, fp := range e.Outputs {
+			outputs[fp] = true
+		}
+	}
+	n := 0
+	for source, e := range old.Files {
+		// site files are generated every time but their outputs may change, e.g. with the feed formats
+		if seen[source] && !strings.HasPrefix(source, ":") {
+			continue
+		}
+		for _, fp := range orphans(e, m.Files[source]) {
+			if outputs[fp] {
+				continue
+			}
+			n++
+			if dryRun {
+				if !quiet {
+					fmt.Printf("remove %s\n", fp)
+				}
+				continue
+			}
+			err := prune(fp, target)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !quiet && !dryRun && n > 0 {
+		fmt.Printf("%d files removed\n", n)
+	}
+	return nil
+}
+
 // staticFile is used to walk the file trees and do the right thing for the destination directory: create
-// subdirectories, link files, render HTML files.
-func staticFile(source, target string, info fs.FileInfo) error {
+// subdirectories, link files, render HTML files and feeds in the given formats. If the extension is not ".html", pages
+// are rendered as gemtext instead, and no feeds are written. If search is set, HTML pages get the search form. The
+// manifest entry for the source file is returned.
+func staticFile(task args, ext string, feeds []string, search bool) (*staticEntry, error) {
+	source, target := task.source, task.target
+	e := newStaticEntry(source)
 	// render pages
+	if strings.HasSuffix(source, ".md") && ext == ".gmi" {
+		return e, staticGemtext(source[:len(source)-3], target[:len(target)-3]+ext, task.root, e)
+	}
 	if strings.HasSuffix(source, ".md") {
-		p, err := staticPage(source[:len(source)-3], target[:len(target)-3]+".html")
+		p, err := staticPage(source[:len(source)-3], target[:len(target)-3]+".html", task.root, search, e)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		return staticFeed(source[:len(source)-3], target[:len(target)-3]+".rss", p, info.ModTime())
+		err = staticFeed(source[:len(source)-3], target[:len(target)-3], p, task.info.ModTime(), feeds, e)
+		return e, err
 	}
 	// remaining files are linked unless this is a template
 	if slices.Contains(templateFiles, filepath.Base(source)) {
-		return nil
+		return e, nil
 	}
-	return os.Link(source, target)
+	// an existing link might be to an old version of the file
+	err := os.Remove(target)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	e.Outputs = append(e.Outputs, target)
+	return e, os.Link(defaultSite.join(source), target)
 }
 
-// staticPage takes the filename of a page (ending in ".md") and generates a static HTML page.
-func staticPage(source, target string) (*Page, error) {
+// staticPage takes the filename of a page (without the ".md" suffix) and generates a static HTML page. The root is the
+// relative URL of the root of the static site. If search is set, the page gets the search form. The output and its
+// dependencies are added to the manifest entry.
+func staticPage(source, target, root string, search bool, e *staticEntry) (*Page, error) {
 	p, err := loadPage(filepath.ToSlash(source))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot load %s: %s\n", source, err)
@@ -203,37 +420,105 @@ func staticPage(source, target string) (*Page, error) {
 	// instead of p.renderHtml() we do it all ourselves, appending ".html" to all the local links
 	parser, hashtags := wikiParser()
 	doc := markdown.Parse(p.Body, parser)
-	ast.WalkFunc(doc, staticLinks)
+	ast.WalkFunc(doc, staticLinks(filepath.Dir(source), root, ".html", e))
 	opts := html.RendererOptions{
 		// sync with wikiRenderer
 		Flags: html.CommonFlags & ^html.SmartypantsFractions | html.LazyLoadImages,
 	}
 	renderer := html.NewRenderer(opts)
 	maybeUnsafeHTML := markdown.Render(doc, renderer)
-	p.Html = unsafeBytes(maybeUnsafeHTML)
+	p.Html = p.sanitize(maybeUnsafeHTML)
 	p.Hashtags = *hashtags
-	return p, write(p, target, "", "static.html")
+	e.depend("static.html")
+	e.Outputs = append(e.Outputs, target)
+	return p, write(&staticPageData{Page: p, Root: root, Search: search}, target, "", "static.html")
 }
 
-// staticFeed writes a .rss file for a page, but only if it's an index page or a page that might be used as a hashtag
-func staticFeed(source, target string, p *Page, ti time.Time) error {
-	// render feed, maybe
+// staticGemtext takes the filename of a page (without the ".md" suffix) and generates a static gemtext page. Changes,
+// index and hashtag pages are gemlogs. The root is the relative URL of the root of the static site. The output and
+// its dependencies are added to the manifest entry.
+func staticGemtext(source, target, root string, e *staticEntry) error {
+	p, err := loadPage(filepath.ToSlash(source))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot load %s: %s\n", source, err)
+		return err
+	}
+	parser, _ := wikiParser()
+	doc := markdown.Parse(p.Body, parser)
+	ast.WalkFunc(doc, staticLinks(filepath.Dir(source), root, ".gmi", e))
+	e.Feed = staticFeedCandidate(source)
+	e.Outputs = append(e.Outputs, target)
+	err = os.WriteFile(target, []byte(gemtext(doc, isGemlog(p.Name))), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write %s: %s\n", target, err)
+	}
+	return err
+}
+
+// staticFeedCandidate reports whether a page (without the ".md" suffix) gets a feed: index pages and pages that might
+// be used as a hashtag.
+func staticFeedCandidate(source string) bool {
 	base := filepath.Base(source)
 	_, ok := index.token[strings.ToLower(base)]
-	if base == "index" || ok {
-		f := feed(p, ti, 0, 10)
-		if len(f.Items) > 0 {
-			return write(f, target, `<?xml version="1.0" encoding="UTF-8"?>`, "feed.html")
+	return base == "index" || ok
+}
+
+// staticFeed writes feed files for a page, but only if it's an index page or a page that might be used as a hashtag.
+// The target has no extension: each format determines its own extension (".rss", ".atom" or ".json"). The outputs and
+// their dependencies are added to the manifest entry.
+func staticFeed(source, target string, p *Page, ti time.Time, feeds []string, e *staticEntry) error {
+	// render feed, maybe
+	if staticFeedCandidate(source) {
+		e.Feed = true
+		f := feed(p, ti, 0, 10, getFeedOptions())
+		for _, fp := range f.sources {
+			e.depend(fp)
+		}
+		if len(f.Items) == 0 {
+			return nil
+		}
+		for _, format := range feeds {
+			var err error
+			fp := target + "." + format
+			if format == "json" {
+				err = writeJson(f, fp)
+			} else {
+				e.depend(feedFormats[format])
+				err = write(f, fp, `<?xml version="1.0" encoding="UTF-8"?>`, feedFormats[format])
+			}
+			if err != nil {
+				return err
+			}
+			e.Outputs = append(e.Outputs, fp)
 		}
 	}
 	return nil
 }
 
-// staticLinks checks a node and if it is a link to a local page, it appends ".html" to the link destination.
-func staticLinks(node ast.Node, entering bool) ast.WalkStatus {
+// staticLinks returns a function that checks a node and if it is a link to a local page, it appends the extension
+// (".html" or ".gmi") to the link destination. Links are relative to the directory of the page. Since the result
+// depends on whether the page exists, the page is added to the dependencies of the manifest entry. The root is the
+// relative URL of the root of the static site, used for the links to tag pages.
+func staticLinks(dir, root, ext string, e *staticEntry) ast.NodeVisitorFunc {
+	return func(node ast.Node, entering bool) ast.WalkStatus {
+		return staticLink(node, entering, dir, root, ext, e)
+	}
+}
+
+// staticLink checks a node and if it is a link to a local page, it appends the extension to the link destination. If
+// it is a hashtag, it links to the tag page instead.
+func staticLink(node ast.Node, entering bool, dir, root, ext string, e *staticEntry) ast.WalkStatus {
 	if entering {
 		switch v := node.(type) {
 		case *ast.Link:
+			// hashtags link to the tag pages since there is no search
+			if slices.Contains(v.AdditionalAttributes, `class="tag"`) {
+				tag, err := url.QueryUnescape(strings.TrimPrefix(string(v.Destination), "/search/?q=%23"))
+				if err == nil {
+					v.Destination = []byte(staticTagUrl(root, tag, ext))
+				}
+				return ast.GoToNext
+			}
 			// not an absolute URL, not a full URL, not a mailto: URI
 			if !bytes.HasPrefix(v.Destination, []byte("/")) &&
 				!bytes.Contains(v.Destination, []byte("://")) &&
@@ -243,17 +528,29 @@ func staticLinks(node ast.Node, entering bool) ast.WalkStatus {
 				if err != nil {
 					return ast.GoToNext
 				}
-				_, err = os.Stat(fn + ".md")
+				fp := filepath.Join(dir, filepath.FromSlash(fn)) + ".md"
+				e.depend(fp)
+				_, err = defaultSite.store.stat(filepath.ToSlash(fp))
 				if err != nil {
 					return ast.GoToNext
 				}
-				v.Destination = append(v.Destination, []byte(".html")...)
+				v.Destination = append(v.Destination, []byte(ext)...)
 			}
 		}
 	}
 	return ast.GoToNext
 }
 
+// staticRoot returns the relative URL of the root of the static site as seen from a file in the target directory:
+// the empty string for files in the target directory itself, "../" for files in a subdirectory, and so on.
+func staticRoot(target, fp string) string {
+	rel, err := filepath.Rel(target, filepath.Dir(fp))
+	if err != nil || rel == "." {
+		return ""
+	}
+	return strings.Repeat("../", strings.Count(filepath.ToSlash(rel), "/")+1)
+}
+
 // write a page or feed with an appropriate template to a specific destination, overwriting it.
 func write(data any, fp, prefix, templateFile string) error {
 	file, err := os.Create(fp)
@@ -268,10 +565,32 @@ func write(data any, fp, prefix, templateFile string) error {
 	}
 	templates.RLock()
 	defer templates.RUnlock()
-	err = templates.template[templateFile].Execute(file, data)
+	t := templates.template[templateFile]
+	if t == nil {
+		err = fmt.Errorf("template not found: %s", templateFile)
+		fmt.Fprintf(os.Stderr, "Cannot write %s: %s\n", fp, err)
+		return err
+	}
+	err = t.Execute(file, data)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot execute %s template for %s: %s\n", templateFile, fp, err)
 		return err
 	}
 	return nil
 }
+
+// writeJson writes a feed as a JSON Feed to a specific destination, overwriting it.
+func writeJson(f *Feed, fp string) error {
+	file, err := os.Create(fp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create %s: %s\n", fp, err)
+		return err
+	}
+	defer file.Close()
+	err = writeJsonFeed(file, f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write %s: %s\n", fp, err)
+		return err
+	}
+	return nil
+}
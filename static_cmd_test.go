@@ -5,11 +5,12 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestStaticCmd(t *testing.T) {
 	cleanup(t, "testdata/static")
-	s := staticCli(".", "testdata/static", 2, true)
+	s := staticCli(".", "testdata/static", &staticCmd{jobs: 2, feeds: "rss", notFound: true}, true)
 	assert.Equal(t, subcommands.ExitSuccess, s)
 	// pages
 	assert.FileExists(t, "testdata/static/index.html")
@@ -17,6 +18,10 @@ func TestStaticCmd(t *testing.T) {
 	// regular files
 	assert.FileExists(t, "testdata/static/static_cmd.go")
 	assert.FileExists(t, "testdata/static/static_cmd_test.go")
+	// the 404.html template is not a source file
+	b, err := os.ReadFile("testdata/static/404.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "<title>Page Not Found</title>")
 }
 
 func TestFeedStaticCmd(t *testing.T) {
@@ -34,11 +39,245 @@ And the cars so loud
 `)}
 	h.save()
 	h.notify()
-	s := staticCli("testdata/static-feed", "testdata/static-feed-out", 2, true)
+	s := staticCli("testdata/static-feed", "testdata/static-feed-out", &staticCmd{jobs: 2, feeds: "rss,atom,json"}, true)
 	assert.Equal(t, subcommands.ExitSuccess, s)
 	assert.FileExists(t, "testdata/static-feed-out/2024-03-07-poem.html")
 	assert.FileExists(t, "testdata/static-feed-out/Haiku.html")
 	b, err := os.ReadFile("testdata/static-feed-out/Haiku.rss")
 	assert.NoError(t, err)
 	assert.Contains(t, string(b), "<channel>")
+	b, err = os.ReadFile("testdata/static-feed-out/Haiku.atom")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "<entry>")
+	assert.Contains(t, string(b), `<category term="Haiku"/>`)
+	b, err = os.ReadFile("testdata/static-feed-out/Haiku.json")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"version": "https://jsonfeed.org/version/1.1"`)
+	assert.Contains(t, string(b), `"title": "Rain"`)
+}
+
+func TestIncrementalStaticCmd(t *testing.T) {
+	cleanup(t, "testdata/static-incremental")
+	cleanup(t, "testdata/static-incremental-out")
+	p := &Page{Name: "testdata/static-incremental/cactus", Body: []byte("# Cactus\nSee the [dragon](dragon).\n")}
+	p.save()
+	p = &Page{Name: "testdata/static-incremental/fern", Body: []byte("# Fern\nCurled fronds.\n")}
+	p.save()
+	cmd := &staticCmd{jobs: 2, feeds: "rss"}
+	s := staticCli("testdata/static-incremental", "testdata/static-incremental-out", cmd, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.FileExists(t, "testdata/static-incremental-out/.oddmu-static.json")
+	b, err := os.ReadFile("testdata/static-incremental-out/cactus.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `<a href="dragon">`)
+	// files that are up to date are not written again
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes("testdata/static-incremental-out/fern.html", old, old))
+	s = staticCli("testdata/static-incremental", "testdata/static-incremental-out", cmd, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	fi, err := os.Stat("testdata/static-incremental-out/fern.html")
+	assert.NoError(t, err)
+	assert.True(t, fi.ModTime().Equal(old))
+	// creating a linked page changes the link; deleting a page removes its output
+	p = &Page{Name: "testdata/static-incremental/dragon", Body: []byte("# Dragon\nUp to the sky.\n")}
+	p.save()
+	assert.NoError(t, os.Remove("testdata/static-incremental/fern.md"))
+	// a dry run changes nothing
+	s = staticCli("testdata/static-incremental", "testdata/static-incremental-out", &staticCmd{jobs: 2, feeds: "rss", dryRun: true}, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.FileExists(t, "testdata/static-incremental-out/fern.html")
+	assert.NoFileExists(t, "testdata/static-incremental-out/dragon.html")
+	s = staticCli("testdata/static-incremental", "testdata/static-incremental-out", cmd, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.NoFileExists(t, "testdata/static-incremental-out/fern.html")
+	assert.FileExists(t, "testdata/static-incremental-out/dragon.html")
+	b, err = os.ReadFile("testdata/static-incremental-out/cactus.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `<a href="dragon.html">`)
+	// the manifest uses relative file names
+	b, err = os.ReadFile("testdata/static-incremental-out/.oddmu-static.json")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"outputs":["cactus.html"]`)
+	assert.Contains(t, string(b), `"testdata/static-incremental/cactus.md":`)
+	assert.NotContains(t, string(b), "static-incremental-out")
+	// a manifest pointing outside the target directory is not used and nothing is removed
+	assert.NoError(t, os.WriteFile("testdata/static-incremental-out/.oddmu-static.json",
+		[]byte(`{"files":{"testdata/static-incremental/gone.md":{"outputs":["../static-incremental/cactus.md"]}}}`), 0644))
+	s = staticCli("testdata/static-incremental", "testdata/static-incremental-out", cmd, true)
+	assert.Equal(t, subcommands.ExitFailure, s)
+	assert.FileExists(t, "testdata/static-incremental/cactus.md")
+	assert.Error(t, prune("testdata/static-incremental/cactus.md", "testdata/static-incremental-out"))
+	assert.FileExists(t, "testdata/static-incremental/cactus.md")
+}
+
+func TestFailedStaticCmd(t *testing.T) {
+	cleanup(t, "testdata/static-failed")
+	cleanup(t, "testdata/static-failed-out")
+	p := &Page{Name: "testdata/static-failed/cactus", Body: []byte("# Cactus\nGreen head and white hair\n")}
+	p.save()
+	p = &Page{Name: "testdata/static-failed/fern", Body: []byte("# Fern\nCurled fronds.\n")}
+	p.save()
+	cmd := &staticCmd{jobs: 2, feeds: "rss"}
+	s := staticCli("testdata/static-failed", "testdata/static-failed-out", cmd, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	manifest, err := os.ReadFile("testdata/static-failed-out/.oddmu-static.json")
+	assert.NoError(t, err)
+	// the fern page cannot be written because a directory is in the way
+	p = &Page{Name: "testdata/static-failed/fern", Body: []byte("# Fern\nUnfurling fronds.\n")}
+	p.save()
+	assert.NoError(t, os.Remove("testdata/static-failed-out/fern.html"))
+	assert.NoError(t, os.MkdirAll("testdata/static-failed-out/fern.html/blocked", 0755))
+	assert.NoError(t, os.Remove("testdata/static-failed/cactus.md"))
+	s = staticCli("testdata/static-failed", "testdata/static-failed-out", cmd, true)
+	assert.Equal(t, subcommands.ExitFailure, s)
+	// the previous manifest is kept and nothing is removed
+	b, err := os.ReadFile("testdata/static-failed-out/.oddmu-static.json")
+	assert.NoError(t, err)
+	assert.Equal(t, string(manifest), string(b))
+	assert.FileExists(t, "testdata/static-failed-out/cactus.html")
+}
+
+func TestSiteFilesStaticCmd(t *testing.T) {
+	cleanup(t, "testdata/static-site")
+	cleanup(t, "testdata/static-site-out")
+	t.Setenv("ODDMU_BASE_URL", "https://example.org/")
+	p := &Page{Name: "testdata/static-site/cactus", Body: []byte("# Cactus\nGreen head and white hair\n\n#Succulent\n")}
+	p.save()
+	cmd := &staticCmd{jobs: 2, feeds: "rss", sitemap: true, search: true, notFound: true}
+	s := staticCli("testdata/static-site", "testdata/static-site-out", cmd, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	b, err := os.ReadFile("testdata/static-site-out/sitemap.xml")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "<loc>https://example.org/cactus.html</loc>")
+	assert.Contains(t, string(b), "<lastmod>")
+	b, err = os.ReadFile("testdata/static-site-out/search.json")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `{"url":"cactus.html","title":"Cactus","tags":["succulent"],"text":"Green head and white hair #Succulent"}`)
+	b, err = os.ReadFile("testdata/static-site-out/404.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "<title>Page Not Found</title>")
+	b, err = os.ReadFile("testdata/static-site-out/cactus.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `const root = "";`)
+	assert.Contains(t, string(b), `fetch(root + "search.json")`)
+	// files no longer requested are removed
+	s = staticCli("testdata/static-site", "testdata/static-site-out", &staticCmd{jobs: 2, feeds: "rss", search: true}, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.NoFileExists(t, "testdata/static-site-out/sitemap.xml")
+	assert.NoFileExists(t, "testdata/static-site-out/404.html")
+	assert.FileExists(t, "testdata/static-site-out/search.json")
+	// without the search index, pages have no search form
+	s = staticCli("testdata/static-site", "testdata/static-site-out", &staticCmd{jobs: 2, feeds: "rss"}, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.NoFileExists(t, "testdata/static-site-out/search.json")
+	b, err = os.ReadFile("testdata/static-site-out/cactus.html")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "search.json")
+	assert.NotContains(t, string(b), `<form id="search"`)
+	// the sitemap requires the base URL
+	t.Setenv("ODDMU_BASE_URL", "")
+	s = staticCli("testdata/static-site", "testdata/static-site-out", cmd, true)
+	assert.Equal(t, subcommands.ExitFailure, s)
+}
+
+func TestTagAndListStaticCmd(t *testing.T) {
+	cleanup(t, "testdata/static-tags")
+	cleanup(t, "testdata/static-tags-out")
+	p := &Page{Name: "testdata/static-tags/index", Body: []byte("# Garden\n")}
+	p.save()
+	p = &Page{Name: "testdata/static-tags/plants/cactus", Body: []byte("# Cactus\nGreen head and white hair\n\n#Succulent_Plant\n")}
+	p.save()
+	p = &Page{Name: "testdata/static-tags/plants/aloe", Body: []byte("# Aloe\nThick leaves\n\n#Succulent_Plant\n")}
+	p.save()
+	s := staticCli("testdata/static-tags", "testdata/static-tags-out", &staticCmd{jobs: 2, feeds: "rss"}, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	b, err := os.ReadFile("testdata/static-tags-out/plants/cactus.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `<a class="tag" href="../tag/succulent_plant.html">#Succulent Plant</a>`)
+	assert.Contains(t, string(b), `<a href="../index.html">Home</a>`)
+	b, err = os.ReadFile("testdata/static-tags-out/tag/succulent_plant.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `<a href="../plants/cactus.html">Cactus</a>`)
+	assert.Contains(t, string(b), `<a href="../plants/aloe.html">Aloe</a>`)
+	assert.Contains(t, string(b), `<a href="succulent_plant.rss">RSS</a>`)
+	b, err = os.ReadFile("testdata/static-tags-out/tag/succulent_plant.rss")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "<title>Cactus</title>")
+	// the top directory has an index page but the subdirectory doesn't
+	b, err = os.ReadFile("testdata/static-tags-out/index.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "<title>Garden</title>")
+	b, err = os.ReadFile("testdata/static-tags-out/plants/index.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `<a href="aloe.html">aloe.md</a>`)
+	assert.Contains(t, string(b), `<a href="../">Up</a>`)
+	// tag pages and list pages are only written again if the pages they list changed
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes("testdata/static-tags-out/tag/succulent_plant.html", old, old))
+	assert.NoError(t, os.Chtimes("testdata/static-tags-out/plants/index.html", old, old))
+	s = staticCli("testdata/static-tags", "testdata/static-tags-out", &staticCmd{jobs: 2, feeds: "rss"}, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	for _, fp := range []string{"testdata/static-tags-out/tag/succulent_plant.html", "testdata/static-tags-out/plants/index.html"} {
+		fi, err := os.Stat(fp)
+		assert.NoError(t, err)
+		assert.True(t, fi.ModTime().Equal(old), fp)
+	}
+	p = &Page{Name: "testdata/static-tags/plants/aloe", Body: []byte("# Aloe Vera\nThick leaves\n\n#Succulent_Plant\n")}
+	p.save()
+	s = staticCli("testdata/static-tags", "testdata/static-tags-out", &staticCmd{jobs: 2, feeds: "rss"}, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	b, err = os.ReadFile("testdata/static-tags-out/tag/succulent_plant.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `<a href="../plants/aloe.html">Aloe Vera</a>`)
+	b, err = os.ReadFile("testdata/static-tags-out/plants/index.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "Aloe Vera")
+	// once the subdirectory has an index page, the list page is replaced
+	p = &Page{Name: "testdata/static-tags/plants/index", Body: []byte("# Plants\n")}
+	p.save()
+	s = staticCli("testdata/static-tags", "testdata/static-tags-out", &staticCmd{jobs: 2, feeds: "rss"}, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	b, err = os.ReadFile("testdata/static-tags-out/plants/index.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "<title>Plants</title>")
+}
+
+func TestGeminiStaticCmd(t *testing.T) {
+	cleanup(t, "testdata/static-gemini")
+	cleanup(t, "testdata/static-gemini-out")
+	p := &Page{Name: "testdata/static-gemini/Haiku", Body: []byte("# Haiku\n")}
+	p.save()
+	h := &Page{Name: "testdata/static-gemini/2024-03-07-poem",
+		Body: []byte(`# Rain
+I cannot hear you
+The birds outside are singing
+And the cars so loud
+
+#Haiku
+`)}
+	h.save()
+	h.notify()
+	assert.NoError(t, os.WriteFile("testdata/static-gemini/rain.jpg", []byte("drops"), 0644))
+	s := staticCli("testdata/static-gemini", "testdata/static-gemini-out", &staticCmd{jobs: 2, format: "gemini", feeds: "rss"}, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.FileExists(t, "testdata/static-gemini-out/rain.jpg")
+	assert.NoFileExists(t, "testdata/static-gemini-out/Haiku.html")
+	assert.NoFileExists(t, "testdata/static-gemini-out/Haiku.rss")
+	b, err := os.ReadFile("testdata/static-gemini-out/2024-03-07-poem.gmi")
+	assert.NoError(t, err)
+	assert.Equal(t, "# Rain\n\nI cannot hear you The birds outside are singing And the cars so loud\n\n"+
+		"=> tag/haiku.gmi #Haiku\n", string(b))
+	// hashtag pages and tag pages are gemlogs
+	b, err = os.ReadFile("testdata/static-gemini-out/Haiku.gmi")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "=> 2024-03-07-poem.gmi 2024-03-07 Rain\n")
+	b, err = os.ReadFile("testdata/static-gemini-out/tag/haiku.gmi")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "=> ../2024-03-07-poem.gmi 2024-03-07 Rain\n")
+	// switching back to HTML regenerates the pages and removes the gemtext files
+	s = staticCli("testdata/static-gemini", "testdata/static-gemini-out", &staticCmd{jobs: 2, feeds: "rss"}, true)
+	assert.Equal(t, subcommands.ExitSuccess, s)
+	assert.FileExists(t, "testdata/static-gemini-out/Haiku.html")
+	assert.NoFileExists(t, "testdata/static-gemini-out/Haiku.gmi")
+	assert.NoFileExists(t, "testdata/static-gemini-out/tag/haiku.gmi")
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// staticManifestName is the name of the manifest file in the target directory of the static subcommand.
+const staticManifestName = ".oddmu-static.json"
+
+// staticManifest records the files generated by the static subcommand. This allows the next run to regenerate only
+// the files that are stale and to delete the files whose source files have been deleted.
+type staticManifest struct {
+	// Format is the output format used for pages. If it changes, all the pages are regenerated.
+	Format string `json:"format,omitempty"`
+
+	// Feeds are the feed formats used, comma-separated. If they change, all the pages are regenerated.
+	Feeds string `json:"feeds"`
+
+	// Search records whether the search index was written. If it changes, all the pages are regenerated since the
+	// search form is only part of the pages if there is a search index.
+	Search bool `json:"search,omitempty"`
+
+	// Files maps the source files to their entries.
+	Files map[string]*staticEntry `json:"files"`
+}
+
+// staticEntry records what a source file produced and what the result depends on.
+type staticEntry struct {
+	// Outputs are the files in the target directory generated for the source file. In the manifest file, they are
+	// relative to the target directory; in memory, they include the target directory. See [loadStaticManifest].
+	Outputs []string `json:"outputs"`
+
+	// Deps maps the files the outputs depend on to their modification time in nanoseconds. The files are relative to
+	// the root directory of the site. This includes the source file itself, the templates used, the pages linked and
+	// the pages used for feed items. If a file did not exist, its modification time is 0. This matters because links
+	// to pages that exist get an ".html" suffix.
+	Deps map[string]int64 `json:"deps"`
+
+	// Feed records whether the source file was a candidate for a feed, i.e. an index page or a hashtag page.
+	Feed bool `json:"feed,omitempty"`
+}
+
+// newStaticEntry returns a new entry for a source file, depending on the source file.
+func newStaticEntry(source string) *staticEntry {
+	e := &staticEntry{Deps: make(map[string]int64)}
+	e.depend(source)
+	return e
+}
+
+// depend adds a file to the dependencies of the entry. Files outside the root directory of the site are ignored since
+// they cannot be pages of the site.
+func (e *staticEntry) depend(fp string) {
+	fp = filepath.Clean(fp)
+	if !filepath.IsLocal(fp) {
+		return
+	}
+	e.Deps[fp] = modTime(fp)
+}
+
+// modTime returns the modification time of a file of the default site in nanoseconds, or 0 if the file does not
+// exist.
+func modTime(fp string) int64 {
+	fi, err := defaultSite.store.stat(filepath.ToSlash(fp))
+	if err != nil {
+		return 0
+	}
+	return fi.ModTime().UnixNano()
+}
+
+// loadStaticManifest loads the manifest from the target directory. If there is no manifest, an empty manifest is
+// returned and every file is considered to be stale. The outputs are joined to the target directory. If any of them
+// would end up outside the target directory, the manifest is not used.
+func loadStaticManifest(target string) (*staticManifest, error) {
+	m := &staticManifest{Files: make(map[string]*staticEntry)}
+	b, err := os.ReadFile(filepath.Join(target, staticManifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", filepath.Join(target, staticManifestName), err)
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]*staticEntry)
+	}
+	for _, e := range m.Files {
+		for i, fp := range e.Outputs {
+			fp = filepath.FromSlash(fp)
+			if !filepath.IsLocal(fp) {
+				return nil, fmt.Errorf("cannot use %s: %s is outside the target directory",
+					filepath.Join(target, staticManifestName), fp)
+			}
+			e.Outputs[i] = filepath.Join(target, fp)
+		}
+	}
+	if m.Format == "" {
+		m.Format = "html"
+	}
+	return m, nil
+}
+
+// save writes the manifest to the target directory. The outputs are written relative to the target directory.
+func (m *staticManifest) save(target string) error {
+	c := &staticManifest{Format: m.Format, Feeds: m.Feeds, Search: m.Search, Files: make(map[string]*staticEntry, len(m.Files))}
+	for source, e := range m.Files {
+		outputs := make([]string, len(e.Outputs))
+		for i, fp := range e.Outputs {
+			rel, err := filepath.Rel(target, fp)
+			if err != nil {
+				return err
+			}
+			outputs[i] = filepath.ToSlash(rel)
+		}
+		c.Files[source] = &staticEntry{Outputs: outputs, Deps: e.Deps, Feed: e.Feed}
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(target, staticManifestName), b, 0644)
+}
+
+// merge copies the entries of the source files that were seen but not processed from the old manifest.
+func (m *staticManifest) merge(old *staticManifest, seen map[string]bool) {
+	for source := range seen {
+		if _, ok := m.Files[source]; ok {
+			continue
+		}
+		if e, ok := old.Files[source]; ok {
+			m.Files[source] = e
+		}
+	}
+}
+
+// pages returns the source files of all the pages in the manifest, sorted.
+func (m *staticManifest) pages() []string {
+	sources := []string{}
+	for source, e := range m.Files {
+		if strings.HasSuffix(source, ".md") && len(e.Outputs) > 0 {
+			sources = append(sources, source)
+		}
+	}
+	slices.Sort(sources)
+	return sources
+}
+
+// stale reports whether a source file needs to be processed. This is the case if the source file is unknown, if any
+// of its outputs are missing, if any of its dependencies changed, if it became or stopped being a feed candidate, or
+// if the output format, the feed formats or the search index changed.
+func (m *staticManifest) stale(source, format, feeds string, search bool) bool {
+	e, ok := m.Files[source]
+	if !ok {
+		return true
+	}
+	if strings.HasSuffix(source, ".md") && (m.Format != format || m.Feeds != feeds || m.Search != search ||
+		e.Feed != staticFeedCandidate(source[:len(source)-3])) {
+		return true
+	}
+	for _, fp := range e.Outputs {
+		_, err := os.Stat(fp)
+		if err != nil {
+			return true
+		}
+	}
+	for fp, t := range e.Deps {
+		if modTime(fp) != t {
+			return true
+		}
+	}
+	return false
+}
+
+// orphans returns the outputs of the old entry that are not outputs of the new entry. If the new entry is nil, all
+// the outputs of the old entry are returned.
+func orphans(old, e *staticEntry) []string {
+	if old == nil {
+		return nil
+	}
+	if e == nil {
+		return old.Outputs
+	}
+	files := []string{}
+	for _, fp := range old.Outputs {
+		if !slices.Contains(e.Outputs, fp) {
+			files = append(files, fp)
+		}
+	}
+	return files
+}
+
+// prune deletes a generated file. Any parent directories that end up empty are deleted, too, but the target
+// directory itself is kept. Files outside the target directory are never deleted.
+func prune(fp, target string) error {
+	rel, err := filepath.Rel(target, fp)
+	if err != nil || !filepath.IsLocal(rel) {
+		return fmt.Errorf("refusing to remove %s since it is outside %s", fp, target)
+	}
+	err = os.Remove(fp)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(fp); dir != target && strings.HasPrefix(dir, target); dir = filepath.Dir(dir) {
+		// this fails for directories that aren't empty
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The site files aren't generated for a particular source file. Their entries in the manifest use these keys instead
+// of a source file. If a site file isn't generated, its entry isn't seen and the file is removed like any other file
+// whose source file is gone. Tag pages and list pages use these prefixes followed by the tag or the directory.
+const (
+	staticSitemapKey   = ":sitemap"
+	staticSearchKey    = ":search"
+	staticNotFoundKey  = ":not-found"
+	staticTagPrefix    = ":tag/"
+	staticListPrefix   = ":list/"
+	staticTagDirectory = "tag"
+)
+
+// sitemapUrl is an URL in a sitemap. Lastmod is the modification date of the page in W3C Datetime format.
+type sitemapUrl struct {
+	Loc     string `xml:"loc"`
+	Lastmod string `xml:"lastmod"`
+}
+
+// sitemap is the sitemap of a static site. See https://www.sitemaps.org/protocol.html.
+type sitemap struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Urls    []sitemapUrl `xml:"url"`
+}
+
+// searchEntry is a page in the search index used by the script in the static.html template. The URL is relative to
+// the root of the static site. The tags are lower case and the text is the plain text of the page with whitespace
+// collapsed.
+type searchEntry struct {
+	Url   string   `json:"url"`
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+	Text  string   `json:"text"`
+}
+
+// staticList is a directory listing for the static-list.html template.
+type staticList struct {
+	// Title is the directory name, or "/" for the top directory.
+	Title string
+
+	// Dir is the directory name, percent-encoded.
+	Dir string
+
+	// Files are the files and subdirectories in the directory.
+	Files []staticListFile
+}
+
+// staticListFile is an entry in a directory listing.
+type staticListFile struct {
+	// Name is the filename. For pages, the ".md" suffix is part of the name.
+	Name string
+
+	// Path is the link to the file relative to the directory, percent-encoded. For pages, this is the HTML file.
+	// For directories, this is the directory with a trailing slash.
+	Path string
+
+	// Title is the page title, if the file is a page.
+	Title string
+
+	// IsDir is set for directories.
+	IsDir bool
+
+	// IsUp is set for the link to the parent directory.
+	IsUp bool
+
+	// Date is the last modification date of the file.
+	Date string
+}
+
+// staticSite holds what's needed to generate the files for the site as a whole.
+type staticSite struct {
+	source, target string
+	ext            string
+	search         bool
+	dryRun, quiet  bool
+	old, m         *staticManifest
+	seen           map[string]bool
+}
+
+// taken reports whether a generated file would clash with a file generated for a source file: either a page with the
+// same name or another file with the same name. Templates are not copied (see [staticFile]) and therefore don't clash.
+func (s *staticSite) taken(fp string) bool {
+	rel, err := filepath.Rel(s.target, fp)
+	if err != nil {
+		return true
+	}
+	src := filepath.Join(s.source, rel)
+	if s.seen[src] && !slices.Contains(templateFiles, filepath.Base(src)) {
+		return true
+	}
+	return strings.HasSuffix(src, s.ext) && s.seen[strings.TrimSuffix(src, s.ext)+".md"]
+}
+
+// generate writes the outputs of a site file using the function provided and adds an entry for the key to the
+// manifest. If any of the outputs clashes with a file generated for a source file, nothing is written. If deps is
+// nil, the outputs are written every time. Otherwise, deps are the files the outputs depend on and the outputs are
+// only written if they are stale. See [staticSite.stale]. In a dry run, the outputs are listed instead.
+func (s *staticSite) generate(key string, outputs []string, deps map[string]int64, fn func() error) error {
+	for _, fp := range outputs {
+		if s.taken(fp) {
+			fmt.Fprintf(os.Stderr, "Not writing %s since the source directory has a file by that name\n", fp)
+			return nil
+		}
+	}
+	s.seen[key] = true
+	e := &staticEntry{Outputs: outputs, Deps: deps}
+	if deps == nil {
+		e.Deps = map[string]int64{}
+	} else if !s.stale(key, e) {
+		s.m.Files[key] = e
+		return nil
+	}
+	if s.dryRun {
+		if !s.quiet {
+			for _, fp := range outputs {
+				fmt.Printf("update %s\n", fp)
+			}
+		}
+		s.m.Files[key] = e
+		return nil
+	}
+	for _, fp := range outputs {
+		err := os.MkdirAll(filepath.Dir(fp), 0755)
+		if err != nil {
+			return err
+		}
+	}
+	err := fn()
+	if err != nil {
+		return err
+	}
+	s.m.Files[key] = e
+	return nil
+}
+
+// stale reports whether a site file needs to be written. This is the case if the key is unknown, if the outputs
+// changed or are missing, if the files they depend on changed, or if the search index changed.
+func (s *staticSite) stale(key string, e *staticEntry) bool {
+	old, ok := s.old.Files[key]
+	if !ok || s.old.Search != s.m.Search || !slices.Equal(old.Outputs, e.Outputs) || !maps.Equal(old.Deps, e.Deps) {
+		return true
+	}
+	for _, fp := range e.Outputs {
+		_, err := os.Stat(fp)
+		if err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// staticSiteFiles writes the files for the site as a whole: the tag pages, the list pages for directories without an
+// index page and, if requested by the command, the sitemap, the search index and the page for files not found. These
+// are based on all the pages in the manifest. The sitemap, the search index and the page for files not found are
+// written every time since any page might affect them. The tag pages and the list pages are only written if the pages
+// and files they list changed according to the old manifest. The base URL is required for the sitemap. The extension
+// is the one used for pages, ".html" or ".gmi". In a dry run, the files are listed instead.
+func staticSiteFiles(source, target string, cmd *staticCmd, ext string, feeds []string, base *url.URL, old, m *staticManifest, seen map[string]bool, quiet bool) error {
+	s := &staticSite{source: source, target: target, ext: ext, search: cmd.search, dryRun: cmd.dryRun, quiet: quiet,
+		old: old, m: m, seen: seen}
+	if cmd.sitemap {
+		fp := filepath.Join(target, "sitemap.xml")
+		err := s.generate(staticSitemapKey, []string{fp}, nil, func() error { return staticSitemap(target, fp, base, m) })
+		if err != nil {
+			return err
+		}
+	}
+	if cmd.search {
+		fp := filepath.Join(target, "search.json")
+		err := s.generate(staticSearchKey, []string{fp}, nil, func() error { return staticSearch(target, fp, m) })
+		if err != nil {
+			return err
+		}
+	}
+	if cmd.notFound {
+		fp := filepath.Join(target, "404.html")
+		err := s.generate(staticNotFoundKey, []string{fp}, nil, func() error {
+			return write(&staticPageData{Page: &Page{Title: "Page Not Found", Name: "404"}, Search: cmd.search}, fp, "",
+				"404.html")
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := staticTagPages(s, feeds)
+	if err != nil {
+		return err
+	}
+	return staticListPages(s)
+}
+
+// staticUrl returns the URL of a generated file relative to the root of the static site.
+func staticUrl(target, fp string) (*url.URL, error) {
+	rel, err := filepath.Rel(target, fp)
+	if err != nil {
+		return nil, err
+	}
+	return &url.URL{Path: filepath.ToSlash(rel)}, nil
+}
+
+// staticSitemap writes the sitemap listing all the pages in the manifest.
+func staticSitemap(target, fp string, base *url.URL, m *staticManifest) error {
+	s := &sitemap{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, source := range m.pages() {
+		e := m.Files[source]
+		u, err := staticUrl(target, e.Outputs[0])
+		if err != nil {
+			return err
+		}
+		s.Urls = append(s.Urls, sitemapUrl{
+			Loc:     base.ResolveReference(u).String(),
+			Lastmod: time.Unix(0, e.Deps[source]).Format(time.RFC3339),
+		})
+	}
+	b, err := xml.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(fp, append([]byte(xml.Header), b...), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write %s: %s\n", fp, err)
+	}
+	return err
+}
+
+// staticSearch writes the search index for all the pages in the manifest.
+func staticSearch(target, fp string, m *staticManifest) error {
+	entries := []searchEntry{}
+	for _, source := range m.pages() {
+		p, err := loadPage(filepath.ToSlash(strings.TrimSuffix(source, ".md")))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot load %s: %s\n", source, err)
+			return err
+		}
+		p.handleTitle(true)
+		u, err := staticUrl(target, m.Files[source].Outputs[0])
+		if err != nil {
+			return err
+		}
+		tags := hashtags(p.Body)
+		for i, tag := range tags {
+			tags[i] = strings.ToLower(tag)
+		}
+		entries = append(entries, searchEntry{
+			Url:   u.String(),
+			Title: p.Title,
+			Tags:  tags,
+			Text:  strings.Join(strings.Fields(p.plainText()), " "),
+		})
+	}
+	file, err := os.Create(fp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create %s: %s\n", fp, err)
+		return err
+	}
+	defer file.Close()
+	enc := json.NewEncoder(file)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(entries)
+}
+
+// staticTagUrl returns the URL of the tag page for a hashtag, given the relative URL of the root of the static site.
+// The extension is either ".html" or ".gmi".
+func staticTagUrl(root, tag, ext string) string {
+	return root + staticTagDirectory + "/" + url.PathEscape(strings.ToLower(tag)) + ext
+}
+
+// staticTagPages writes a page for every hashtag used by the pages in the manifest, listing the pages with the most
+// recently modified page first, and feeds in the given formats. The hashtag links of the static pages point to these
+// pages. See staticLink.
+func staticTagPages(s *staticSite, feeds []string) error {
+	for tag, ids := range index.token {
+		// the tag is used as a filename
+		if strings.ContainsAny(tag, `/\`) || strings.HasPrefix(tag, ".") {
+			continue
+		}
+		sources := []string{}
+		for _, id := range ids {
+			name, ok := index.documents[id]
+			if !ok {
+				continue
+			}
+			source := filepath.FromSlash(name) + ".md"
+			if e, ok := s.m.Files[source]; ok && len(e.Outputs) > 0 {
+				sources = append(sources, source)
+			}
+		}
+		if len(sources) == 0 {
+			continue
+		}
+		slices.SortFunc(sources, func(a, b string) int {
+			return int(s.m.Files[b].Deps[b]/1e9 - s.m.Files[a].Deps[a]/1e9)
+		})
+		fp := filepath.Join(s.target, staticTagDirectory, tag)
+		outputs := []string{fp + s.ext}
+		// the pages listed, their titles and dates, and the feed items depend on the pages
+		e := newStaticEntry(sources[0])
+		for _, source := range sources[1:] {
+			e.depend(source)
+		}
+		if s.ext == ".html" {
+			e.depend("static.html")
+		}
+		for _, format := range feeds {
+			outputs = append(outputs, fp+"."+format)
+			if feedFormats[format] != "" {
+				e.depend(feedFormats[format])
+			}
+		}
+		err := s.generate(staticTagPrefix+tag, outputs, e.Deps, func() error {
+			return staticTagPage(s, tag, sources, fp, feeds)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// staticTagPage writes the tag page and its feeds. The target has no extension. For Gemini, the tag page is a gemlog
+// and there are no feeds.
+func staticTagPage(s *staticSite, tag string, sources []string, target string, feeds []string) error {
+	if s.ext == ".gmi" {
+		return staticTagGemlog(s, tag, sources, target+s.ext)
+	}
+	var list, body strings.Builder
+	list.WriteString("<ul>\n")
+	for _, source := range sources {
+		name := filepath.ToSlash(strings.TrimSuffix(source, ".md"))
+		title, ok := index.titles[name]
+		if !ok {
+			title = name
+		}
+		u, err := staticUrl(s.target, s.m.Files[source].Outputs[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "<li><a href=\"../%s\">%s</a></li>\n", html.EscapeString(u.String()), html.EscapeString(title))
+		fmt.Fprintf(&body, "* [%s](%s)\n", name, nameEscape(name))
+	}
+	list.WriteString("</ul>\n")
+	for _, format := range feeds {
+		fmt.Fprintf(&list, "<p><a href=\"%s.%s\">%s</a></p>\n", url.PathEscape(tag), format, strings.ToUpper(format))
+	}
+	p := &Page{Title: "#" + tag, Name: path.Join(staticTagDirectory, tag), Html: unsafeBytes([]byte(list.String()))}
+	err := write(&staticPageData{Page: p, Root: "../", Search: s.search}, target+".html", "", "static.html")
+	if err != nil {
+		return err
+	}
+	// the feed is based on a page listing the pages
+	p = &Page{Title: "#" + tag, Name: tag, Body: []byte(body.String())}
+	first := sources[0]
+	f := feed(p, time.Unix(0, s.m.Files[first].Deps[first]), 0, 10, getFeedOptions())
+	for _, format := range feeds {
+		fp := target + "." + format
+		if format == "json" {
+			err = writeJson(f, fp)
+		} else {
+			err = write(f, fp, `<?xml version="1.0" encoding="UTF-8"?>`, feedFormats[format])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// staticTagGemlog writes the tag page for Gemini: a gemlog linking to the pages, with the most recently modified page
+// first. The date of a page is the date its name starts with or its last modification date.
+func staticTagGemlog(s *staticSite, tag string, sources []string, fp string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# #%s\n\n", tag)
+	w := &gemtextWriter{gemlog: true}
+	for _, source := range sources {
+		name := filepath.ToSlash(strings.TrimSuffix(source, ".md"))
+		title, ok := index.titles[name]
+		if !ok {
+			title = name
+		}
+		u, err := staticUrl(s.target, s.m.Files[source].Outputs[0])
+		if err != nil {
+			return err
+		}
+		w.date = time.Unix(0, s.m.Files[source].Deps[source]).Format(time.DateOnly)
+		b.WriteString(w.link("../"+u.String(), title, true) + "\n")
+	}
+	err := os.WriteFile(fp, []byte(b.String()), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write %s: %s\n", fp, err)
+	}
+	return err
+}
+
+// staticListPages writes a list page for every directory without an index page, using the static-list.html template.
+// The directories are the directories of all the files in the manifest.
+func staticListPages(s *staticSite) error {
+	dirs := make(map[string][]string)
+	for source := range s.m.Files {
+		if strings.HasPrefix(source, ":") {
+			continue
+		}
+		// add the file to its directory and make sure all the parent directories are known
+		dir := filepath.Dir(source)
+		dirs[dir] = append(dirs[dir], source)
+		for dir != s.source && dir != "." && dir != string(filepath.Separator) {
+			parent := filepath.Dir(dir)
+			if !slices.Contains(dirs[parent], dir) {
+				dirs[parent] = append(dirs[parent], dir)
+			}
+			dir = parent
+		}
+	}
+	for dir, files := range dirs {
+		if !strings.HasPrefix(dir, s.source) && s.source != "." {
+			continue
+		}
+		if s.seen[filepath.Join(dir, "index.md")] {
+			continue
+		}
+		rel, err := filepath.Rel(s.source, dir)
+		if err != nil {
+			return err
+		}
+		fp := filepath.Join(s.target, rel, "index"+s.ext)
+		err = s.generate(staticListPrefix+filepath.ToSlash(rel), []string{fp}, s.listDeps(files), func() error {
+			return staticListPage(s, rel, files, fp)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listDeps returns the dependencies of a list page: the files listed, since their dates and titles are shown, and the
+// template used. Subdirectories are listed by name only and so their modification time is always 0.
+func (s *staticSite) listDeps(files []string) map[string]int64 {
+	e := &staticEntry{Deps: make(map[string]int64)}
+	for _, file := range files {
+		if _, ok := s.m.Files[file]; ok {
+			e.depend(file)
+		} else {
+			e.Deps[file] = 0
+		}
+	}
+	if s.ext == ".html" {
+		e.depend("static-list.html")
+	}
+	return e.Deps
+}
+
+// staticListPage writes the list page for a directory. The directory is relative to the source directory.
+func staticListPage(s *staticSite, dir string, files []string, fp string) error {
+	l := &staticList{Title: "/", Dir: ""}
+	if dir != "." {
+		l.Title = filepath.ToSlash(dir) + "/"
+		l.Dir = pathEncode(filepath.ToSlash(dir)) + "/"
+		l.Files = append(l.Files, staticListFile{Name: "..", Path: "../", IsDir: true, IsUp: true})
+	}
+	slices.Sort(files)
+	for _, file := range files {
+		name := filepath.Base(file)
+		f := staticListFile{Name: name, Path: url.PathEscape(name)}
+		if e, ok := s.m.Files[file]; ok {
+			// templates have no outputs
+			if len(e.Outputs) == 0 {
+				continue
+			}
+			f.Date = time.Unix(0, e.Deps[file]).Format(time.DateOnly)
+			if strings.HasSuffix(name, ".md") {
+				f.Path = url.PathEscape(strings.TrimSuffix(name, ".md")) + s.ext
+				f.Title = index.titles[filepath.ToSlash(strings.TrimSuffix(file, ".md"))]
+			}
+		} else {
+			f.IsDir = true
+			f.Path += "/"
+		}
+		l.Files = append(l.Files, f)
+	}
+	if s.ext == ".gmi" {
+		return staticListGemtext(l, fp)
+	}
+	return write(l, fp, "", "static-list.html")
+}
+
+// staticListGemtext writes the list page for a directory as gemtext, with a link line for every file.
+func staticListGemtext(l *staticList, fp string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", l.Title)
+	for _, f := range l.Files {
+		label := f.Name
+		if f.IsDir && !f.IsUp {
+			label += "/"
+		} else if f.Title != "" {
+			label = f.Title
+		}
+		if f.Date != "" {
+			label = f.Date + " " + label
+		}
+		fmt.Fprintf(&b, "=> %s %s\n", f.Path, label)
+	}
+	err := os.WriteFile(fp, []byte(b.String()), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write %s: %s\n", fp, err)
+	}
+	return err
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storage is where a site keeps its pages and files. The names used are the names used by [fs.FS]: paths using
+// slashes, relative to the root of the storage, with "." for the root itself. A page is stored using its name with the
+// ".md" extension. As every storage is a [fs.FS], the functions of the io/fs package such as [fs.WalkDir] work, too.
+type storage interface {
+	fs.FS
+
+	// readFile returns the content of a file.
+	readFile(name string) ([]byte, error)
+
+	// writeFile writes a file, creating missing directories.
+	writeFile(name string, data []byte) error
+
+	// writeFrom writes a file with the data read from the reader, creating missing directories. Unlike writeFile,
+	// the data doesn't have to be kept in memory.
+	writeFrom(name string, r io.Reader) error
+
+	// stat returns information about a file or directory.
+	stat(name string) (fs.FileInfo, error)
+
+	// readDir returns the entries of a directory, sorted by name.
+	readDir(name string) ([]fs.DirEntry, error)
+
+	// mkdir creates a directory. The parent directory must exist.
+	mkdir(name string) error
+
+	// rename renames a file or a directory, creating missing directories.
+	rename(from, to string) error
+
+	// remove deletes a file or an empty directory.
+	remove(name string) error
+
+	// touch sets the modification time of a file.
+	touch(name string, t time.Time) error
+
+	// versioned reports whether the storage keeps old versions by itself. If so, no backup files are made.
+	versioned() bool
+}
+
+// openStorage returns the storage for the site based on the setting "storage": "file" (the default) stores the pages
+// and files in the root directory, "git" also commits every change to the git repository in the root directory.
+func (s *site) openStorage() (storage, error) {
+	switch v := s.setting("storage"); v {
+	case "", "file":
+		return &fileStorage{root: s.root}, nil
+	case "git":
+		return newGitStorage(s.root)
+	default:
+		return nil, fmt.Errorf("unknown storage %s", v)
+	}
+}
+
+// storagePath returns the name of a page, file or directory as used by the storage. Leading and trailing slashes are
+// removed and the empty string becomes the root.
+func storagePath(name string) string {
+	return path.Clean(strings.Trim(name, "/"))
+}
+
+// fileStorage keeps pages and files in a directory of the file system.
+type fileStorage struct {
+	root string
+}
+
+// path returns the filepath for a name, or an error if the name is invalid.
+func (s *fileStorage) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(s.root, filepath.FromSlash(name)), nil
+}
+
+func (s *fileStorage) Open(name string) (fs.File, error) {
+	return os.DirFS(s.root).Open(name)
+}
+
+func (s *fileStorage) readFile(name string) ([]byte, error) {
+	fp, err := s.path("read", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(fp)
+}
+
+func (s *fileStorage) writeFile(name string, data []byte) error {
+	fp, err := s.path("write", name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(fp), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fp, data, 0644)
+}
+
+func (s *fileStorage) writeFrom(name string, r io.Reader) error {
+	fp, err := s.path("write", name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(fp), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(fp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *fileStorage) stat(name string) (fs.FileInfo, error) {
+	fp, err := s.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(fp)
+}
+
+func (s *fileStorage) readDir(name string) ([]fs.DirEntry, error) {
+	fp, err := s.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(fp)
+}
+
+func (s *fileStorage) mkdir(name string) error {
+	fp, err := s.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(fp, 0755)
+}
+
+func (s *fileStorage) rename(from, to string) error {
+	fp, err := s.path("rename", from)
+	if err != nil {
+		return err
+	}
+	tp, err := s.path("rename", to)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(tp), 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(fp, tp)
+}
+
+func (s *fileStorage) remove(name string) error {
+	fp, err := s.path("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fp)
+}
+
+func (s *fileStorage) touch(name string, t time.Time) error {
+	fp, err := s.path("touch", name)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(fp, t, t)
+}
+
+func (s *fileStorage) versioned() bool {
+	return false
+}
+
+// gitStorage keeps pages and files in a directory of the file system that is part of a git repository. Every change
+// is committed. The name and email address of the committer are taken from the git configuration.
+type gitStorage struct {
+	fileStorage
+
+	// author is the name used as the author of the commits. If empty, the committer is the author. See [authored].
+	author string
+
+	// mu makes sure that only one change is added and committed at a time. It is shared by the copies made by
+	// [authored].
+	mu *sync.Mutex
+}
+
+// Revision is a commit that changed a file. Date uses the [time.DateTime] format.
+type Revision struct {
+	Hash    string
+	Author  string
+	Date    string
+	Message string
+}
+
+// revisionRe matches the commit hashes accepted from users.
+var revisionRe = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+
+// authored returns the storage to use for the changes made by a user. If the storage records authors, the changes are
+// attributed to the user. Otherwise, or if the user is unknown, the storage is returned unchanged. Angle brackets and
+// line breaks are removed from the user name since git would take them for the email address or the end of the name.
+func authored(store storage, user string) storage {
+	g, ok := store.(*gitStorage)
+	if !ok {
+		return store
+	}
+	user = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '<' || r == '>' || r == '\n' || r == '\r' || r == 0 {
+			return -1
+		}
+		return r
+	}, user))
+	if user == "" {
+		return store
+	}
+	c := *g
+	c.author = user
+	return &c
+}
+
+// newGitStorage returns a git storage for the root directory. The root directory must be part of a git work tree.
+func newGitStorage(root string) (*gitStorage, error) {
+	s := &gitStorage{fileStorage: fileStorage{root: root}, mu: &sync.Mutex{}}
+	err := s.git("rev-parse", "--is-inside-work-tree")
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// git runs a git command in the root directory.
+func (s *gitStorage) git(args ...string) error {
+	_, err := s.output(args...)
+	return err
+}
+
+// output runs a git command in the root directory and returns its output.
+func (s *gitStorage) output(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.root
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// commit adds the named files to the git index and commits them with the message given. Only the named files are
+// committed, even if other changes have been added to the index. Files that are ignored by git are skipped. If nothing
+// changed, nothing is committed.
+func (s *gitStorage) commit(message string, names ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := []string{}
+	for _, name := range names {
+		fp := filepath.FromSlash(name)
+		var err error
+		if _, err = s.fileStorage.stat(name); err != nil {
+			// a file that was deleted before it was ever committed is unknown to git
+			err = s.git("rm", "-r", "--cached", "--quiet", "--ignore-unmatch", "--", literal(fp))
+		} else if s.git("check-ignore", "--quiet", "--", fp) == nil {
+			continue
+		} else {
+			err = s.git("add", "--all", "--", literal(fp))
+		}
+		if err != nil {
+			return err
+		}
+		paths = append(paths, literal(fp))
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	out, err := s.output(append([]string{"diff", "--cached", "--name-only", "--relative", "-z", "--"}, paths...)...)
+	if err != nil {
+		return err
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	args := []string{"commit", "--quiet", "--message", message}
+	if s.author != "" {
+		args = append(args, "--author", s.author+" <>")
+	}
+	args = append(args, "--")
+	for _, fp := range strings.Split(strings.TrimRight(string(out), "\x00"), "\x00") {
+		args = append(args, literal(fp))
+	}
+	return s.git(args...)
+}
+
+// literal returns a pathspec for git that matches the file name given and nothing else, even if the name contains
+// characters like "*".
+func literal(fp string) string {
+	return ":(literal)" + fp
+}
+
+// log returns the last commits that changed a file, newest first. At most n commits are returned.
+func (s *gitStorage) log(name string, n int) ([]Revision, error) {
+	out, err := s.output("log", "--max-count", strconv.Itoa(n), "--format=%H%x00%an%x00%aI%x00%s", "--",
+		filepath.FromSlash(name))
+	if err != nil {
+		return nil, err
+	}
+	revs := []Revision{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 4 {
+			continue
+		}
+		rev := Revision{Hash: fields[0], Author: fields[1], Date: fields[2], Message: fields[3]}
+		ti, err := time.Parse(time.RFC3339, fields[2])
+		if err == nil {
+			rev.Date = ti.Format(time.DateTime)
+		}
+		revs = append(revs, rev)
+	}
+	return revs, nil
+}
+
+// show returns the content of a file as it was in a commit. Append "~" to the hash of a commit to get the content
+// before the commit.
+func (s *gitStorage) show(rev, name string) ([]byte, error) {
+	return s.output("show", rev+":./"+name)
+}
+
+func (s *gitStorage) writeFile(name string, data []byte) error {
+	err := s.fileStorage.writeFile(name, data)
+	if err != nil {
+		return err
+	}
+	return s.commit("Update "+name, name)
+}
+
+func (s *gitStorage) writeFrom(name string, r io.Reader) error {
+	err := s.fileStorage.writeFrom(name, r)
+	if err != nil {
+		return err
+	}
+	return s.commit("Update "+name, name)
+}
+
+func (s *gitStorage) rename(from, to string) error {
+	err := s.fileStorage.rename(from, to)
+	if err != nil {
+		return err
+	}
+	return s.commit("Rename "+from+" to "+to, from, to)
+}
+
+func (s *gitStorage) remove(name string) error {
+	err := s.fileStorage.remove(name)
+	if err != nil {
+		return err
+	}
+	return s.commit("Delete "+name, name)
+}
+
+func (s *gitStorage) versioned() bool {
+	return true
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/subcommands"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	m := newMemoryStorage()
+	assert.NoError(t, m.writeFile("notes/dawn.md", []byte("# Dawn")))
+	assert.NoError(t, m.writeFile("index.md", []byte("# Index")))
+	assert.Error(t, m.writeFile("../escape.md", []byte("# Escape")))
+	b, err := m.readFile("notes/dawn.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "# Dawn", string(b))
+	fi, err := m.stat("notes")
+	assert.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	entries, err := m.readDir(".")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "index.md", entries[0].Name())
+	// renaming a directory renames the files in it
+	assert.NoError(t, m.rename("notes", "diary/notes"))
+	_, err = m.stat("notes/dawn.md")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	b, err = fs.ReadFile(m, "diary/notes/dawn.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "# Dawn", string(b))
+	assert.NoError(t, m.touch("index.md", time.Now()))
+	assert.NoError(t, m.remove("index.md"))
+	assert.ErrorIs(t, m.remove("index.md"), fs.ErrNotExist)
+	assert.False(t, m.versioned())
+	// directories can be made and removed if empty
+	assert.Error(t, m.mkdir("poems/haiku"))
+	assert.NoError(t, m.mkdir("poems"))
+	assert.ErrorIs(t, m.mkdir("poems"), fs.ErrExist)
+	assert.NoError(t, m.mkdir("poems/haiku"))
+	assert.Error(t, m.remove("poems"))
+	assert.NoError(t, m.rename("poems", "verse"))
+	assert.NoError(t, m.remove("verse/haiku"))
+	assert.NoError(t, m.remove("verse"))
+	_, err = m.stat("verse")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestMemorySite(t *testing.T) {
+	s := newSite("testdata/memory")
+	s.store = newMemoryStorage()
+	for _, fn := range []string{"view.html", "list.html"} {
+		b, err := os.ReadFile(fn)
+		assert.NoError(t, err)
+		assert.NoError(t, s.store.writeFile(fn, b))
+	}
+	hostSites.Lock()
+	hostSites.sites = map[string]*site{"memory.example.org": s}
+	hostSites.Unlock()
+	t.Cleanup(func() {
+		hostSites.Lock()
+		defer hostSites.Unlock()
+		hostSites.sites = nil
+	})
+	save := virtualHosts(makeHandler(saveHandler, true, http.MethodPost))
+	w := hostRequest(save, http.MethodPost, "memory.example.org", "/save/notes/dawn",
+		url.Values{"body": {"# Dawn\n\nThe sun rises"}})
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.NoDirExists(t, "testdata/memory")
+	assert.Equal(t, "Dawn", s.index.titles["notes/dawn"])
+	view := virtualHosts(makeHandler(viewHandler, false, http.MethodGet))
+	w = hostRequest(view, http.MethodGet, "memory.example.org", "/view/notes/dawn", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "The sun rises")
+	w = hostRequest(view, http.MethodGet, "memory.example.org", "/view/notes/dawn.md", nil)
+	assert.Equal(t, "# Dawn\n\nThe sun rises", w.Body.String())
+	list := virtualHosts(makeHandler(listHandler, false, http.MethodGet))
+	w = hostRequest(list, http.MethodGet, "memory.example.org", "/list/notes/", nil)
+	assert.Contains(t, w.Body.String(), "dawn.md")
+	rename := virtualHosts(makeHandler(renameHandler, true, http.MethodPost))
+	w = hostRequest(rename, http.MethodPost, "memory.example.org", "/rename/notes/dawn.md",
+		url.Values{"name": {"dusk.md"}})
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "Dawn", s.index.titles["notes/dusk"])
+	// deleting makes a backup
+	w = hostRequest(save, http.MethodPost, "memory.example.org", "/save/notes/dusk", url.Values{"body": {""}})
+	assert.Equal(t, http.StatusFound, w.Code)
+	_, err := s.store.stat("notes/dusk.md~")
+	assert.NoError(t, err)
+	assert.NotContains(t, s.index.titles, "notes/dusk")
+}
+
+// useGit creates a git repository in the directory and returns a site using it with the git storage. The test is
+// skipped if git is not installed.
+func useGit(t *testing.T, dir string) *site {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	cleanup(t, dir)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"config", "user.name", "Oddmu"},
+		{"config", "user.email", "oddmu@example.org"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		assert.NoError(t, cmd.Run())
+	}
+	s := newSite(dir)
+	store, err := newGitStorage(s.root)
+	assert.NoError(t, err)
+	s.store = store
+	return s
+}
+
+// gitLog returns the output of git log in the directory using the format given, one line per commit.
+func gitLog(t *testing.T, dir, format string) []string {
+	cmd := exec.Command("git", "log", "--format="+format)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(out)), "\n")
+}
+
+func TestGitStorage(t *testing.T) {
+	s := useGit(t, "testdata/git")
+	store := s.store
+	p := &Page{Name: "haiku", Body: []byte("Old commits pile up\nNothing is ever lost here\nThe log remembers"), site: s}
+	assert.NoError(t, p.save())
+	p.Body = []byte("Old commits pile up\nNothing is ever lost here\nThe log forgets not")
+	assert.NoError(t, p.save())
+	// no backups are made
+	assert.NoFileExists(t, "testdata/git/haiku.md~")
+	assert.NoError(t, store.rename("haiku.md", "poems/haiku.md"))
+	assert.NoError(t, store.remove("poems/haiku.md"))
+	// files can be streamed
+	assert.NoError(t, store.writeFrom("notes/stream.txt", strings.NewReader("Water flows")))
+	b, err := os.ReadFile("testdata/git/notes/stream.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "Water flows", string(b))
+	assert.Equal(t, []string{"Update notes/stream.txt", "Delete poems/haiku.md", "Rename haiku.md to poems/haiku.md",
+		"Update haiku.md", "Update haiku.md"}, gitLog(t, "testdata/git", "%s"))
+	_, err = newGitStorage(os.TempDir())
+	assert.Error(t, err)
+}
+
+func TestGitCommit(t *testing.T) {
+	s := useGit(t, "testdata/git-commit")
+	store := s.store
+	// changes added to the index by others are not committed
+	assert.NoError(t, os.WriteFile("testdata/git-commit/other.md", []byte("Other"), 0644))
+	cmd := exec.Command("git", "add", "other.md")
+	cmd.Dir = "testdata/git-commit"
+	assert.NoError(t, cmd.Run())
+	assert.NoError(t, authored(store, "alex <alex@example.org>\nEvil").writeFile("[a]*.md", []byte("Stars")))
+	assert.Equal(t, []string{"Update [a]*.md"}, gitLog(t, "testdata/git-commit", "%s"))
+	assert.Equal(t, []string{"alex alex@example.orgEvil"}, gitLog(t, "testdata/git-commit", "%an"))
+	cmd = exec.Command("git", "diff", "--cached", "--name-only")
+	cmd.Dir = "testdata/git-commit"
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "other.md\n", string(out))
+	// ignored files are skipped
+	assert.NoError(t, os.WriteFile("testdata/git-commit/.gitignore", []byte("*.tmp\n"), 0644))
+	assert.NoError(t, store.writeFile("scratch.tmp", []byte("Scratch")))
+	assert.Equal(t, 1, len(gitLog(t, "testdata/git-commit", "%s")))
+	// removing a file that was never committed commits nothing
+	assert.NoError(t, os.WriteFile("testdata/git-commit/new.md", []byte("New"), 0644))
+	assert.NoError(t, store.remove("new.md"))
+	assert.Equal(t, 1, len(gitLog(t, "testdata/git-commit", "%s")))
+}
+
+func TestGitHistory(t *testing.T) {
+	s := useGit(t, "testdata/git-history")
+	for _, fn := range []string{"diff.html", "history.html"} {
+		b, err := os.ReadFile(fn)
+		assert.NoError(t, err)
+		assert.NoError(t, s.store.writeFile(fn, b))
+	}
+	hostSites.Lock()
+	hostSites.sites = map[string]*site{"git.example.org": s}
+	hostSites.Unlock()
+	t.Cleanup(func() {
+		hostSites.Lock()
+		defer hostSites.Unlock()
+		hostSites.sites = nil
+	})
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile("testdata/git-history/.htpasswd", []byte("alex:"+string(hash)+"\n"), 0644))
+	save := virtualHosts(makeHandler(saveHandler, true, http.MethodPost))
+	post := func(path, body, username string) {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(url.Values{"body": {body}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Host = "git.example.org"
+		r.SetBasicAuth(username, "secret")
+		w := httptest.NewRecorder()
+		save.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusFound, w.Code)
+	}
+	// without a password file, the name sent is not trusted
+	os.Unsetenv("ODDMU_AUTH")
+	post("/save/sun", "# Sun\n\nThe sun is hot", "mallory")
+	t.Setenv("ODDMU_AUTH", ".htpasswd")
+	post("/save/moon", "# Moon\n\nThe moon is bright", "alex")
+	post("/save/moon", "# Moon\n\nThe moon is dark", "alex")
+	// the templates were committed by the committer, the page by the user
+	assert.Equal(t, []string{"alex", "alex", "Anonymous", "Oddmu", "Oddmu"}, gitLog(t, "testdata/git-history", "%an"))
+	hashes := gitLog(t, "testdata/git-history", "%H")
+	// the history lists the commits for the page only
+	history := virtualHosts(makeHandler(historyHandler, true, http.MethodGet))
+	w := hostRequest(history, http.MethodGet, "git.example.org", "/history/moon", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "/diff/moon?rev="+hashes[0])
+	assert.Contains(t, body, "/diff/moon?rev="+hashes[1])
+	assert.NotContains(t, body, hashes[2])
+	assert.Contains(t, body, "alex: Update moon.md")
+	// the diff without revision shows the last commit
+	diff := virtualHosts(makeHandler(diffHandler, true, http.MethodGet))
+	w = hostRequest(diff, http.MethodGet, "git.example.org", "/diff/moon", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<del>bright</del>")
+	assert.Contains(t, w.Body.String(), "<ins>dark</ins>")
+	assert.Contains(t, w.Body.String(), "/history/moon")
+	assert.NotContains(t, w.Body.String(), "moon.md~")
+	// the diff for the first commit shows the page created
+	w = hostRequest(diff, http.MethodGet, "git.example.org", "/diff/moon", url.Values{"rev": {hashes[1]}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<ins># Moon")
+	assert.NotContains(t, w.Body.String(), "<del>")
+	// revisions must be hashes
+	w = hostRequest(diff, http.MethodGet, "git.example.org", "/diff/moon", url.Values{"rev": {"--output=x"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	// without git, there is no history
+	w = hostRequest(history, http.MethodGet, "example.org", "/history/moon", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGitReplace(t *testing.T) {
+	s := useGit(t, "testdata/git-replace")
+	assert.NoError(t, s.store.writeFile("dawn.md", []byte("# Dawn\n\nThe sun rises.")))
+	assert.NoError(t, s.store.writeFile("noon.md", []byte("# Noon\n\nThe sun rises no more.")))
+	assert.NoError(t, os.WriteFile("testdata/git-replace/.oddmu.toml", []byte("storage = \"git\"\n"), 0644))
+	os.Unsetenv("ODDMU_CONFIG")
+	os.Unsetenv("ODDMU_STORAGE")
+	assert.NoError(t, setRoot("testdata/git-replace"))
+	t.Cleanup(func() {
+		setRoot(".")
+	})
+	b := new(bytes.Buffer)
+	assert.Equal(t, subcommands.ExitSuccess, replaceCli(b, true, false, []string{"rises", "sets"}))
+	// one commit for all the changes and no backups
+	assert.Equal(t, []string{"Replace rises with sets", "Update noon.md", "Update dawn.md"},
+		gitLog(t, "testdata/git-replace", "%s"))
+	assert.NoFileExists(t, "testdata/git-replace/dawn.md~")
+}
+
+// memoryStorage keeps pages and files in memory. Nothing is kept when the process ends. Directories exist as long as
+// they contain files or were made using mkdir. It is used to test code that uses a storage without touching the file system.
+type memoryStorage struct {
+	sync.RWMutex
+	files fstest.MapFS
+}
+
+// newMemoryStorage returns an empty memory storage.
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{files: make(fstest.MapFS)}
+}
+
+func (m *memoryStorage) Open(name string) (fs.File, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.files.Open(name)
+}
+
+func (m *memoryStorage) readFile(name string) ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.files.ReadFile(name)
+}
+
+func (m *memoryStorage) writeFile(name string, data []byte) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.files[name] = &fstest.MapFile{Data: bytes.Clone(data), Mode: 0644, ModTime: time.Now()}
+	return nil
+}
+
+func (m *memoryStorage) writeFrom(name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return m.writeFile(name, data)
+}
+
+func (m *memoryStorage) stat(name string) (fs.FileInfo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.files.Stat(name)
+}
+
+func (m *memoryStorage) readDir(name string) ([]fs.DirEntry, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.files.ReadDir(name)
+}
+
+func (m *memoryStorage) mkdir(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	m.Lock()
+	defer m.Unlock()
+	if _, err := m.files.Stat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if dir := path.Dir(name); dir != "." {
+		fi, err := m.files.Stat(dir)
+		if err != nil || !fi.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	m.files[name] = &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: time.Now()}
+	return nil
+}
+
+func (m *memoryStorage) rename(from, to string) error {
+	if !fs.ValidPath(from) || !fs.ValidPath(to) || from == "." || to == "." {
+		return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrInvalid}
+	}
+	m.Lock()
+	defer m.Unlock()
+	if f, ok := m.files[from]; ok && !f.Mode.IsDir() {
+		delete(m.files, from)
+		m.files[to] = f
+		return nil
+	}
+	// renaming a directory renames all the files in it
+	names := []string{}
+	for name := range m.files {
+		if name == from || strings.HasPrefix(name, from+"/") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrNotExist}
+	}
+	for _, name := range names {
+		m.files[to+name[len(from):]] = m.files[name]
+		delete(m.files, name)
+	}
+	return nil
+}
+
+func (m *memoryStorage) remove(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if f.Mode.IsDir() {
+		for n := range m.files {
+			if strings.HasPrefix(n, name+"/") {
+				return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+			}
+		}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memoryStorage) touch(name string, t time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		return &fs.PathError{Op: "touch", Path: name, Err: fs.ErrNotExist}
+	}
+	c := *f
+	c.ModTime = t
+	m.files[name] = &c
+	return nil
+}
+
+func (m *memoryStorage) versioned() bool {
+	return false
+}
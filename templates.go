@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
 	"html/template"
+	"io"
 	"io/fs"
-	"log"
+	"log/slog"
 	"net/http"
+	"path"
 	"path/filepath"
 	"slices"
 	"strings"
@@ -15,13 +18,16 @@ import (
 // able to generate HTML output. This always requires a template.
 var templateFiles = []string{"edit.html", "add.html", "view.html", "preview.html",
 	"diff.html", "search.html", "static.html", "upload.html", "feed.html",
-	"list.html"}
+	"atom.html", "list.html", "404.html", "static-list.html", "history.html"}
 
 // templateStore controls access to map of parsed HTML templates. Make sure to lock and unlock as appropriate. See
 // renderTemplate and loadTemplates.
 type templateStore struct {
 	sync.RWMutex
 
+	// site is the site whose templates are loaded. If nil, the default site is used.
+	site *site
+
 	// template is a map of parsed HTML templates. The key is their filepath name. By default, the map only contains
 	// top-level templates like "view.html". Subdirectories may contain their own templates which override the
 	// templates in the root directory. If so, they are filepaths like "dir/view.html".
@@ -32,31 +38,65 @@ var templates templateStore
 
 // loadTemplates loads the templates. If templates have already been loaded, return immediately.
 func loadTemplates() {
-	if templates.template != nil {
+	templates.load()
+}
+
+// load loads the templates. If templates have already been loaded, return immediately.
+func (t *templateStore) load() {
+	t.RLock()
+	loaded := t.template != nil
+	t.RUnlock()
+	if loaded {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.template != nil {
 		return
 	}
-	templates.Lock()
-	defer templates.Unlock()
 	// walk the directory, load templates and add directories
-	templates.template = make(map[string]*template.Template)
-	filepath.Walk(".", loadTemplate)
-	log.Println(len(templates.template), "templates loaded")
+	t.template = make(map[string]*template.Template)
+	fs.WalkDir(t.wiki().store, ".", t.loadTemplate)
+	slog.Info("Templates loaded", "dir", t.wiki().root, "count", len(t.template))
+}
+
+// reloadTemplates discards all the templates and loads them again.
+func reloadTemplates() {
+	templates.reload()
+}
+
+// reload discards all the templates and loads them again.
+func (t *templateStore) reload() {
+	t.Lock()
+	defer t.Unlock()
+	t.template = make(map[string]*template.Template)
+	fs.WalkDir(t.wiki().store, ".", t.loadTemplate)
+	metrics.templateReloaded(len(t.template))
+	slog.Info("Templates reloaded", "dir", t.wiki().root, "count", len(t.template))
+}
+
+// wiki returns the site whose templates are loaded.
+func (t *templateStore) wiki() *site {
+	if t.site == nil {
+		return defaultSite
+	}
+	return t.site
 }
 
 // loadTemplate is used to walk the directory. It loads all the template files it finds, including the ones in
-// subdirectories. This is called with templates already locked.
-func loadTemplate(fp string, info fs.FileInfo, err error) error {
+// subdirectories. The path is a name used by the storage of the site. This is called with templates already locked.
+func (t *templateStore) loadTemplate(fp string, d fs.DirEntry, err error) error {
 	if err != nil {
 		return err
 	}
 	if strings.HasSuffix(fp, ".html") &&
-		slices.Contains(templateFiles, filepath.Base(fp)) {
-		t, err := template.ParseFiles(fp)
+		slices.Contains(templateFiles, path.Base(fp)) {
+		tmpl, err := template.ParseFS(t.wiki().store, fp)
 		if err != nil {
-			log.Println("Cannot parse template:", fp, err)
+			slog.Warn("Cannot parse template", "template", fp, "err", err)
 			// ignore error
 		} else {
-			templates.template[fp] = t
+			t.template[filepath.FromSlash(fp)] = tmpl
 		}
 	}
 	return nil
@@ -64,49 +104,80 @@ func loadTemplate(fp string, info fs.FileInfo, err error) error {
 
 // updateTemplate checks whether this is a valid template file and if so, reloads it.
 func updateTemplate(fp string) {
+	templates.update(fp)
+}
+
+// update checks whether this is a valid template file and if so, reloads it. The filepath is relative to the
+// directory containing the templates.
+func (t *templateStore) update(fp string) {
 	if strings.HasSuffix(fp, ".html") &&
 		slices.Contains(templateFiles, filepath.Base(fp)) {
-		t, err := template.ParseFiles(fp)
+		tmpl, err := template.ParseFS(t.wiki().store, filepath.ToSlash(fp))
 		if err != nil {
-			log.Println("Template:", fp, err)
+			slog.Warn("Cannot parse template", "template", fp, "err", err)
 		} else {
-			templates.Lock()
-			defer templates.Unlock()
-			templates.template[fp] = t
-			log.Println("Parse template:", fp)
+			t.Lock()
+			defer t.Unlock()
+			if t.template == nil {
+				t.template = make(map[string]*template.Template)
+			}
+			t.template[fp] = tmpl
+			metrics.templateReloaded(1)
+			slog.Info("Parse template", "template", fp)
 		}
 	}
 }
 
 // removeTemplate removes a template unless it's a root template because that would result in the site being unusable.
 func removeTemplate(fp string) {
+	templates.remove(fp)
+}
+
+// remove removes a template unless it's a root template because that would result in the site being unusable.
+func (t *templateStore) remove(fp string) {
 	if slices.Contains(templateFiles, filepath.Base(fp)) &&
 		filepath.Dir(fp) != "." {
-		templates.Lock()
-		defer templates.Unlock()
-		delete(templates.template, fp)
-		log.Println("Discard template:", fp)
+		t.Lock()
+		defer t.Unlock()
+		delete(t.template, fp)
+		slog.Info("Discard template", "template", fp)
 	}
 }
 
 // renderTemplate is the helper that is used to render the templates with data.
 // A template in the same directory is preferred, if it exists.
 func renderTemplate(w http.ResponseWriter, dir, tmpl string, data any) {
-	loadTemplates()
-	base := tmpl + ".html"
-	templates.RLock()
-	defer templates.RUnlock()
-	t := templates.template[filepath.Join(dir, base)]
-	if t == nil {
-		t = templates.template[base]
-	}
-	if t == nil {
-		log.Println("Template not found:", base)
+	templates.render(w, dir, tmpl, data)
+}
+
+// render renders a template with data. A template in the same directory is preferred, if it exists.
+func (t *templateStore) render(w http.ResponseWriter, dir, tmpl string, data any) {
+	err := t.execute(w, dir, tmpl, data)
+	if errors.Is(err, errTemplateNotFound) {
 		http.Error(w, "Template not found", http.StatusInternalServerError)
-		return
-	}
-	err := t.Execute(w, data)
-	if err != nil {
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// errTemplateNotFound is returned by [templateStore.execute] if neither the directory nor the root directory have the
+// template.
+var errTemplateNotFound = errors.New("template not found")
+
+// execute executes a template with data, writing the result to any writer. A template in the same directory is
+// preferred, if it exists. See [templateStore.render] for the web server.
+func (t *templateStore) execute(w io.Writer, dir, tmpl string, data any) error {
+	t.load()
+	base := tmpl + ".html"
+	t.RLock()
+	defer t.RUnlock()
+	tt := t.template[filepath.Join(dir, base)]
+	if tt == nil {
+		tt = t.template[base]
+	}
+	if tt == nil {
+		slog.Warn("Template not found", "template", base)
+		return errTemplateNotFound
+	}
+	return tt.Execute(w, data)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"log/slog"
+	"strings"
+)
+
+// getTLSConfig returns the TLS configuration to use, or nil if the wiki is served without TLS. If the environment
+// variables ODDMU_TLS_CERT and ODDMU_TLS_KEY are set, they name the files with the certificate and the private key,
+// in PEM format. If the environment variable ODDMU_ACME_DOMAINS is set, certificates for these comma-separated
+// domains are requested from Let's Encrypt (or the ACME server named by ODDMU_ACME_DIRECTORY) and cached in the
+// directory named by ODDMU_ACME_CACHE (".acme" by default). The optional ODDMU_ACME_EMAIL is the contact address for
+// the account. As only the TLS-ALPN-01 challenge is supported, the wiki must be reachable on port 443.
+func getTLSConfig() (*tls.Config, error) {
+	certFile := setting("tls_cert")
+	keyFile := setting("tls_key")
+	domains := setting("acme_domains")
+	if certFile != "" || keyFile != "" {
+		if domains != "" {
+			return nil, errors.New("ODDMU_TLS_CERT and ODDMU_TLS_KEY cannot be combined with ODDMU_ACME_DOMAINS")
+		}
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("ODDMU_TLS_CERT and ODDMU_TLS_KEY must both be set")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Using certificate", "file", certFile)
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"http/1.1"},
+			MinVersion:   tls.VersionTLS12,
+		}, nil
+	}
+	if domains != "" {
+		m := acmeManager(domains)
+		slog.Info("Using ACME certificates", "domains", domains)
+		config := m.TLSConfig()
+		config.MinVersion = tls.VersionTLS12
+		return config, nil
+	}
+	return nil, nil
+}
+
+// acmeManager returns the manager for ACME certificates for the comma-separated list of domains.
+func acmeManager(domains string) *autocert.Manager {
+	names := []string{}
+	for _, domain := range strings.Split(domains, ",") {
+		domain = strings.TrimSpace(domain)
+		if domain != "" {
+			names = append(names, domain)
+		}
+	}
+	cache := setting("acme_cache")
+	if cache == "" {
+		cache = ".acme"
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(names...),
+		Cache:      autocert.DirCache(cache),
+		Email:      setting("acme_email"),
+	}
+	directory := setting("acme_directory")
+	if directory != "" {
+		m.Client = &acme.Client{DirectoryURL: directory}
+	}
+	return m
+}
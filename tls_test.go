@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// selfSigned returns a self-signed certificate and the private key for the domain, PEM-encoded.
+func selfSigned(t *testing.T, domain string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	b, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+}
+
+func TestTLSConfigNone(t *testing.T) {
+	config, err := getTLSConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+	t.Setenv("ODDMU_TLS_CERT", "cert.pem")
+	_, err = getTLSConfig()
+	assert.Error(t, err)
+}
+
+func TestTLSCertificate(t *testing.T) {
+	cleanup(t, "testdata/tls")
+	assert.NoError(t, os.MkdirAll("testdata/tls", 0755))
+	cert, key := selfSigned(t, "localhost")
+	assert.NoError(t, os.WriteFile("testdata/tls/cert.pem", cert, 0644))
+	assert.NoError(t, os.WriteFile("testdata/tls/key.pem", key, 0600))
+	t.Setenv("ODDMU_TLS_CERT", "testdata/tls/cert.pem")
+	t.Setenv("ODDMU_TLS_KEY", "testdata/tls/key.pem")
+	config, err := getTLSConfig()
+	assert.NoError(t, err)
+	assert.NotNil(t, config)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Secret garden")
+	})}
+	go srv.Serve(tls.NewListener(listener, config))
+	defer srv.Close()
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"}}}
+	res, err := client.Get("https://" + listener.Addr().String() + "/")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "Secret garden", string(body))
+	assert.NotNil(t, res.TLS)
+}
+
+func TestTLSAcme(t *testing.T) {
+	cleanup(t, "testdata/acme")
+	assert.NoError(t, os.MkdirAll("testdata/acme", 0700))
+	// a stand-in ACME server that must not be contacted because the certificate is cached
+	contacted := false
+	acmeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contacted = true
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}))
+	defer acmeServer.Close()
+	cert, key := selfSigned(t, "example.org")
+	assert.NoError(t, os.WriteFile("testdata/acme/example.org", append(key, cert...), 0600))
+	t.Setenv("ODDMU_ACME_DOMAINS", "example.org")
+	t.Setenv("ODDMU_ACME_CACHE", "testdata/acme")
+	t.Setenv("ODDMU_ACME_DIRECTORY", acmeServer.URL)
+	config, err := getTLSConfig()
+	assert.NoError(t, err)
+	c, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.org",
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}})
+	assert.NoError(t, err)
+	assert.Equal(t, "example.org", c.Leaf.Subject.CommonName)
+	assert.False(t, contacted)
+	// other domains are not allowed
+	_, err = config.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	assert.Error(t, err)
+	assert.False(t, contacted)
+}
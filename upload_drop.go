@@ -4,6 +4,8 @@ package main
 // This is why we import heic for side effects. For writing, the particular encoders have to be imported.
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/disintegration/imaging"
@@ -13,11 +15,11 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"mime"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -33,6 +35,9 @@ type Upload struct {
 	MaxWidth string
 	Quality  string
 	Uploads  []FileUpload
+
+	// site is the site the files are uploaded to. If nil, it's the default site.
+	site *site
 }
 
 type FileUpload struct {
@@ -47,7 +52,8 @@ var baseRe = regexp.MustCompile(`^(.*?)-[0-9]+$`)
 // parameters are used to copy name, maxwidth and quality from the previous upload. If the previous name contains a
 // number, this is incremented by one.
 func uploadHandler(w http.ResponseWriter, r *http.Request, dir string) {
-	data := &Upload{Dir: pathEncode(dir)}
+	s := siteOf(r)
+	data := &Upload{Dir: pathEncode(dir), site: s}
 	var err error
 	maxwidth := r.FormValue("maxwidth")
 	if maxwidth != "" {
@@ -65,7 +71,7 @@ func uploadHandler(w http.ResponseWriter, r *http.Request, dir string) {
 	if filename == "" {
 		filename = "image-1.jpg"
 	}
-	filename, err = next(filepath.FromSlash(dir), filename, 0)
+	filename, err = next(s.store, storagePath(dir), filename, 0)
 	if err != nil {
 		http.Error(w, "cannot determine filename", http.StatusInternalServerError)
 		return
@@ -82,13 +88,13 @@ func uploadHandler(w http.ResponseWriter, r *http.Request, dir string) {
 		data.Name = basename(filename)
 	}
 	data.Uploads = make([]FileUpload, len(r.Form["uploads"]))
-	for i, s := range r.Form["uploads"] {
-		data.Uploads[i].Name = s
-		mimeType := mime.TypeByExtension(path.Ext(s))
+	for i, u := range r.Form["uploads"] {
+		data.Uploads[i].Name = u
+		mimeType := mime.TypeByExtension(path.Ext(u))
 		data.Uploads[i].Image = strings.HasPrefix(mimeType, "image/")
 
 	}
-	renderTemplate(w, dir, "upload", data)
+	s.templates.render(w, dir, "upload", data)
 }
 
 // next returns the next filename for a filename containing a number. The last number is identified using lastRe. This
@@ -96,11 +102,12 @@ func uploadHandler(w http.ResponseWriter, r *http.Request, dir string) {
 // increased by one. Thus, when called with "image-1.jpg", 0 the string returned will be "image-1.jpg" if no such file
 // exists. If "image-1.jpg" exists but "image-2.jpg" does not, then that is returned. When called with "image.jpg"
 // (containing no number) and the file does not exist, it is returned unchanged. If it exists, "image-1.jpg" is assumed
-// and the algorithm described previously is used to find the next unused filename.
-func next(dir, fn string, i int) (string, error) {
+// and the algorithm described previously is used to find the next unused filename. The directory is a name used by
+// the file system.
+func next(fsys fs.FS, dir, fn string, i int) (string, error) {
 	m := lastRe.FindStringSubmatch(fn)
 	if m == nil {
-		_, err := os.Stat(filepath.Join(dir, fn))
+		_, err := fs.Stat(fsys, path.Join(dir, fn))
 		if err != nil {
 			return fn, nil
 		}
@@ -113,7 +120,7 @@ func next(dir, fn string, i int) (string, error) {
 		n += i
 		for {
 			s := m[1] + strconv.Itoa(n) + m[3]
-			_, err = os.Stat(filepath.Join(dir, s))
+			_, err = fs.Stat(fsys, path.Join(dir, s))
 			if err != nil {
 				return s, nil
 			}
@@ -127,9 +134,9 @@ func next(dir, fn string, i int) (string, error) {
 // is redirected to the view of that file. Some errors are for the users and some are for users and the admins. Those
 // later errors are printed, too.
 func dropHandler(w http.ResponseWriter, r *http.Request, dir string) {
-	dir = filepath.FromSlash(dir)
-	// ensure the directory exists and that "" results in "."
-	fi, err := os.Stat(filepath.Clean(dir))
+	s := siteOf(r)
+	// ensure the directory exists
+	fi, err := s.store.stat(storagePath(dir))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -149,6 +156,10 @@ func dropHandler(w http.ResponseWriter, r *http.Request, dir string) {
 		http.Error(w, "the file would be hidden", http.StatusForbidden)
 		return
 	}
+	// the file may be one that requires a different login
+	if !checkAuth(w, r, "drop", path.Join(dir, fn)) {
+		return
+	}
 	data.Set("filename", fn)
 	pn := r.FormValue("pagename")
 	if pn != "" {
@@ -189,8 +200,25 @@ func dropHandler(w http.ResponseWriter, r *http.Request, dir string) {
 	// the destination image format is determined by the extension
 	to := strings.ToLower(path.Ext(fn))
 	first := true
+	if r.MultipartForm == nil {
+		http.Error(w, "no files were uploaded", http.StatusBadRequest)
+		return
+	}
+	username := author(r, path.Join(dir, fn))
+	store := authored(s.store, username)
 	for _, fhs := range r.MultipartForm.File["file"] {
-		log.Println("Reading", fhs.Filename)
+		// only the first file replaces an existing file
+		replaced := ""
+		if first {
+			replaced = path.Join(storagePath(dir), fn)
+		}
+		err = s.checkUploadSize(filepath.FromSlash(dir), replaced, fhs.Size)
+		if err != nil {
+			slog.Warn("Upload refused", "file", fhs.Filename, "dir", dir, "err", err)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		slog.Debug("Reading", "file", fhs.Filename)
 		file, err := fhs.Open()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -199,30 +227,19 @@ func dropHandler(w http.ResponseWriter, r *http.Request, dir string) {
 		defer file.Close()
 		// the first filename overwrites!
 		if !first {
-			fn, err = next(dir, fn, 1)
+			fn, err = next(s.store, storagePath(dir), fn, 1)
 			if err != nil {
-				log.Println(err)
+				slog.Error("Upload failed", "dir", dir, "err", err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 		}
 		first = false
-		fp := filepath.Join(dir, fn)
-		watches.ignore(fp)
-		err = backup(fp)
-		if err != nil {
-			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		log.Println("Creating", fp)
-		dst, err := os.Create(fp)
-		if err != nil {
-			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		defer dst.Close()
+		name := path.Join(storagePath(dir), fn)
+		fp := filepath.FromSlash(name)
+		s.watches.ignore(fp)
+		// the uploaded file is copied unless it is an image that needs to be converted
+		var src io.Reader = file
 		// the source image format is determined by the extension
 		from := strings.ToLower(filepath.Ext(fhs.Filename))
 		if q != 75 || mw > 0 || from != to {
@@ -232,7 +249,7 @@ func dropHandler(w http.ResponseWriter, r *http.Request, dir string) {
 				http.Error(w, "The image could not be decoded from "+from+" format", http.StatusBadRequest)
 				return
 			}
-			log.Println("Decoded", fmt, "file")
+			slog.Debug("Decoded", "format", fmt)
 			if mw > 0 {
 				res := imaging.Resize(img, mw, 0, imaging.Lanczos) // preserve aspect ratio
 				// imaging functions don't return errors but empty images…
@@ -241,51 +258,63 @@ func dropHandler(w http.ResponseWriter, r *http.Request, dir string) {
 				}
 			}
 			// images are always reencoded, so image quality goes down
+			var buf bytes.Buffer
 			switch to {
 			case ".png":
-				err = png.Encode(dst, img)
+				err = png.Encode(&buf, img)
 			case ".jpg", ".jpeg":
-				err = jpeg.Encode(dst, img, &jpeg.Options{Quality: q})
+				err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: q})
 			case ".webp":
-				err = webp.Encode(dst, img, webp.Options{Quality: q}) // Quality of 100 implies Lossless.
+				err = webp.Encode(&buf, img, webp.Options{Quality: q}) // Quality of 100 implies Lossless.
 			default:
 				err = errors.New("Unsupported destination format for image conversion: " + to)
 			}
 			if err != nil {
-				log.Println(err)
+				slog.Error("Upload failed", "file", name, "err", err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			log.Println("Encoded", to, fp)
+			slog.Debug("Encoded", "format", to, "file", name)
+			src = &buf
+		}
+		// if there are zero bytes to write, delete the file instead
+		in := bufio.NewReader(src)
+		_, err = in.Peek(1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			slog.Error("Upload failed", "file", name, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if errors.Is(err, io.EOF) {
+			_, err := s.store.stat(name)
+			if err == nil {
+				err = s.deleteFile(name, username)
+				if err != nil {
+					slog.Error("Upload failed", "file", name, "err", err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				slog.Info("Deleted", "file", name)
+			}
 		} else {
-			// just copy the bytes
-			n, err := io.Copy(dst, file)
+			err = s.backup(name)
 			if err != nil {
-				log.Println(err)
+				slog.Error("Upload failed", "file", name, "err", err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			// if zero bytes were copied, delete the file instead
-			if n == 0 {
-				err := os.Remove(fp)
-				if err != nil {
-					log.Println(err)
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				log.Println("Deleted", fp)
-			} else {
-				log.Println("Copied", fp)
+			slog.Debug("Creating", "file", name)
+			err = store.writeFrom(name, in)
+			if err != nil {
+				slog.Error("Upload failed", "file", name, "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 		}
 		data.Add("uploads", fn)
-		username, _, ok := r.BasicAuth()
-		if ok {
-			log.Println("Saved", filepath.ToSlash(fp), "by", username)
-		} else {
-			log.Println("Saved", filepath.ToSlash(fp))
-		}
-		updateTemplate(fp)
+		slog.Info("Saved", "file", name, "user", username)
+		metrics.uploaded()
+		s.templates.update(fp)
 	}
 	http.Redirect(w, r, "/upload/"+nameEscape(dir)+"?"+data.Encode(), http.StatusFound)
 }
@@ -316,10 +345,14 @@ func (f *FileUpload) Path() string {
 
 // Title returns the title of the matching page. If the page does not exist, the page name is returned.
 func (u *Upload) Title() string {
-	index.RLock()
-	defer index.RUnlock()
+	idx := defaultSite.index
+	if u.site != nil {
+		idx = u.site.index
+	}
+	idx.RLock()
+	defer idx.RUnlock()
 	name := path.Join(u.Dir, u.Name)
-	title, ok := index.titles[name]
+	title, ok := idx.titles[name]
 	if ok {
 		return title
 	}
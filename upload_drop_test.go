@@ -323,7 +323,7 @@ func TestUploadNext(t *testing.T) {
 	nm := "test-1.jpg"
 	var err error
 	for i := 0; i < 25; i++ {
-		nm, err = next("testdata/next", nm, 0)
+		nm, err = next(defaultSite.store, "testdata/next", nm, 0)
 		assert.NoError(t, err)
 		s = append(s, nm)
 		os.Create("testdata/next/" + nm)
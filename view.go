@@ -5,11 +5,9 @@ import (
 	"io"
 	"mime"
 	"net/http"
-	"os"
 	"path"
-	"path/filepath"
-	"strings"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -25,12 +23,15 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 
 // viewHandler serves pages. If the requested URL ends in ".rss" and the corresponding file ending with ".md" exists, a
 // feed is generated and the "feed.html" template is used (it is used to generate a RSS 2.0 feed, even if the extension
-// is ".html"). If the requested URL maps to a page name, the corresponding file (by appending ".md") is loaded and
-// served using the "view.html" template. If the requested URL maps to an existing file, it is served (you can therefore
-// request the ".md" files directly). If the requested URL maps to a directory, the browser is redirected to the index
-// page. If none of the above, the browser is redirected to an edit page.
+// is ".html"). If the requested URL ends in ".atom", the "atom.html" template is used to generate an Atom feed. If the
+// requested URL ends in ".json", a JSON Feed is generated without a template. If the requested URL maps to a page name,
+// the corresponding file (by appending ".md") is loaded and served using the "view.html" template. If the requested URL
+// maps to an existing file, it is served (you can therefore request the ".md" files directly) with the headers set by
+// fileHeaders. If the requested URL maps to a directory, the browser is redirected to the index page or, if there is
+// no index page, to the list of files. If none of the above, the browser is redirected to an edit page.
 //
-// Uploading files ending in ".rss" does not prevent RSS feed generation.
+// Uploading files ending in ".rss", ".atom" or ".json" does not prevent feed generation. These files are only served if
+// no corresponding page exists.
 //
 // Caching: a 304 NOT MODIFIED is returned if the request has an If-Modified-Since header that matches the file's
 // modification time, truncated to one second. Truncation is required because the file's modtime has sub-second
@@ -40,38 +41,41 @@ func viewHandler(w http.ResponseWriter, r *http.Request, name string) {
 		unknown = iota
 		file
 		page
-		rss
+		syndication
 		dir
 	)
 	t := unknown
-	if strings.HasSuffix(name, ".rss") {
-		name = name[:len(name)-4]
-		t = rss
+	ext := path.Ext(name)
+	format := strings.TrimPrefix(ext, ".")
+	if _, ok := feedFormats[format]; ok {
+		name = name[:len(name)-len(ext)]
+		t = syndication
 	}
-	fp := filepath.FromSlash(name)
-	fi, err := os.Stat(fp + ".md")
+	s := siteOf(r)
+	// "dir/" is not the page "dir" but "dir/.md" is never found since it is hidden
+	fi, err := s.store.stat(name + ".md")
 	if err == nil {
 		if fi.IsDir() {
 			t = dir // directory ending in ".md"
 		} else if t == unknown {
 			t = page
 		}
-		// otherwise t == rss
+		// otherwise t == syndication
 	} else {
-		if t == rss {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-		if fp == "" {
-			fp = "." // make sure Stat works
+		if t == syndication {
+			// maybe it's an uploaded file
+			name += ext
 		}
-		fi, err = os.Stat(fp)
+		fi, err = s.store.stat(storagePath(name))
 		if err == nil {
 			if fi.IsDir() {
 				t = dir
 			} else {
 				t = file
 			}
+		} else if t == syndication {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
 		}
 	}
 	// if nothing was found, offer to create it
@@ -79,8 +83,13 @@ func viewHandler(w http.ResponseWriter, r *http.Request, name string) {
 		http.Redirect(w, r, path.Join("/edit", nameEscape(name)), http.StatusFound)
 		return
 	}
-	// directories are redirected to the index page
+	// directories are redirected to the index page, if it exists, or to the list of files
 	if t == dir {
+		_, err := s.store.stat(path.Join(storagePath(name), "index.md"))
+		if err != nil {
+			http.Redirect(w, r, path.Join("/list", nameEscape(name))+"/", http.StatusFound)
+			return
+		}
 		http.Redirect(w, r, path.Join("/view", nameEscape(name), "index"), http.StatusFound)
 		return
 	}
@@ -101,13 +110,14 @@ func viewHandler(w http.ResponseWriter, r *http.Request, name string) {
 	}
 	// if the file exists, serve it
 	if t == file {
-		file, err := os.Open(fp)
+		file, err := s.store.Open(storagePath(name))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		defer func() { file.Close() }()
 		// set MIME type by extension or by sniffing
-		mimeType := mime.TypeByExtension(filepath.Ext(fp))
+		mimeType := mime.TypeByExtension(path.Ext(name))
 		if mimeType == "" {
 			mtype, err := mimetype.DetectReader(file)
 			if err != nil {
@@ -115,9 +125,16 @@ func viewHandler(w http.ResponseWriter, r *http.Request, name string) {
 				return
 			}
 			mimeType = mtype.String()
+			// start over
+			file.Close()
+			file, err = s.store.Open(storagePath(name))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 		}
 		w.Header().Set("Content-Type", mimeType)
-		file.Seek(0, io.SeekStart)
+		fileHeaders(w, s, mimeType)
 		// copy file
 		_, err = io.Copy(w, file)
 		if err != nil {
@@ -126,13 +143,13 @@ func viewHandler(w http.ResponseWriter, r *http.Request, name string) {
 		}
 		return
 	}
-	p, err := loadPage(name)
+	p, err := s.loadPage(name)
 	if err != nil {
 		http.Redirect(w, r, path.Join("/edit", nameEscape(name)), http.StatusFound)
 		return
 	}
 	p.handleTitle(true)
-	if t == rss {
+	if t == syndication {
 		from, err := strconv.Atoi(r.FormValue("from"))
 		if err != nil {
 			from = 0
@@ -141,11 +158,30 @@ func viewHandler(w http.ResponseWriter, r *http.Request, name string) {
 		if err != nil {
 			n = 10
 		}
-		it := feed(p, fi.ModTime(), from, n)
-		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>`))
-		renderTemplate(w, p.Dir(), "feed", it)
+		renderFeed(w, r, p, fi.ModTime(), from, n, format)
 		return
 	}
 	p.renderHtml()
-	renderTemplate(w, p.Dir(), "view", p)
+	s.templates.render(w, p.Dir(), "view", p)
+}
+
+// renderFeed renders the feed for a page in the given format ("rss", "atom" or "json"). The RSS and Atom feeds use the
+// "feed.html" and "atom.html" templates, respectively.
+func renderFeed(w http.ResponseWriter, r *http.Request, p *Page, ti time.Time, from, n int, format string) {
+	f := feed(p, ti, from, n, feedOptionsFor(r))
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/feed+json")
+		err := writeJsonFeed(w, f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>`))
+		p.wiki().templates.render(w, p.Dir(), "atom", f)
+	default:
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>`))
+		p.wiki().templates.render(w, p.Dir(), "feed", f)
+	}
 }
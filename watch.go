@@ -3,7 +3,7 @@ package main
 import (
 	"github.com/fsnotify/fsnotify"
 	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"slices"
@@ -36,6 +36,10 @@ type watchStore struct {
 	// watcher is the pointer to the actual watcher doing the file system watching. It watches a set of paths.
 	// Whenever Oddmu creates a new subdirectory, it adds the path for this subdirectory to the watcher.
 	watcher *fsnotify.Watcher
+
+	// site is the site being watched. The filepaths in files and ignores are relative to its root directory. The
+	// site's templates are reloaded and its index is updated.
+	site *site
 }
 
 var watches watchStore
@@ -51,11 +55,11 @@ func (w *watchStore) install() (int, error) {
 	var err error
 	w.watcher, err = fsnotify.NewWatcher()
 	if err != nil {
-		log.Println("Creating a watcher for file changes:", err)
+		slog.Error("Creating a watcher for file changes failed", "err", err)
 		return 0, err
 	}
 	go w.watch()
-	err = filepath.Walk(".", w.add)
+	err = filepath.Walk(w.site.root, w.add)
 	if err != nil {
 		return 0, err
 	}
@@ -68,12 +72,12 @@ func (w *watchStore) add(fp string, info fs.FileInfo, err error) error {
 		return err
 	}
 	if info.IsDir() {
-		if fp != "." && strings.HasPrefix(filepath.Base(fp), ".") {
+		if fp != w.site.root && strings.HasPrefix(filepath.Base(fp), ".") {
 			return filepath.SkipDir
 		}
 		err := w.watcher.Add(fp)
 		if err != nil {
-			log.Println("Cannot add watch:", fp)
+			slog.Error("Cannot add watch", "dir", fp, "err", err)
 			return err
 		}
 	}
@@ -93,7 +97,7 @@ func (w *watchStore) watch() {
 			if !ok {
 				return
 			}
-			log.Println("Watcher:", err)
+			slog.Warn("Watcher", "err", err)
 		case e, ok := <-w.watcher.Events:
 			if !ok {
 				return
@@ -107,13 +111,18 @@ func (w *watchStore) watch() {
 // the creation of pages and directories (immediately). Files and directories starting with a dot are skipped.
 // Incidentally, this also prevents rsync updates from generating activity ("stat ./.index.md.tTfPFg: no such file or
 // directory"). Note the painful details: If moving a file into a watched directory, a Create event is received. If a
-// new file is created in a watched directory, a Create event and one or more Write events is received.
+// new file is created in a watched directory, a Create event and one or more Write events is received. The filepaths of
+// the events are made relative to the root directory of the site.
 func (w *watchStore) watchHandle(e fsnotify.Event) {
-	fp := strings.TrimPrefix(e.Name, "./")
+	fp, err := filepath.Rel(w.site.root, e.Name)
+	if err != nil {
+		slog.Warn("Watcher", "err", err)
+		return
+	}
 	if strings.HasPrefix(filepath.Base(fp), ".") {
 		return
 	}
-	// log.Println(e)
+	// slog.Debug("Event", "event", e)
 	w.Lock()
 	defer w.Unlock()
 	if e.Op.Has(fsnotify.Create|fsnotify.Write) &&
@@ -131,13 +140,13 @@ func (w *watchStore) watchHandle(e fsnotify.Event) {
 	} else if e.Op.Has(fsnotify.Rename | fsnotify.Remove) {
 		w.watchDoRemove(fp)
 	} else if e.Op.Has(fsnotify.Create) &&
-		!slices.Contains(w.watcher.WatchList(), fp) {
-		fi, err := os.Stat(fp)
+		!slices.Contains(w.watcher.WatchList(), e.Name) {
+		fi, err := os.Stat(e.Name)
 		if err != nil {
-			log.Println(err)
+			slog.Warn("Watcher", "err", err)
 		} else if fi.IsDir() {
-			log.Println("Add watch for", fp)
-			w.watcher.Add(fp)
+			slog.Info("Add watch", "dir", e.Name)
+			w.watcher.Add(e.Name)
 		}
 	}
 }
@@ -160,24 +169,24 @@ func (w *watchStore) watchDoUpdate(fp string) {
 	if ignored {
 		return
 	} else if strings.HasSuffix(fp, ".html") {
-		updateTemplate(fp)
+		w.site.templates.update(fp)
 	} else if strings.HasSuffix(fp, ".md") {
-		p, err := loadPage(fp[:len(fp)-3]) // page name without ".md"
+		p, err := w.site.loadPage(filepath.ToSlash(fp[:len(fp)-3])) // page name without ".md"
 		if err != nil {
-			log.Println("Cannot load page", fp)
+			slog.Warn("Cannot load page", "file", fp, "err", err)
 		} else {
-			log.Println("Update index for", fp)
-			index.update(p)
+			slog.Info("Update index", "file", fp)
+			w.site.index.update(p)
 		}
-	} else if !slices.Contains(w.watcher.WatchList(), fp) {
-		fi, err := os.Stat(fp)
+	} else if !slices.Contains(w.watcher.WatchList(), w.site.join(fp)) {
+		fi, err := os.Stat(w.site.join(fp))
 		if err != nil {
-			log.Println(err)
+			slog.Warn("Watcher", "err", err)
 			return
 		}
 		if fi.IsDir() {
-			log.Println("Add watch for", fp)
-			w.watcher.Add(fp)
+			slog.Info("Add watch", "dir", fp)
+			w.watcher.Add(w.site.join(fp))
 		}
 	}
 }
@@ -189,18 +198,32 @@ func (w *watchStore) watchDoRemove(fp string) {
 	if ignored {
 		return
 	} else if strings.HasSuffix(fp, ".html") {
-		removeTemplate(fp)
+		w.site.templates.remove(fp)
 	} else if strings.HasSuffix(fp, ".md") {
-		_, err := os.Stat(fp)
+		_, err := os.Stat(w.site.join(fp))
 		if err == nil {
-			log.Println("Cannot remove existing page from the index", fp)
+			slog.Warn("Cannot remove existing page from the index", "file", fp)
 		} else {
-			log.Println("Deindex", fp)
-			index.deletePageName(fp[:len(fp)-3]) // page name without ".md"
+			slog.Info("Deindex", "file", fp)
+			w.site.index.deletePageName(filepath.ToSlash(fp[:len(fp)-3])) // page name without ".md"
 		}
 	}
 }
 
+// flush handles all the files still waiting for their timer, right now, and closes the watcher. This is called when
+// the server shuts down.
+func (w *watchStore) flush() {
+	w.Lock()
+	defer w.Unlock()
+	for fp := range w.files {
+		delete(w.files, fp)
+		w.watchDoUpdate(fp)
+	}
+	if w.watcher != nil {
+		w.watcher.Close()
+	}
+}
+
 // ignore is before code that is known suspected save files and trigger watchHandle eventhough the code already handles
 // this. This is achieved by adding the path to the ignores map for 1s.
 func (w *watchStore) ignore(fp string) {
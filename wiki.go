@@ -5,11 +5,13 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/google/subcommands"
 	"io/fs"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -44,7 +46,7 @@ func isHiddenName(name string) bool {
 // URL like /upload/ is OK. The argument can also be provided using a form parameter, i.e. call /edit/?id=foo/bar. The
 // handle itself is called with the remaining URL path fragment. Any path segment beginning with a period is rejected
 // because it's considered to be a hidden file or directory. This also takes care of path traversal since ".." is
-// treated the same.
+// treated the same. Actions that change files may require a login; see [checkAuth].
 func makeHandler(fn func(http.ResponseWriter, *http.Request, string), required bool, methods ...string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		validMethod := false
@@ -84,6 +86,9 @@ func makeHandler(fn func(http.ResponseWriter, *http.Request, string), required b
 		}
 		// handle /action/ or /action/page
 		if !required || len(name) > 0 {
+			if !checkAuth(w, r, m[1], name) {
+				return
+			}
 			fn(w, r, name)
 			return
 		}
@@ -93,7 +98,7 @@ func makeHandler(fn func(http.ResponseWriter, *http.Request, string), required b
 
 // getPort returns the environment variable ODDMU_PORT or the default port, "8080".
 func getPort() string {
-	port := os.Getenv("ODDMU_PORT")
+	port := setting("port")
 	if port == "" {
 		port = "8080"
 	}
@@ -109,7 +114,7 @@ func getPort() string {
 // If ODDMU_ADDRESS is unspecified, then the
 // listener listens on all available unicast addresses, both IPv4 and IPv6.
 func getListener() (net.Listener, error) {
-	address := os.Getenv("ODDMU_ADDRESS")
+	address := setting("address")
 	port := getPort()
 
 	stat, err := os.Stdin.Stat()
@@ -119,58 +124,63 @@ func getListener() (net.Listener, error) {
 	if stat.Mode().Type() == fs.ModeSocket {
 		// Listening socket passed on stdin, through systemd socket
 		// activation or similar:
-		log.Println("Serving a wiki on a listening socket passed by systemd.")
+		slog.Info("Serving a wiki on a listening socket passed by systemd")
 		return net.FileListener(os.Stdin)
 	}
+	address = listenAddress(address, port)
+	slog.Info("Serving a wiki", "address", address)
+	return net.Listen("tcp", address)
+}
+
+// listenAddress returns the address to listen on for an address, which may be an IPv4 address, an IPv6 address or
+// empty, and a port.
+func listenAddress(address, port string) string {
 	if strings.ContainsRune(address, ':') {
-		address = fmt.Sprintf("[%s]:%s", address, port)
-	} else {
-		address = fmt.Sprintf("%s:%s", address, port)
+		return fmt.Sprintf("[%s]:%s", address, port)
 	}
-	log.Printf("Serving a wiki at address %s", address)
-	return net.Listen("tcp", address)
+	return fmt.Sprintf("%s:%s", address, port)
 }
 
-// scheduleLoadIndex calls index.load and prints some messages before and after. For testing, call index.load directly
-// and skip the messages.
+// scheduleLoadIndex calls index.load for all the sites and prints some messages before and after. For testing, call
+// index.load directly and skip the messages.
 func scheduleLoadIndex() {
-	log.Print("Indexing pages")
-	n, err := index.load()
-	if err == nil {
-		log.Printf("Indexed %d pages", n)
-	} else {
-		log.Printf("Indexing failed: %s", err)
+	for _, s := range hostSites.all() {
+		slog.Info("Indexing pages", "dir", s.root)
+		n, err := s.index.load()
+		if err == nil {
+			slog.Info("Indexed pages", "dir", s.root, "count", n)
+		} else {
+			slog.Error("Indexing failed", "dir", s.root, "err", err)
+		}
 	}
 }
 
 // scheduleLoadLanguages calls loadLanguages and prints some messages before and after. For testing, call loadLanguages
 // directly and skip the messages.
 func scheduleLoadLanguages() {
-	log.Print("Loading languages")
+	slog.Info("Loading languages")
 	n := loadLanguages()
-	log.Printf("Loaded %d languages", n)
+	slog.Info("Loaded languages", "count", n)
 }
 
-// scheduleInstallWatcher calls watches.install and prints some messages before and after. For testing, call watch.init
-// directly and skip the messages.
+// scheduleInstallWatcher calls watches.install for all the sites and prints some messages before and after. For
+// testing, call watch.init directly and skip the messages.
 func scheduleInstallWatcher() {
-	log.Print("Installing watcher")
-	n, err := watches.install()
-	if err == nil {
-		if n == 1 {
-			log.Println("Installed watchers for one directory")
+	for _, s := range hostSites.all() {
+		slog.Info("Installing watcher", "dir", s.root)
+		n, err := s.watches.install()
+		if err == nil {
+			slog.Info("Installed watchers", "dir", s.root, "dirs", n)
 		} else {
-			log.Printf("Installed watchers for %d directories", n)
+			slog.Error("Installing watcher failed", "dir", s.root, "err", err)
 		}
-	} else {
-		log.Printf("Installing watcher failed: %s", err)
 	}
 }
 
-// serve starts the web server using [http.Serve]. The listener is determined via [getListener]. The various handlers
-// are created using [makeHandler] if their path starts with an action segment. For example, the URL path "/view/index"
-// is understood to contain the "view" action and so [viewHandler] is called with the argument "index". The one handler
-// that doesn't need this is [rootHandler].
+// serve starts the web server using [http.Serve]. The listener is determined via [getListener] and uses TLS if
+// [getTLSConfig] says so. The various handlers are created using [makeHandler] if their path starts with an action
+// segment. For example, the URL path "/view/index" is understood to contain the "view" action and so [viewHandler] is
+// called with the argument "index". The one handler that doesn't need this is [rootHandler].
 //
 // The handlers often come in pairs. One handler to show the user interface and one handler to make the change:
 //   - [editHandler] shows the edit form and [saveHandler] saves changes to a page
@@ -180,42 +190,92 @@ func scheduleInstallWatcher() {
 // Some handlers only do something and the links or forms to call them is expected to be part of the view template:
 //   - [archiveHandler] zips up the current directory
 //   - [diffHandler] shows the changes made in the last 60min to a page
+//   - [historyHandler] shows the commits that changed a page if the git storage is used
 //   - [searchHandler] shows search results
 //
+// The handlers that change files are wrapped in [limit] to enforce rate limits and size limits. All the responses get
+// security headers via [securityHeaders].
+//
 // At the same time as the server starts up, pages are indexed via [scheduleLoadIndex], languages are loaded via
 // [scheduleLoadLanguages] and the current directory and its subdirectories is watched for changes using watchers
-// installed via [scheduleInstallWatcher].
+// installed via [scheduleInstallWatcher]. Once all of these are done, the server is ready; see [readyHandler]. If the
+// environment variable ODDMU_METRICS is set, metrics are available via [metricsHandler]. Every request is logged by
+// [accessLog]. Signals are handled by [handleSignals]. If the environment variable ODDMU_DAV is set, the pages and files
+// are also available via WebDAV; see [davHandler].
+//
+// If the environment variable ODDMU_HOSTS is set, several sites are served by the same process: the Host header of a
+// request determines the site using [virtualHosts] and the sites are loaded via [siteStore.load]. Every site has its
+// own index, templates, watches and configuration.
+//
+// If the environment variable ODDMU_GEMINI_PORT is set, the pages, the search and the feeds are also served via
+// Gemini; see [serveGemini].
 func serve() {
 	listener, err := getListener()
 	if listener == nil {
-		log.Println(err)
+		slog.Error("Cannot listen", "err", err)
 		return
 	}
-	go scheduleLoadIndex()
-	go scheduleLoadLanguages()
-	go scheduleInstallWatcher()
+	config, err := getTLSConfig()
+	if err != nil {
+		slog.Error("Cannot configure TLS", "err", err)
+		return
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	for _, s := range hostSites.load() {
+		slog.Info("Serving a site", "dir", s.root)
+	}
+	if setting("gemini_port") != "" {
+		gemini, err := getGeminiListener(config)
+		if err != nil {
+			slog.Error("Cannot serve Gemini", "err", err)
+			return
+		}
+		defer gemini.Close()
+		go serveGemini(gemini)
+	}
+	go startup(scheduleLoadIndex, scheduleLoadLanguages, scheduleInstallWatcher)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", rootHandler)
 	mux.HandleFunc("/archive/", makeHandler(archiveHandler, true, http.MethodGet))
 	mux.HandleFunc("/view/", makeHandler(viewHandler, false, http.MethodGet, http.MethodHead))
 	mux.HandleFunc("/preview/", makeHandler(previewHandler, false, http.MethodGet, http.MethodPost))
 	mux.HandleFunc("/diff/", makeHandler(diffHandler, true, http.MethodGet))
+	mux.HandleFunc("/history/", makeHandler(historyHandler, true, http.MethodGet))
 	mux.HandleFunc("/edit/", makeHandler(editHandler, true, http.MethodGet))
-	mux.HandleFunc("/save/", makeHandler(saveHandler, true, http.MethodPost))
+	mux.HandleFunc("/save/", limit(makeHandler(saveHandler, true, http.MethodPost)))
 	mux.HandleFunc("/add/", makeHandler(addHandler, true, http.MethodGet))
-	mux.HandleFunc("/append/", makeHandler(appendHandler, true, http.MethodPost))
+	mux.HandleFunc("/append/", limit(makeHandler(appendHandler, true, http.MethodPost)))
 	mux.HandleFunc("/upload/", makeHandler(uploadHandler, false, http.MethodGet))
-	mux.HandleFunc("/drop/", makeHandler(dropHandler, false, http.MethodPost))
+	mux.HandleFunc("/drop/", limit(makeHandler(dropHandler, false, http.MethodPost)))
 	mux.HandleFunc("/search/", makeHandler(searchHandler, false, http.MethodGet, http.MethodPost))
+	mux.HandleFunc("/list/", makeHandler(listHandler, false, http.MethodGet))
+	mux.HandleFunc("/delete/", limit(makeHandler(deleteHandler, true, http.MethodPost)))
+	mux.HandleFunc("/rename/", limit(makeHandler(renameHandler, true, http.MethodPost)))
+	mux.HandleFunc("/dav/", davHandler)
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/readyz", readyHandler)
+	if setting("metrics") != "" {
+		mux.HandleFunc("/metrics", metricsHandler)
+	}
 	srv := &http.Server{
 		ReadTimeout:  2 * time.Minute,
 		WriteTimeout: 5 * time.Minute,
 		IdleTimeout:  2 * time.Minute,
-		Handler:      mux,
+		Handler:      virtualHosts(accessLog(securityHeaders(measure(mux)))),
 	}
+	c := notifySignals()
+	done := make(chan struct{})
+	go func() {
+		handleSignals(srv, c)
+		close(done)
+	}()
 	err = srv.Serve(listener)
-	if err != nil {
-		log.Println(err)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-done
+	} else if err != nil {
+		slog.Error("Serving failed", "err", err)
 	}
 }
 
@@ -225,10 +285,15 @@ func commands() {
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
+	subcommands.Register(&bookCmd{}, "")
+	subcommands.Register(&catCmd{}, "")
+	subcommands.Register(&configCmd{}, "")
 	subcommands.Register(&exportCmd{}, "")
+	subcommands.Register(&geminiCmd{}, "")
 	subcommands.Register(&hashtagsCmd{}, "")
 	subcommands.Register(&feedCmd{}, "")
 	subcommands.Register(&htmlCmd{}, "")
+	subcommands.Register(&importCmd{}, "")
 	subcommands.Register(&listCmd{}, "")
 	subcommands.Register(&linksCmd{}, "")
 	subcommands.Register(&missingCmd{}, "")
@@ -239,15 +304,23 @@ func commands() {
 	subcommands.Register(&tocCmd{}, "")
 	subcommands.Register(&versionCmd{}, "")
 
-	flag.Parse()
 	ctx := context.Background()
 	os.Exit(int(subcommands.Execute(ctx)))
 }
 
-// main runs [serve] if called without arguments and it runs [commands] if called with arguments.
-// The first argument is the subcommand.
+// main parses the global options, sets the root directory via [setRoot] and sets up logging via [initLogging], then it
+// runs [serve] if called without arguments and it runs [commands] if called with arguments. The first argument is the
+// subcommand.
 func main() {
-	if len(os.Args) == 1 {
+	root := flag.String("root", "", "the root directory of the wiki, defaults to ODDMU_ROOT or the working directory")
+	flag.Parse()
+	err := setRoot(*root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	initLogging()
+	if flag.NArg() == 0 {
 		serve()
 	} else {
 		commands()
@@ -3,11 +3,11 @@ package main
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
-	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
@@ -130,7 +130,7 @@ func minimalIndex(t *testing.T) *indexStore {
 		assert.NoError(t, err)
 		idx.addPage(p)
 	}
-	err := filepath.Walk("themes", idx.walk)
+	err := fs.WalkDir(os.DirFS("."), "themes", idx.walk)
 	assert.NoError(t, err)
 	return idx
 }